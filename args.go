@@ -0,0 +1,149 @@
+package kubeapply
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// subcommand of kubectl.
+type subcommand struct {
+	// maxArgs is the maximum number of positional arguments, or -1 if unlimited.
+	maxArgs int
+
+	// noOutput is set for subcommands that don't support --output=json.
+	noOutput bool
+}
+
+// subcommands of kubectl with their positional arguments.
+// Positional arguments are refused for subcommands not listed here.
+var subcommands = map[string]subcommand{
+	"apply":     {maxArgs: 0},
+	"diff":      {maxArgs: 0, noOutput: true},
+	"kustomize": {maxArgs: 0, noOutput: true},
+
+	"get":      {maxArgs: -1},
+	"describe": {maxArgs: -1, noOutput: true},
+	"delete":   {maxArgs: -1, noOutput: true},
+	"wait":     {maxArgs: -1},
+	"logs":     {maxArgs: 2, noOutput: true},
+	"explain":  {maxArgs: 1, noOutput: true},
+	"top":      {maxArgs: 2, noOutput: true},
+
+	"scale":    {maxArgs: -1},
+	"label":    {maxArgs: -1},
+	"annotate": {maxArgs: -1},
+	"create":   {maxArgs: -1},
+
+	"rollout status":  {maxArgs: 2, noOutput: true},
+	"rollout history": {maxArgs: 2},
+	"rollout restart": {maxArgs: -1},
+	"rollout undo":    {maxArgs: 2},
+	"rollout pause":   {maxArgs: -1},
+	"rollout resume":  {maxArgs: -1},
+}
+
+// lookupSubcommand by the longest known prefix of the subcommand, such as "rollout status" for "rollout status -w".
+func lookupSubcommand(name string) (subcommand, bool) {
+	var parts = strings.Split(name, " ")
+
+	for i := len(parts); i > 0; i-- {
+		if sc, ok := subcommands[strings.Join(parts[:i], " ")]; ok {
+			return sc, true
+		}
+	}
+
+	return subcommand{}, false
+}
+
+// SplitSubcommand from its positional arguments, such as "rollout status" and ["deployment/web"]
+// for "rollout status deployment/web". The first word is the subcommand if none is known.
+func SplitSubcommand(words []string) (subcommand string, args []string) {
+	for i := len(words); i > 0; i-- {
+		if _, ok := subcommands[strings.Join(words[:i], " ")]; ok {
+			return strings.Join(words[:i], " "), words[i:]
+		}
+	}
+
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	return words[0], words[1:]
+}
+
+// CheckArgs verifies the subcommand has no flags, and the positional arguments can be used with it.
+func (a *Apply) CheckArgs() error {
+	for _, word := range strings.Split(a.Subcommand, " ") {
+		if strings.HasPrefix(word, "-") {
+			return fmt.Errorf(`subcommand "%s" can't have flags: use flags instead`, a.Subcommand)
+		}
+	}
+
+	if len(a.Args) == 0 {
+		return nil
+	}
+
+	var name = a.Subcommand
+
+	if name == "" {
+		name = Command
+	}
+
+	var sc, ok = lookupSubcommand(name)
+
+	switch {
+	case !ok || sc.maxArgs == 0:
+		return fmt.Errorf(`positional arguments are not supported by subcommand "%s"`, name)
+	case sc.maxArgs != -1 && len(a.Args) > sc.maxArgs:
+		return fmt.Errorf(`subcommand "%s" accepts up to %d positional arguments, got %d`, name, sc.maxArgs, len(a.Args))
+	}
+
+	for _, arg := range a.Args {
+		if err := checkArg(arg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cmdLine to copy and paste on a shell, quoting arguments when needed.
+func cmdLine(name string, args []string) string {
+	var s = []string{shellQuote(name)}
+
+	for _, arg := range args {
+		s = append(s, shellQuote(arg))
+	}
+
+	return strings.Join(s, " ")
+}
+
+var shellSafe = regexp.MustCompile(`^[a-zA-Z0-9_./:=,@%+-]+$`)
+
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+func checkArg(arg string) error {
+	if arg == "" {
+		return fmt.Errorf("positional arguments can't be empty")
+	}
+
+	// flags must be set with flags, so that they can be checked
+	if strings.HasPrefix(arg, "-") {
+		return fmt.Errorf(`positional argument "%s" can't start with "-": use flags instead`, arg)
+	}
+
+	for _, c := range arg {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("positional argument %q has control characters", arg)
+		}
+	}
+
+	return nil
+}
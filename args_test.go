@@ -0,0 +1,134 @@
+package kubeapply
+
+import (
+	"reflect"
+	"testing"
+)
+
+var checkArgsTests = []struct {
+	name    string
+	in      *Apply
+	wantErr string
+}{
+	{
+		"no args",
+		&Apply{},
+		"",
+	},
+	{
+		"apply",
+		&Apply{Args: []string{"deployment"}},
+		`positional arguments are not supported by subcommand "apply"`,
+	},
+	{
+		"unknown subcommand",
+		&Apply{Subcommand: "exec", Args: []string{"web"}},
+		`positional arguments are not supported by subcommand "exec"`,
+	},
+	{
+		"get",
+		&Apply{Subcommand: "get", Args: []string{"deployment", "web", "api"}},
+		"",
+	},
+	{
+		"rollout status",
+		&Apply{Subcommand: "rollout status", Args: []string{"deployment/web"}},
+		"",
+	},
+	{
+		"too many",
+		&Apply{Subcommand: "explain", Args: []string{"pods", "services"}},
+		`subcommand "explain" accepts up to 1 positional arguments, got 2`,
+	},
+	{
+		"empty",
+		&Apply{Subcommand: "get", Args: []string{"pods", ""}},
+		"positional arguments can't be empty",
+	},
+	{
+		"flag",
+		&Apply{Subcommand: "get", Args: []string{"pods", "--all-namespaces"}},
+		`positional argument "--all-namespaces" can't start with "-": use flags instead`,
+	},
+	{
+		"control characters",
+		&Apply{Subcommand: "get", Args: []string{"pods\nweb"}},
+		`positional argument "pods\nweb" has control characters`,
+	},
+	{
+		"flag on subcommand",
+		&Apply{Subcommand: "apply --kubeconfig=/other"},
+		`subcommand "apply --kubeconfig=/other" can't have flags: use flags instead`,
+	},
+}
+
+func TestCheckArgs(t *testing.T) {
+	for _, tt := range checkArgsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err = tt.in.CheckArgs()
+
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("Expected error to be %q, got %v instead", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+var cmdLineTests = []struct {
+	name string
+	args []string
+	want string
+}{
+	{
+		"kubectl",
+		[]string{"get", "pods", "-l=app=web", "--output=json"},
+		"kubectl get pods -l=app=web --output=json",
+	},
+	{
+		"kubectl",
+		[]string{"get", "pods", "web server"},
+		"kubectl get pods 'web server'",
+	},
+	{
+		"kubectl",
+		[]string{"annotate", "pods", "web", "note=it's"},
+		`kubectl annotate pods web 'note=it'\''s'`,
+	},
+	{
+		"kubectl",
+		[]string{"get", "pods", "--selector=", "*"},
+		"kubectl get pods --selector= '*'",
+	},
+}
+
+func TestCmdLine(t *testing.T) {
+	for _, tt := range cmdLineTests {
+		if got := cmdLine(tt.name, tt.args); got != tt.want {
+			t.Errorf("Expected cmdLine(%v, %v) = %v, got %v instead", tt.name, tt.args, tt.want, got)
+		}
+	}
+}
+
+var splitSubcommandTests = []struct {
+	words          []string
+	wantSubcommand string
+	wantArgs       []string
+}{
+	{nil, "", nil},
+	{[]string{"apply"}, "apply", []string{}},
+	{[]string{"get", "pods", "web"}, "get", []string{"pods", "web"}},
+	{[]string{"rollout", "status", "deployment/web"}, "rollout status", []string{"deployment/web"}},
+	{[]string{"rollout"}, "rollout", []string{}},
+	{[]string{"exec", "web"}, "exec", []string{"web"}},
+}
+
+func TestSplitSubcommand(t *testing.T) {
+	for _, tt := range splitSubcommandTests {
+		subcommand, args := SplitSubcommand(tt.words)
+
+		if subcommand != tt.wantSubcommand || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("Expected SplitSubcommand(%v) = (%v, %v), got (%v, %v) instead",
+				tt.words, tt.wantSubcommand, tt.wantArgs, subcommand, args)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+// Package client for the kubeapply HTTP API.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/server"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Default values used by New.
+const (
+	DefaultRetries      = 3
+	DefaultBackoff      = 500 * time.Millisecond
+	DefaultMaxBackoff   = 10 * time.Second
+	DefaultPollInterval = time.Second
+)
+
+// Client of a kubeapply server.
+type Client struct {
+	// Endpoint of the server, such as "http://localhost:9000".
+	Endpoint string
+
+	// HTTPClient used to send requests. http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+
+	// Token sent as a bearer token, for servers requiring API tokens.
+	Token string
+
+	// Retries of requests failing with a network error or with the status codes 409, 502, 503, or 504.
+	// Apply requests are sent with an idempotency key when retries are enabled, so that they don't run twice.
+	Retries int
+
+	// Backoff before the first retry. It doubles on each retry, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// PollInterval of Wait.
+	PollInterval time.Duration
+}
+
+// New client for the kubeapply server on the endpoint.
+func New(endpoint string) *Client {
+	return &Client{
+		Endpoint:     endpoint,
+		Retries:      DefaultRetries,
+		Backoff:      DefaultBackoff,
+		MaxBackoff:   DefaultMaxBackoff,
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// Options of an apply request.
+type Options struct {
+	// IdempotencyKey of the request. A random key is used if empty and retries are enabled.
+	IdempotencyKey string
+
+	// RequestID to correlate the request on the logs and recordings of the server.
+	RequestID string
+
+	// Output receives the output of kubectl as it runs, on the "stdout" or "stderr" stream.
+	// The output isn't streamed if nil.
+	Output func(stream, data string)
+}
+
+// Error response of the server.
+type Error struct {
+	StatusCode int
+	Message    string
+	Errors     string
+}
+
+func (e *Error) Error() string {
+	if e.Errors == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, e.Message)
+	}
+
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Message, e.Errors)
+}
+
+// ExitError is returned when kubectl exits with a non-zero exit code, or when the manifests can't be rendered.
+type ExitError struct {
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("kubectl exit code %d: %s", e.ExitCode, strings.TrimSpace(e.Stderr))
+}
+
+// Apply the request. An *ExitError is returned with the response if kubectl fails.
+func (c *Client) Apply(ctx context.Context, req server.ApplyRequestBody, opts *Options) (kubeapply.Response, error) {
+	var resp, err = c.apply(ctx, req, opts)
+
+	if err == nil && resp.ExitCode != 0 {
+		err = &ExitError{ExitCode: resp.ExitCode, Stderr: resp.Stderr}
+	}
+
+	return resp, err
+}
+
+// Diff the request against the live objects with kubectl diff.
+// kubectl diff exits with code 1 when there are differences, and this isn't considered an error.
+func (c *Client) Diff(ctx context.Context, req server.ApplyRequestBody, opts *Options) (kubeapply.Response, error) {
+	req.Command = "diff"
+	var resp, err = c.apply(ctx, req, opts)
+
+	if err == nil && resp.ExitCode != 0 && resp.ExitCode != 1 {
+		err = &ExitError{ExitCode: resp.ExitCode, Stderr: resp.Stderr}
+	}
+
+	return resp, err
+}
+
+func (c *Client) apply(ctx context.Context, req server.ApplyRequestBody, opts *Options) (kubeapply.Response, error) {
+	var resp kubeapply.Response
+	var h = c.applyHeader(opts)
+	var stream = opts != nil && opts.Output != nil
+
+	if stream {
+		h.Set("Accept", server.StreamMediaType+", application/json")
+	}
+
+	hr, err := c.do(ctx, http.MethodPost, "/apply", req, h)
+
+	if err != nil {
+		return resp, err
+	}
+
+	defer hr.Body.Close()
+
+	if stream && isMediaType(hr, server.StreamMediaType) {
+		return readStream(hr.Body, opts.Output)
+	}
+
+	err = decodeApplyResponse(hr, &resp)
+	return resp, err
+}
+
+// applyHeader for the options.
+func (c *Client) applyHeader(opts *Options) http.Header {
+	var h = http.Header{}
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var key = opts.IdempotencyKey
+
+	if key == "" && c.Retries > 0 {
+		key = uuid.NewV4().String()
+	}
+
+	if key != "" {
+		h.Set(server.IdempotencyKeyHeader, key)
+	}
+
+	if opts.RequestID != "" {
+		h.Set(server.RequestIDHeader, opts.RequestID)
+	}
+
+	return h
+}
+
+// Submit the request to run in the background, returning the queued job.
+// Use Wait to wait for it to finish.
+func (c *Client) Submit(ctx context.Context, req server.ApplyRequestBody, opts *Options) (server.Job, error) {
+	var j server.Job
+	var h = c.applyHeader(opts)
+	h.Set("Prefer", "respond-async")
+	hr, err := c.do(ctx, http.MethodPost, "/apply", req, h)
+
+	if err != nil {
+		return j, err
+	}
+
+	defer hr.Body.Close()
+
+	if hr.StatusCode != http.StatusAccepted {
+		return j, readError(hr)
+	}
+
+	err = json.NewDecoder(hr.Body).Decode(&j)
+	return j, err
+}
+
+// Job running in the background.
+func (c *Client) Job(ctx context.Context, id string) (server.Job, error) {
+	var j server.Job
+	var err = c.get(ctx, "/jobs/"+url.PathEscape(id), &j)
+	return j, err
+}
+
+// Wait for a job to finish, polling it every PollInterval.
+// An *ExitError is returned with the job if kubectl fails, and an error if the job fails to run.
+func (c *Client) Wait(ctx context.Context, id string) (server.Job, error) {
+	var interval = c.PollInterval
+
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	for {
+		var j, err = c.Job(ctx, id)
+
+		if err != nil {
+			return j, err
+		}
+
+		switch j.Status {
+		case server.JobFailed:
+			return j, fmt.Errorf("job %s failed: %s", j.ID, j.Error)
+		case server.JobDone:
+			if j.Response != nil && j.Response.ExitCode != 0 {
+				return j, &ExitError{ExitCode: j.Response.ExitCode, Stderr: j.Response.Stderr}
+			}
+
+			return j, nil
+		}
+
+		if err := sleep(ctx, interval); err != nil {
+			return j, err
+		}
+	}
+}
+
+// Recording of a request, with the response recorded by the server.
+// The id is the id of the response, not the request ID.
+func (c *Client) Recording(ctx context.Context, id string) (kubeapply.Response, error) {
+	var resp kubeapply.Response
+	var err = c.get(ctx, "/recordings/"+url.PathEscape(id), &resp)
+	return resp, err
+}
+
+// Version of kubeapply and kubectl, and of the Kubernetes API server of the cluster, if not empty.
+func (c *Client) Version(ctx context.Context, cluster string) (server.VersionResponse, error) {
+	var vr server.VersionResponse
+	var p = "/version"
+
+	if cluster != "" {
+		p += "?cluster=" + url.QueryEscape(cluster)
+	}
+
+	var err = c.get(ctx, p, &vr)
+	return vr, err
+}
+
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	var hr, err = c.do(ctx, http.MethodGet, path, nil, http.Header{})
+
+	if err != nil {
+		return err
+	}
+
+	defer hr.Body.Close()
+
+	if hr.StatusCode != http.StatusOK {
+		return readError(hr)
+	}
+
+	return json.NewDecoder(hr.Body).Decode(v)
+}
+
+// do the request, retrying on network errors and temporary failures.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, h http.Header) (*http.Response, error) {
+	var b []byte
+
+	if body != nil {
+		var err error
+
+		if b, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+
+		h.Set("Content-Type", "application/json")
+	}
+
+	var backoff = c.Backoff
+
+	for attempt := 0; ; attempt++ {
+		var hr, err = c.send(ctx, method, path, b, h)
+
+		if attempt >= c.Retries || !retry(hr, err) || ctx.Err() != nil {
+			return hr, err
+		}
+
+		if hr != nil {
+			_, _ = io.Copy(ioutil.Discard, hr.Body)
+			_ = hr.Body.Close()
+		}
+
+		if err := sleep(ctx, backoff); err != nil {
+			return nil, err
+		}
+
+		if backoff *= 2; c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, b []byte, h http.Header) (*http.Response, error) {
+	var body io.Reader
+
+	if b != nil {
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.Endpoint, "/")+path, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range h {
+		req.Header[k] = v
+	}
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	var hc = c.HTTPClient
+
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	return hc.Do(req.WithContext(ctx))
+}
+
+// retry requests failing with a network error, or with a status code meaning the request didn't run.
+// 409 Conflict is returned when an in-flight request with the same idempotency key fails to complete.
+func retry(hr *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch hr.StatusCode {
+	case http.StatusConflict, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	var t = time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isMediaType(hr *http.Response, mediaType string) bool {
+	return strings.HasPrefix(hr.Header.Get("Content-Type"), mediaType)
+}
+
+// errorResponse of the server.
+type errorResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Errors  string `json:"errors"`
+}
+
+// decodeApplyResponse, which is a kubeapply.Response even when kubectl fails, or an error response.
+func decodeApplyResponse(hr *http.Response, resp *kubeapply.Response) error {
+	switch hr.StatusCode {
+	case http.StatusOK, http.StatusInternalServerError, http.StatusUnprocessableEntity:
+	default:
+		return readError(hr)
+	}
+
+	b, err := ioutil.ReadAll(hr.Body)
+
+	if err != nil {
+		return err
+	}
+
+	var er errorResponse
+
+	if err := json.Unmarshal(b, &er); err != nil || er.Status != 0 {
+		return newError(hr.StatusCode, b)
+	}
+
+	return json.Unmarshal(b, resp)
+}
+
+func readError(hr *http.Response) error {
+	var b, _ = ioutil.ReadAll(io.LimitReader(hr.Body, 1<<20))
+	return newError(hr.StatusCode, b)
+}
+
+func newError(code int, b []byte) *Error {
+	var er errorResponse
+
+	if err := json.Unmarshal(b, &er); err != nil || er.Status == 0 {
+		return &Error{
+			StatusCode: code,
+			Message:    http.StatusText(code),
+			Errors:     strings.TrimSpace(string(b)),
+		}
+	}
+
+	return &Error{
+		StatusCode: code,
+		Message:    er.Message,
+		Errors:     er.Errors,
+	}
+}
+
+// errStreamInterrupted is returned when a streamed response ends before the last event.
+var errStreamInterrupted = errors.New("streamed response ended before the response event")
+
+// readStream of events, calling output for each output event, and returning the response on the last event.
+func readStream(r io.Reader, output func(stream, data string)) (kubeapply.Response, error) {
+	var s = bufio.NewScanner(r)
+	s.Buffer(nil, 64<<20)
+
+	for s.Scan() {
+		var e server.StreamEvent
+
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return kubeapply.Response{}, fmt.Errorf("cannot decode streamed event: %v", err)
+		}
+
+		if e.Response != nil {
+			return *e.Response, nil
+		}
+
+		output(e.Stream, e.Data)
+	}
+
+	if err := s.Err(); err != nil {
+		return kubeapply.Response{}, err
+	}
+
+	return kubeapply.Response{}, errStreamInterrupted
+}
@@ -0,0 +1,556 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/server"
+	"github.com/henvic/kubeapply/server/decoding"
+)
+
+const fakeKubectl = `#!/bin/sh
+echo "$*" >> RUNS
+case "$1" in
+version)
+	echo '{"clientVersion": {"gitVersion": "v1.30.0"}}'
+	;;
+diff)
+	echo "+  replicas: 2"
+	exit 1
+	;;
+*)
+	echo "applying" >&2
+	case "$*" in
+	*-x=fail*)
+		echo "error: failed" >&2
+		exit 3
+		;;
+	*-x=slow*)
+		echo "started"
+		sleep 1
+		;;
+	esac
+	echo "$*"
+	;;
+esac
+`
+
+type testServer struct {
+	*httptest.Server
+
+	runs string
+
+	// fail the next requests to /apply with 503 Service Unavailable.
+	fail int
+
+	// keys of the idempotency key header of the requests to /apply.
+	keys []string
+
+	m sync.Mutex
+}
+
+func (ts *testServer) runCount(t *testing.T) int {
+	b, err := ioutil.ReadFile(ts.runs)
+
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	return strings.Count(string(b), "\n")
+}
+
+func newTestServer(t *testing.T) *testServer {
+	var dir = t.TempDir()
+	var runs = filepath.Join(dir, "runs")
+	var script = strings.Replace(fakeKubectl, "RUNS", runs, 1)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var configurationsDir = kubeapply.ConfigurationsDir
+	kubeapply.ConfigurationsDir = filepath.Join(dir, "configurations")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t.Cleanup(func() {
+		cancel()
+		kubeapply.ConfigurationsDir = configurationsDir
+	})
+
+	h, err := server.Handler(ctx, server.Params{
+		MaxConcurrency: 4,
+		KubeconfigsDir: filepath.Join(dir, "kubeconfigs"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ts = &testServer{
+		runs: runs,
+	}
+
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/apply" {
+			ts.m.Lock()
+			ts.keys = append(ts.keys, r.Header.Get(server.IdempotencyKeyHeader))
+			var fail = ts.fail > 0
+			ts.fail--
+			ts.m.Unlock()
+
+			if fail {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	}))
+
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestClient(ts *testServer) *Client {
+	var c = New(ts.URL)
+	c.Backoff = time.Millisecond
+	c.PollInterval = 10 * time.Millisecond
+	return c
+}
+
+var manifests = server.ApplyRequestBody{
+	Files: map[string]decoding.FileValue{
+		"app.yaml": {Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n")},
+	},
+}
+
+func TestApply(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+
+	resp, err := c.Apply(context.Background(), manifests, &Options{RequestID: "deploy-1"})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if want := "apply --filename=./ --recursive --output=json\n"; string(resp.Stdout) != want {
+		t.Errorf("Expected stdout to be %q, got %q instead", want, resp.Stdout)
+	}
+
+	if resp.RequestID != "deploy-1" {
+		t.Errorf("Expected request ID to be deploy-1, got %v instead", resp.RequestID)
+	}
+
+	if len(resp.Objects) != 1 || resp.Objects[0].Name != "web" {
+		t.Errorf("Expected objects to have the ConfigMap, got %+v instead", resp.Objects)
+	}
+
+	recorded, err := c.Recording(context.Background(), resp.ID)
+
+	if err != nil {
+		t.Fatalf("Expected no error getting recording, got %v instead", err)
+	}
+
+	if recorded.ID != resp.ID || recorded.CmdLine != resp.CmdLine || recorded.Stdout != resp.Stdout {
+		t.Errorf("Expected recording to be %+v, got %+v instead", resp, recorded)
+	}
+}
+
+func TestApplyExitError(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+
+	var req = manifests
+	req.Flags = map[string]decoding.FlagValue{"x": {"fail"}}
+
+	resp, err := c.Apply(context.Background(), req, nil)
+
+	var ee *ExitError
+
+	if !errors.As(err, &ee) || ee.ExitCode != 3 {
+		t.Fatalf("Expected exit error with code 3, got %v instead", err)
+	}
+
+	if want := "applying\nerror: failed\n"; resp.Stderr != want || ee.Stderr != want {
+		t.Errorf("Expected stderr to be %q, got %q instead", want, resp.Stderr)
+	}
+
+	if n := ts.runCount(t); n != 1 {
+		t.Errorf("Expected kubectl to run once, got %d instead", n)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+
+	resp, err := c.Diff(context.Background(), manifests, nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if resp.ExitCode != 1 || string(resp.Stdout) != "+  replicas: 2\n" {
+		t.Errorf("Expected differences with exit code 1, got %+v instead", resp)
+	}
+
+	if want := "diff --filename=./ --recursive"; !strings.Contains(resp.CmdLine, want) {
+		t.Errorf("Expected command line to contain %q, got %q instead", want, resp.CmdLine)
+	}
+}
+
+type outputRecorder struct {
+	stdout, stderr string
+}
+
+func (o *outputRecorder) output(stream, data string) {
+	switch stream {
+	case "stdout":
+		o.stdout += data
+	case "stderr":
+		o.stderr += data
+	}
+}
+
+func TestApplyStream(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+
+	var o = &outputRecorder{}
+	var opts = &Options{
+		IdempotencyKey: "stream-1",
+		Output:         o.output,
+	}
+
+	resp, err := c.Apply(context.Background(), manifests, opts)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if o.stdout != string(resp.Stdout) || o.stderr != resp.Stderr || o.stderr != "applying\n" {
+		t.Errorf("Expected streamed output to match response, got (%q, %q) instead", o.stdout, o.stderr)
+	}
+
+	// the output of a replayed response is sent before the response
+	var replayed = &outputRecorder{}
+	opts.Output = replayed.output
+
+	replay, err := c.Apply(context.Background(), manifests, opts)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if replay.ID != resp.ID || *replayed != *o {
+		t.Errorf("Expected replayed response and output, got %+v and %+v instead", replay, replayed)
+	}
+
+	if n := ts.runCount(t); n != 1 {
+		t.Errorf("Expected kubectl to run once, got %d instead", n)
+	}
+
+	// the output is received while kubectl runs, not only when it exits
+	var slow = manifests
+	slow.Flags = map[string]decoding.FlagValue{"-x": {"slow"}}
+
+	var started time.Time
+
+	opts = &Options{
+		Output: func(stream, data string) {
+			if started.IsZero() && strings.Contains(data, "started") {
+				started = time.Now()
+			}
+		},
+	}
+
+	if _, err := c.Apply(context.Background(), slow, opts); err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if started.IsZero() || time.Since(started) < 500*time.Millisecond {
+		t.Errorf("Expected output to be streamed before kubectl finished, got it %v before the response instead", time.Since(started))
+	}
+}
+
+func TestApplyIdempotentDisconnected(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+	c.Retries = 0
+
+	var req = manifests
+	req.Flags = map[string]decoding.FlagValue{"-x": {"slow"}}
+
+	var opts = &Options{IdempotencyKey: "disconnected-1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.Apply(ctx, req, opts); err == nil {
+		t.Fatalf("Expected error for request canceled by the client")
+	}
+
+	// the retry gets the response of the first request, which kept running after the client disconnected
+	resp, err := c.Apply(context.Background(), req, opts)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if want := "started\napply -x=slow --filename=./ --recursive --output=json\n"; string(resp.Stdout) != want {
+		t.Errorf("Expected stdout to be %q, got %q instead", want, resp.Stdout)
+	}
+
+	if n := ts.runCount(t); n != 1 {
+		t.Errorf("Expected kubectl to run once, got %d instead", n)
+	}
+}
+
+func TestApplyRetries(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+	ts.fail = 2
+
+	resp, err := c.Apply(context.Background(), manifests, nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if resp.ExitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d instead", resp.ExitCode)
+	}
+
+	if len(ts.keys) != 3 || ts.keys[0] == "" || ts.keys[0] != ts.keys[1] || ts.keys[1] != ts.keys[2] {
+		t.Errorf("Expected 3 attempts with the same idempotency key, got %v instead", ts.keys)
+	}
+
+	if n := ts.runCount(t); n != 1 {
+		t.Errorf("Expected kubectl to run once, got %d instead", n)
+	}
+}
+
+func TestApplyRetriesExhausted(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+	c.Retries = 1
+	ts.fail = 2
+
+	_, err := c.Apply(context.Background(), manifests, nil)
+
+	var e *Error
+
+	if !errors.As(err, &e) || e.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 error, got %v instead", err)
+	}
+
+	if len(ts.keys) != 2 {
+		t.Errorf("Expected 2 attempts, got %d instead", len(ts.keys))
+	}
+}
+
+func TestApplyNoRetries(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+	c.Retries = 0
+
+	if _, err := c.Apply(context.Background(), manifests, nil); err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if len(ts.keys) != 1 || ts.keys[0] != "" {
+		t.Errorf("Expected request without idempotency key, got %v instead", ts.keys)
+	}
+}
+
+func TestSubmitAndWait(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+
+	j, err := c.Submit(context.Background(), manifests, &Options{RequestID: "job-1"})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if j.ID == "" || j.Status != server.JobQueued || j.RequestID != "job-1" {
+		t.Errorf("Expected queued job, got %+v instead", j)
+	}
+
+	done, err := c.Wait(context.Background(), j.ID)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if done.Status != server.JobDone || done.Response == nil || done.Response.RequestID != "job-1" {
+		t.Fatalf("Expected job to be done with response, got %+v instead", done)
+	}
+
+	if done.Finished == nil {
+		t.Errorf("Expected job to have finished time")
+	}
+
+	recorded, err := c.Recording(context.Background(), done.Response.ID)
+
+	if err != nil || recorded.ID != done.Response.ID {
+		t.Errorf("Expected recording of job response, got %+v (error: %v) instead", recorded, err)
+	}
+}
+
+func TestWaitExitError(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+
+	var req = manifests
+	req.Flags = map[string]decoding.FlagValue{"x": {"fail"}}
+
+	j, err := c.Submit(context.Background(), req, nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	done, err := c.Wait(context.Background(), j.ID)
+
+	var ee *ExitError
+
+	if !errors.As(err, &ee) || ee.ExitCode != 3 || done.Status != server.JobDone {
+		t.Errorf("Expected exit error with code 3, got %v instead", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+
+	v, err := c.Version(context.Background(), "")
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if !strings.Contains(string(v.Kubectl), "v1.30.0") || v.Kubeapply.GoVersion == "" || v.Cluster != nil {
+		t.Errorf("Expected kubectl and kubeapply versions, got %+v instead", v)
+	}
+}
+
+var errorCases = []struct {
+	name string
+	call func(c *Client) error
+	want int
+}{
+	{
+		"unsafe path",
+		func(c *Client) error {
+			_, err := c.Apply(context.Background(), server.ApplyRequestBody{
+				Files: map[string]decoding.FileValue{"../app.yaml": {}},
+			}, nil)
+			return err
+		},
+		http.StatusUnprocessableEntity,
+	},
+	{
+		"invalid args",
+		func(c *Client) error {
+			_, err := c.Apply(context.Background(), server.ApplyRequestBody{Args: []string{"web"}}, nil)
+			return err
+		},
+		http.StatusBadRequest,
+	},
+	{
+		"job not found",
+		func(c *Client) error {
+			_, err := c.Job(context.Background(), "not-found")
+			return err
+		},
+		http.StatusNotFound,
+	},
+	{
+		"recording not found",
+		func(c *Client) error {
+			_, err := c.Recording(context.Background(), "00000000-0000-0000-0000-000000000000")
+			return err
+		},
+		http.StatusNotFound,
+	},
+	{
+		"invalid recording id",
+		func(c *Client) error {
+			_, err := c.Recording(context.Background(), "configurations")
+			return err
+		},
+		http.StatusNotFound,
+	},
+}
+
+func TestErrors(t *testing.T) {
+	var ts = newTestServer(t)
+	var c = newTestClient(ts)
+
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var err = tt.call(c)
+			var e *Error
+
+			if !errors.As(err, &e) || e.StatusCode != tt.want {
+				t.Errorf("Expected error with status code %d, got %v instead", tt.want, err)
+			}
+
+			if e != nil && e.Errors == "" {
+				t.Errorf("Expected error to have a message")
+			}
+		})
+	}
+}
+
+func TestApplyCanceled(t *testing.T) {
+	var c = New("http://127.0.0.1:1")
+	c.Backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.Apply(ctx, manifests, nil); err != context.DeadlineExceeded {
+		t.Errorf("Expected context deadline exceeded error, got %v instead", err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	var auth string
+
+	var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	defer ts.Close()
+
+	var c = New(ts.URL)
+	c.Token = "abc"
+
+	var err = c.get(context.Background(), "/jobs/x", nil)
+	var e *Error
+
+	if !errors.As(err, &e) || e.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected error with status code %d, got %v instead", http.StatusUnauthorized, err)
+	}
+
+	if auth != "Bearer abc" {
+		t.Errorf("Expected bearer token to be sent, got %q instead", auth)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/server/decoding"
+)
+
+// valueFlags of kubectl whose value can be passed as the next argument, such as "-n web".
+// Other flags need "=" to have a value, such as "--dry-run=server", as they might be boolean flags.
+var valueFlags = map[string]struct{}{
+	"-f": {}, "--filename": {},
+	"-k": {}, "--kustomize": {},
+	"-n": {}, "--namespace": {},
+	"-l": {}, "--selector": {},
+	"-o": {}, "--output": {},
+	"-c": {}, "--container": {},
+	"--context":         {},
+	"--field-manager":   {},
+	"--field-selector":  {},
+	"--for":             {},
+	"--prune-allowlist": {},
+	"--prune-whitelist": {},
+	"--replicas":        {},
+	"--since":           {},
+	"--tail":            {},
+	"--timeout":         {},
+	"--to-revision":     {},
+
+	"--kubeapply-url":     {},
+	"--kubeapply-cluster": {},
+}
+
+// invocation of kubeapply.
+type invocation struct {
+	// url of the kubeapply server.
+	url string
+
+	cluster string
+
+	subcommand string
+	args       []string
+	flags      map[string]decoding.FlagValue
+
+	// filenames and kustomizations to upload.
+	filenames      []string
+	kustomizations []string
+	recursive      bool
+}
+
+// parseArgs like kubectl: a subcommand with its positional arguments, and flags in any order.
+func parseArgs(args []string) (*invocation, error) {
+	var (
+		inv = &invocation{
+			flags: map[string]decoding.FlagValue{},
+		}
+
+		words []string
+	)
+
+	for i := 0; i < len(args); i++ {
+		var arg = args[i]
+
+		if arg == "--" {
+			words = append(words, args[i+1:]...)
+			break
+		}
+
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			words = append(words, arg)
+			continue
+		}
+
+		var name, value, hasValue = splitFlag(arg)
+
+		if _, ok := valueFlags[name]; ok && !hasValue {
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("flag needs an argument: %s", name)
+			}
+
+			i++
+			value, hasValue = args[i], true
+		}
+
+		if err := inv.addFlag(name, value, hasValue); err != nil {
+			return nil, err
+		}
+	}
+
+	inv.subcommand, inv.args = kubeapply.SplitSubcommand(words)
+
+	if len(inv.filenames) != 0 && len(inv.kustomizations) != 0 {
+		return nil, errors.New("only one of -f or -k can be specified")
+	}
+
+	return inv, nil
+}
+
+// splitFlag into its name and value, such as "-n=web", "-nweb", or "--namespace=web".
+func splitFlag(arg string) (name, value string, hasValue bool) {
+	if i := strings.Index(arg, "="); i != -1 {
+		return arg[:i], arg[i+1:], true
+	}
+
+	// short flag with its value attached
+	if len(arg) > 2 && arg[1] != '-' {
+		if _, ok := valueFlags[arg[:2]]; ok {
+			return arg[:2], arg[2:], true
+		}
+	}
+
+	return arg, "", false
+}
+
+func (inv *invocation) addFlag(name, value string, hasValue bool) error {
+	switch name {
+	case "--kubeapply-url":
+		inv.url = value
+	case "--kubeapply-cluster":
+		inv.cluster = value
+	case "-f", "--filename":
+		inv.filenames = append(inv.filenames, value)
+	case "-k", "--kustomize":
+		inv.kustomizations = append(inv.kustomizations, value)
+	case "-R", "--recursive":
+		switch value {
+		case "", "true":
+			inv.recursive = true
+		case "false":
+			inv.recursive = false
+		default:
+			return fmt.Errorf(`invalid value "%s" for %s`, value, name)
+		}
+	default:
+		if !hasValue {
+			value = ""
+		}
+
+		inv.flags[name] = append(inv.flags[name], value)
+	}
+
+	return nil
+}
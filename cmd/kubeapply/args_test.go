@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/henvic/kubeapply/server/decoding"
+)
+
+var parseArgsTests = []struct {
+	name    string
+	args    []string
+	want    *invocation
+	wantErr string
+}{
+	{
+		name: "apply",
+		args: []string{"apply", "-f", "k8s", "-R", "--dry-run=server", "-n", "web"},
+		want: &invocation{
+			subcommand: "apply",
+			args:       []string{},
+			flags: map[string]decoding.FlagValue{
+				"--dry-run": {"server"},
+				"-n":        {"web"},
+			},
+			filenames: []string{"k8s"},
+			recursive: true,
+		},
+	},
+	{
+		name: "flags before subcommand",
+		args: []string{"-nweb", "--kubeapply-url", "http://localhost:9001", "--kubeapply-cluster=prod", "-f=a.yaml",
+			"--filename", "b.yaml", "--prune", "-l", "app=web", "apply"},
+		want: &invocation{
+			url:        "http://localhost:9001",
+			cluster:    "prod",
+			subcommand: "apply",
+			args:       []string{},
+			flags: map[string]decoding.FlagValue{
+				"-n":      {"web"},
+				"--prune": {""},
+				"-l":      {"app=web"},
+			},
+			filenames: []string{"a.yaml", "b.yaml"},
+		},
+	},
+	{
+		name: "positional arguments",
+		args: []string{"rollout", "status", "deployment/web", "--timeout", "1m", "--", "-x"},
+		want: &invocation{
+			subcommand: "rollout status",
+			args:       []string{"deployment/web", "-x"},
+			flags: map[string]decoding.FlagValue{
+				"--timeout": {"1m"},
+			},
+		},
+	},
+	{
+		name: "repeated flags",
+		args: []string{"apply", "-f", "-", "--prune-allowlist", "core/v1/ConfigMap", "--prune-allowlist=apps/v1/Deployment",
+			"--recursive=false"},
+		want: &invocation{
+			subcommand: "apply",
+			args:       []string{},
+			flags: map[string]decoding.FlagValue{
+				"--prune-allowlist": {"core/v1/ConfigMap", "apps/v1/Deployment"},
+			},
+			filenames: []string{"-"},
+		},
+	},
+	{
+		name: "kustomize",
+		args: []string{"diff", "-k", "overlays/prod"},
+		want: &invocation{
+			subcommand:     "diff",
+			args:           []string{},
+			flags:          map[string]decoding.FlagValue{},
+			kustomizations: []string{"overlays/prod"},
+		},
+	},
+	{
+		name:    "missing value",
+		args:    []string{"apply", "-f"},
+		wantErr: "flag needs an argument: -f",
+	},
+	{
+		name:    "invalid recursive",
+		args:    []string{"apply", "-R=yes"},
+		wantErr: `invalid value "yes" for -R`,
+	},
+	{
+		name:    "filename and kustomize",
+		args:    []string{"apply", "-f", "a.yaml", "-k", "."},
+		wantErr: "only one of -f or -k can be specified",
+	},
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, tt := range parseArgsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArgs(tt.args)
+
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("Expected error to be %q, got %v instead", tt.wantErr, err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected parseArgs(%v) = %+v, got %+v instead", tt.args, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/server/decoding"
+	"gopkg.in/yaml.v3"
+)
+
+// stdinFile is the name of the file uploaded with the manifests read from the standard input with "-f -".
+const stdinFile = "stdin.yaml"
+
+// uploads of the local files.
+type uploads struct {
+	files map[string]decoding.FileValue
+	stdin io.Reader
+}
+
+func newUploads(stdin io.Reader) *uploads {
+	return &uploads{
+		files: map[string]decoding.FileValue{},
+		stdin: stdin,
+	}
+}
+
+// addFilename like kubectl reads -f: a file, or the manifests (.json, .yaml, and .yml files) of a directory.
+// Subdirectories are only read if recursive is set.
+func (u *uploads) addFilename(name string, recursive bool) error {
+	if name == "-" {
+		b, err := ioutil.ReadAll(u.stdin)
+
+		if err != nil {
+			return fmt.Errorf("cannot read standard input: %v", err)
+		}
+
+		return u.add(stdinFile, b, 0)
+	}
+
+	if strings.Contains(name, "://") {
+		return fmt.Errorf(`cannot use "%s": URLs are not supported`, name)
+	}
+
+	info, err := os.Stat(name)
+
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		if !kubeapply.IsManifest(name) {
+			return fmt.Errorf(`"%s" is not a manifest: use .json, .yaml, or .yml files`, name)
+		}
+
+		return u.addFile(name, name, info)
+	}
+
+	return filepath.Walk(name, func(p string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir() && p != name && !recursive:
+			return filepath.SkipDir
+		case info.IsDir() || !kubeapply.IsManifest(p):
+			return nil
+		}
+
+		return u.addFile(name, p, info)
+	})
+}
+
+// addKustomization directory, with all its files and the directories of the kustomizations it uses
+// on resources, bases, or components, returning the uploaded path of the directory.
+// Directories outside of the kustomization are uploaded from the directory containing all of them.
+func (u *uploads) addKustomization(dir string) (string, error) {
+	info, err := os.Stat(dir)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf(`kustomization "%s" is not a directory`, dir)
+	}
+
+	var dirs = map[string]bool{}
+
+	if err := kustomizationDirs(dir, dirs); err != nil {
+		return "", err
+	}
+
+	var root, toRoot = uploadRoot(dirs)
+
+	for _, d := range topDirs(dirs) {
+		if err := u.addDir(root, toRoot(d)); err != nil {
+			return "", err
+		}
+	}
+
+	var abs, _ = filepath.Abs(dir)
+	return uploadPath(root, toRoot(abs)), nil
+}
+
+// addDir with all its files, uploaded by their path from root.
+func (u *uploads) addDir(root, dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir() && info.Name() == ".git":
+			return filepath.SkipDir
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		return u.addFile(root, p, info)
+	})
+}
+
+// kustomizationFiles recognized by kustomize.
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+type kustomization struct {
+	Resources  []string `yaml:"resources"`
+	Bases      []string `yaml:"bases"`
+	Components []string `yaml:"components"`
+}
+
+// kustomizationDirs adds the absolute path of dir to dirs, and the directories it uses on its kustomization.
+// Remote resources, and resources that are files, are left for kustomize.
+func kustomizationDirs(dir string, dirs map[string]bool) error {
+	abs, err := filepath.Abs(dir)
+
+	if err != nil {
+		return err
+	}
+
+	if dirs[abs] {
+		return nil
+	}
+
+	dirs[abs] = true
+
+	for _, name := range kustomizationFiles {
+		b, err := ioutil.ReadFile(filepath.Join(abs, name)) // #nosec
+
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var k kustomization
+
+		if err := yaml.Unmarshal(b, &k); err != nil {
+			return fmt.Errorf("invalid kustomization %s: %v", filepath.Join(dir, name), err)
+		}
+
+		for _, r := range append(append(k.Resources, k.Bases...), k.Components...) {
+			if strings.Contains(r, "://") {
+				continue
+			}
+
+			var p = filepath.Join(abs, filepath.FromSlash(r))
+
+			if info, err := os.Stat(p); err != nil || !info.IsDir() {
+				continue
+			}
+
+			if err := kustomizationDirs(p, dirs); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// uploadRoot is the directory containing all the dirs, relative to the working directory if inside it.
+// toRoot converts an absolute path to the same form.
+func uploadRoot(dirs map[string]bool) (root string, toRoot func(string) string) {
+	for d := range dirs {
+		if root == "" {
+			root = d
+		}
+
+		for !within(root, d) {
+			root = filepath.Dir(root)
+		}
+	}
+
+	var abs = func(p string) string { return p }
+	var wd, err = os.Getwd()
+
+	if err != nil {
+		return root, abs
+	}
+
+	rel, err := filepath.Rel(wd, root)
+
+	if err != nil || !local(rel) {
+		return root, abs
+	}
+
+	return rel, func(p string) string {
+		var r, _ = filepath.Rel(wd, p)
+		return r
+	}
+}
+
+// topDirs of dirs, skipping the ones inside others, sorted.
+func topDirs(dirs map[string]bool) []string {
+	var top = []string{}
+
+	for d := range dirs {
+		var inside bool
+
+		for o := range dirs {
+			if o != d && within(o, d) {
+				inside = true
+				break
+			}
+		}
+
+		if !inside {
+			top = append(top, d)
+		}
+	}
+
+	sort.Strings(top)
+	return top
+}
+
+// within checks if the path p is the directory dir or is inside it.
+func within(dir, p string) bool {
+	var rel, err = filepath.Rel(dir, p)
+	return err == nil && local(rel)
+}
+
+func (u *uploads) addFile(arg, name string, info os.FileInfo) error {
+	b, err := ioutil.ReadFile(name) // #nosec
+
+	if err != nil {
+		return err
+	}
+
+	return u.add(uploadPath(arg, name), b, info.Mode().Perm())
+}
+
+// add the file, keeping the mode of executables.
+func (u *uploads) add(name string, b []byte, mode os.FileMode) error {
+	if _, ok := u.files[name]; ok {
+		return fmt.Errorf(`"%s" is uploaded more than once`, name)
+	}
+
+	var fv = decoding.FileValue{Data: b}
+
+	if mode&0111 != 0 {
+		var m = decoding.FileMode(mode)
+		fv.Mode = &m
+	}
+
+	u.files[name] = fv
+	return nil
+}
+
+// uploadPath of a local file read from the arg of -f or -k.
+// Paths relative to the working directory are kept, and other files are uploaded by their path from the arg.
+func uploadPath(arg, name string) string {
+	if local(arg) {
+		return filepath.ToSlash(filepath.Clean(name))
+	}
+
+	var abs, _ = filepath.Abs(arg)
+	var base = filepath.Base(abs)
+	var rel, err = filepath.Rel(arg, name)
+
+	if err != nil || rel == "." {
+		return base
+	}
+
+	return path.Join(filepath.ToSlash(base), filepath.ToSlash(rel))
+}
+
+// local checks if the path is relative to the working directory, and inside it.
+func local(p string) bool {
+	var clean = filepath.Clean(p)
+	return !filepath.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
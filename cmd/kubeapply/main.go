@@ -0,0 +1,134 @@
+// Command kubeapply runs kubectl commands on a kubeapply server, taking arguments like kubectl.
+//
+//	kubeapply apply -f k8s -R --dry-run=server -n web
+//
+// The files read with -f or -k are uploaded, and the output and exit code of kubectl are returned.
+// The server is set with --kubeapply-url or the KUBEAPPLY_URL environment variable,
+// and the cluster with --kubeapply-cluster or KUBEAPPLY_CLUSTER.
+// The KUBEAPPLY_TOKEN environment variable sets the API token for servers requiring one.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/henvic/ctxsignal"
+	"github.com/henvic/kubeapply/client"
+	"github.com/henvic/kubeapply/server"
+)
+
+// defaultURL of the kubeapply server.
+const defaultURL = "http://localhost:9000"
+
+func main() {
+	ctx, cancel := ctxsignal.WithTermination(context.Background())
+	var code = run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(code)
+}
+
+// run kubeapply, returning the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	inv, err := parseArgs(args)
+	var req server.ApplyRequestBody
+
+	if err == nil {
+		inv.applyEnv()
+		req, err = inv.request(stdin)
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+
+	var streamed bool
+	var output = func(stream, data string) {
+		streamed = true
+
+		switch stream {
+		case "stderr":
+			_, _ = io.WriteString(stderr, data)
+		default:
+			_, _ = io.WriteString(stdout, data)
+		}
+	}
+
+	var c = client.New(inv.url)
+	c.Token = os.Getenv("KUBEAPPLY_TOKEN")
+
+	resp, err := c.Apply(ctx, req, &client.Options{Output: output})
+
+	var ee *client.ExitError
+
+	if err != nil && !errors.As(err, &ee) {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if resp.Render != nil && resp.Render.ExitCode != 0 {
+		_, _ = io.WriteString(stderr, resp.Render.Stderr)
+	}
+
+	if !streamed {
+		_, _ = io.WriteString(stdout, string(resp.Stdout))
+		_, _ = io.WriteString(stderr, resp.Stderr)
+	}
+
+	if resp.ExitCode < 0 {
+		return 1
+	}
+
+	return resp.ExitCode
+}
+
+// applyEnv sets the server and the cluster from the environment, if not set.
+func (inv *invocation) applyEnv() {
+	if inv.url == "" {
+		inv.url = os.Getenv("KUBEAPPLY_URL")
+	}
+
+	if inv.url == "" {
+		inv.url = defaultURL
+	}
+
+	if inv.cluster == "" {
+		inv.cluster = os.Getenv("KUBEAPPLY_CLUSTER")
+	}
+}
+
+// request to send to the server, with the local files.
+func (inv *invocation) request(stdin io.Reader) (server.ApplyRequestBody, error) {
+	var u = newUploads(stdin)
+
+	for _, f := range inv.filenames {
+		if err := u.addFilename(f, inv.recursive); err != nil {
+			return server.ApplyRequestBody{}, err
+		}
+	}
+
+	switch len(inv.kustomizations) {
+	case 0:
+	case 1:
+		dir, err := u.addKustomization(inv.kustomizations[0])
+
+		if err != nil {
+			return server.ApplyRequestBody{}, err
+		}
+
+		inv.flags["--kustomize"] = []string{dir}
+	default:
+		return server.ApplyRequestBody{}, errors.New("only one -k can be specified")
+	}
+
+	return server.ApplyRequestBody{
+		Cluster: inv.cluster,
+		Command: inv.subcommand,
+		Args:    inv.args,
+		Flags:   inv.flags,
+		Files:   u.files,
+	}, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/server"
+)
+
+const fakeKubectl = `#!/bin/sh
+echo "uploaded: $(find . -type f ! -name description ! -name request ! -name idempotency | sort | tr '\n' ' ')" >&2
+case "$*" in
+*-x=fail*)
+	echo "error: failed" >&2
+	exit 3
+	;;
+esac
+echo "$*"
+`
+
+func newTestServer(t *testing.T) string {
+	var dir = t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubectl"), []byte(fakeKubectl), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var configurationsDir = kubeapply.ConfigurationsDir
+	kubeapply.ConfigurationsDir = filepath.Join(dir, "configurations")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t.Cleanup(func() {
+		cancel()
+		kubeapply.ConfigurationsDir = configurationsDir
+	})
+
+	h, err := server.Handler(ctx, server.Params{
+		KubeconfigsDir: filepath.Join(dir, "kubeconfigs"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ts = httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// chdirTemp changes the working directory to a temporary directory with the files.
+func chdirTemp(t *testing.T, files map[string]string) {
+	var dir = t.TempDir()
+
+	for name, content := range files {
+		var p = filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+}
+
+const configMap = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n"
+
+var runTests = []struct {
+	name       string
+	args       []string
+	stdin      string
+	wantStdout string
+	wantStderr string
+	wantCode   int
+}{
+	{
+		name:       "directory",
+		args:       []string{"apply", "-f", "k8s", "--dry-run=server"},
+		wantStdout: "apply --dry-run=server --filename=./ --recursive --output=json\n",
+		wantStderr: "uploaded: ./k8s/a.yaml \n",
+	},
+	{
+		name:       "recursive",
+		args:       []string{"apply", "-f", "k8s", "-R", "-n", "web"},
+		wantStdout: "apply -n=web --filename=./ --recursive --output=json\n",
+		wantStderr: "uploaded: ./k8s/a.yaml ./k8s/sub/b.json \n",
+	},
+	{
+		name:       "output",
+		args:       []string{"apply", "-f", "k8s", "-o", "yaml"},
+		wantStdout: "apply -o=yaml --filename=./ --recursive\n",
+		wantStderr: "uploaded: ./k8s/a.yaml \n",
+	},
+	{
+		name:       "stdin",
+		args:       []string{"apply", "-f", "-"},
+		stdin:      configMap,
+		wantStdout: "apply --filename=./ --recursive --output=json\n",
+		wantStderr: "uploaded: ./stdin.yaml \n",
+	},
+	{
+		name:       "kustomization",
+		args:       []string{"diff", "-k", "overlay"},
+		wantStdout: "diff --kustomize=overlay\n",
+		wantStderr: "uploaded: ./overlay/cm.yaml ./overlay/kustomization.yaml \n",
+	},
+	{
+		name:       "kustomization with base",
+		args:       []string{"diff", "-k", "overlays/prod"},
+		wantStdout: "diff --kustomize=overlays/prod\n",
+		wantStderr: "uploaded: ./base/cm.yaml ./base/kustomization.yaml ./overlays/prod/kustomization.yaml \n",
+	},
+	{
+		name:       "exit code",
+		args:       []string{"apply", "-f", "k8s/a.yaml", "-x=fail"},
+		wantStderr: "uploaded: ./k8s/a.yaml \nerror: failed\n",
+		wantCode:   3,
+	},
+	{
+		name:       "not a manifest",
+		args:       []string{"apply", "-f", "k8s/notes.txt"},
+		wantStderr: "error: \"k8s/notes.txt\" is not a manifest: use .json, .yaml, or .yml files\n",
+		wantCode:   1,
+	},
+	{
+		name:       "server error",
+		args:       []string{"apply", "deployment/web"},
+		wantStderr: "error: 400 Bad Request: positional arguments are not supported by subcommand \"apply\"\n",
+		wantCode:   1,
+	},
+}
+
+func TestRun(t *testing.T) {
+	t.Setenv("KUBEAPPLY_URL", newTestServer(t))
+
+	chdirTemp(t, map[string]string{
+		"k8s/a.yaml":                       configMap,
+		"k8s/notes.txt":                    "not a manifest",
+		"k8s/sub/b.json":                   `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "web"}}`,
+		"overlay/kustomization.yaml":       "resources:\n- cm.yaml\n",
+		"overlay/cm.yaml":                  configMap,
+		"base/kustomization.yaml":          "resources:\n- cm.yaml\n",
+		"base/cm.yaml":                     configMap,
+		"overlays/prod/kustomization.yaml": "resources:\n- ../../base\n- https://example.com/remote.yaml\n",
+	})
+
+	for _, tt := range runTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			var code = run(context.Background(), tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+			if code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d instead", tt.wantCode, code)
+			}
+
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("Expected stdout to be %q, got %q instead", tt.wantStdout, stdout.String())
+			}
+
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("Expected stderr to be %q, got %q instead", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestAddKustomizationOutside(t *testing.T) {
+	chdirTemp(t, map[string]string{
+		"base/kustomization.yaml":          "resources:\n- cm.yaml\n",
+		"base/cm.yaml":                     configMap,
+		"components/a/kustomization.yaml":  "kind: Component\n",
+		"overlays/prod/kustomization.yaml": "resources:\n- ../../base\ncomponents:\n- ../../components/a\n",
+	})
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir("overlays"); err != nil {
+		t.Fatal(err)
+	}
+
+	var u = newUploads(nil)
+	dir, err := u.addKustomization("prod")
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	var base = filepath.Base(wd)
+
+	if want := base + "/overlays/prod"; dir != want {
+		t.Errorf("Expected kustomization to be uploaded on %v, got %v instead", want, dir)
+	}
+
+	var want = []string{
+		base + "/base/cm.yaml",
+		base + "/base/kustomization.yaml",
+		base + "/components/a/kustomization.yaml",
+		base + "/overlays/prod/kustomization.yaml",
+	}
+
+	var got = []string{}
+
+	for f := range u.files {
+		got = append(got, f)
+	}
+
+	sort.Strings(got)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected uploaded files to be %v, got %v instead", want, got)
+	}
+}
+
+var uploadPathTests = []struct {
+	arg  string
+	name string
+	want string
+}{
+	{"k8s", "k8s/a.yaml", "k8s/a.yaml"},
+	{"./k8s/", "k8s/a.yaml", "k8s/a.yaml"},
+	{"a.yaml", "a.yaml", "a.yaml"},
+	{"../k8s", "../k8s/sub/a.yaml", "k8s/sub/a.yaml"},
+	{"/tmp/k8s", "/tmp/k8s/a.yaml", "k8s/a.yaml"},
+	{"/tmp/a.yaml", "/tmp/a.yaml", "a.yaml"},
+}
+
+func TestUploadPath(t *testing.T) {
+	for _, tt := range uploadPathTests {
+		if got := uploadPath(tt.arg, tt.name); got != tt.want {
+			t.Errorf("Expected uploadPath(%v, %v) = %v, got %v instead", tt.arg, tt.name, tt.want, got)
+		}
+	}
+}
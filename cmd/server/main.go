@@ -8,44 +8,247 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/hashicorp/errwrap"
 	"github.com/henvic/ctxsignal"
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/metrics"
 	"github.com/henvic/kubeapply/server"
+	"github.com/henvic/kubeapply/tracing"
 	log "github.com/sirupsen/logrus"
 )
 
 var params = server.Params{}
 
+var (
+	otlpEndpoint string
+	traceFile    string
+	clustersFile string
+	configFile   string
+
+	readinessContexts stringsFlag
+	envAllow          stringsFlag
+
+	sandbox        bool
+	sandboxOptions kubeapply.Sandbox
+)
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func main() {
+	kubeapply.SandboxInit()
 	rand.Seed(time.Now().UTC().UnixNano())
 	flag.Parse()
+	params.ReadinessContexts = readinessContexts
 
-	var debug = (os.Getenv("DEBUG") != "")
+	if sandbox {
+		params.Sandbox = &sandboxOptions
+	}
 
-	if debug {
-		log.SetLevel(log.DebugLevel)
+	if len(envAllow) != 0 {
+		params.EnvAllowlist = append(append([]string{}, kubeapply.DefaultEnvAllowlist...), envAllow...)
+	}
+
+	if clustersFile != "" {
+		var err error
+
+		if params.Clusters, err = server.LoadClusters(clustersFile); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	if debug || params.ExposeDebug {
+	var c, err = loadConfig()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	configureLogging(c.Logging)
+
+	if debug() || params.ExposeDebug {
 		go profiler()
 	}
 
+	if err := startTracing(); err != nil {
+		log.Fatal(err)
+	}
+
+	defer stopTracing()
+
 	ctx, cancel := ctxsignal.WithTermination(context.Background())
 	defer cancel()
 
-	if err := server.Start(ctx, params); err != nil {
+	var s = &server.Server{}
+
+	if configFile != "" {
+		go reloadOnHangup(ctx, s)
+	}
+
+	if err := s.Serve(ctx, c.Params(params)); err != nil {
 		log.Fatal(err)
 	}
 }
 
+func debug() bool {
+	return os.Getenv("DEBUG") != ""
+}
+
+// loadConfig file over the params set by the flags.
+func loadConfig() (server.Config, error) {
+	if configFile == "" {
+		return server.NewConfig(params), nil
+	}
+
+	return server.LoadConfig(configFile, params)
+}
+
+// configureLogging, using the debug level if the DEBUG environment variable is set.
+func configureLogging(l server.Logging) {
+	l.Configure()
+
+	if debug() {
+		log.SetLevel(log.DebugLevel)
+	}
+}
+
+// reloadOnHangup reloads the config file when the SIGHUP signal is received.
+func reloadOnHangup(ctx context.Context, s *server.Server) {
+	var sc = make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGHUP)
+	defer signal.Stop(sc)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sc:
+		}
+
+		log.Infof("Reloading config file %s", configFile)
+
+		c, err := loadConfig()
+
+		if err == nil {
+			err = s.Reload(c.Params(params))
+		}
+
+		if err != nil {
+			log.Errorf("cannot reload config file, keeping the current config: %v", err)
+			continue
+		}
+
+		configureLogging(c.Logging)
+		log.Info("Config file reloaded")
+	}
+}
+
+func startTracing() error {
+	var exporter tracing.Exporter
+	var err error
+
+	switch {
+	case otlpEndpoint != "":
+		exporter, err = tracing.NewOTLPExporter(otlpEndpoint)
+	case traceFile != "":
+		exporter, err = tracing.NewFileExporter(traceFile)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return errwrap.Wrapf("cannot start tracing: {{err}}", err)
+	}
+
+	tracing.SetDefault(tracing.NewTracer(exporter))
+	return nil
+}
+
+func stopTracing() {
+	var t = tracing.Default()
+
+	if t == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := t.Shutdown(ctx); err != nil {
+		log.Errorf("cannot flush traces: %v", err)
+	}
+}
+
 func profiler() {
 	// let expvar and pprof be exposed here indirectly through http.DefaultServeMux
-	log.Info("Exposing expvar and pprof on localhost:8081")
+	http.Handle("/metrics", metrics.Handler())
+	log.Info("Exposing expvar, pprof, and metrics on localhost:8081")
 	log.Fatal(http.ListenAndServe("localhost:8081", nil))
 }
 
 func init() {
+	flag.StringVar(&configFile, "config", "",
+		"YAML or JSON config file, overriding the flags it sets (reloaded on SIGHUP)")
 	flag.StringVar(&params.Address, "addr", "127.0.0.1:9000", "Serving address")
 	flag.BoolVar(&params.ExposeDebug, "expose-debug", true, "Expose debugging tools over HTTP (on port 8081)")
+	flag.IntVar(&params.MaxConcurrency, "max-concurrency", 16, "Maximum number of concurrent kubectl executions (0 for no limit)")
+	flag.Uint64Var(&params.MinFreeSpace, "min-free-space", server.DefaultMinFreeSpace,
+		"Minimum free space (in bytes) on the configurations directory for the service to be ready")
+	flag.DurationVar(&params.VersionCacheTTL, "version-cache-ttl", server.DefaultVersionCacheTTL,
+		"Duration the kubectl version responses are cached for")
+	flag.DurationVar(&params.IdempotencyTTL, "idempotency-ttl", server.DefaultIdempotencyTTL,
+		"Duration idempotency keys are kept for")
+	flag.IntVar(&params.IdempotencyMaxKeys, "idempotency-max-keys", server.DefaultIdempotencyMaxKeys,
+		"Maximum number of idempotency keys kept with their responses")
+	flag.DurationVar(&params.JobTTL, "job-ttl", server.DefaultJobTTL, "Duration finished background jobs are kept for")
+	flag.Var(&readinessContexts, "check-context", "Kubeconfig context checked for reachability by /readyz (repeatable)")
+	flag.StringVar(&clustersFile, "clusters", "", "JSON file with the list of cluster profiles")
+	flag.StringVar(&params.DefaultCluster, "default-cluster", "", "Cluster used by requests without a cluster")
+	flag.BoolVar(&params.CheckClusters, "check-clusters", false, "Check the cluster profiles for reachability on /readyz")
+	flag.StringVar(&params.KubeconfigsDir, "kubeconfigs-dir", server.DefaultKubeconfigsDir,
+		"Directory where the kubeconfigs registered through the admin API are stored")
+	flag.StringVar(&params.AdminToken, "admin-token", os.Getenv("KUBEAPPLY_ADMIN_TOKEN"),
+		"Bearer token for the admin API (default: $KUBEAPPLY_ADMIN_TOKEN; the admin API is disabled if empty)")
+	flag.Var(&envAllow, "env-allow", "Environment variable passed to kubectl in addition to the default allowlist (repeatable)")
+	flag.StringVar(&params.HomesDir, "homes-dir", "",
+		"Directory for the home directory of each cluster (default: a temporary home directory for each request)")
+	flag.Int64Var(&params.MaxBodySize, "max-body-size", server.DefaultMaxBodySize, "Maximum size of request bodies, in bytes")
+	flag.IntVar(&params.Limits.MaxFiles, "max-files", kubeapply.DefaultLimits.MaxFiles, "Maximum number of uploaded files")
+	flag.Int64Var(&params.Limits.MaxFileSize, "max-file-size", kubeapply.DefaultLimits.MaxFileSize,
+		"Maximum size of each uploaded file, in bytes")
+	flag.Int64Var(&params.Limits.MaxTotalSize, "max-total-size", kubeapply.DefaultLimits.MaxTotalSize,
+		"Maximum size of all uploaded files together, in bytes")
+	flag.IntVar(&params.Limits.MaxPathDepth, "max-path-depth", kubeapply.DefaultLimits.MaxPathDepth,
+		"Maximum number of components of the path of uploaded files")
+	flag.StringVar(&params.OpenAPISpec, "openapi-spec", "", "OpenAPI v2 specification file (swagger.json) used to validate objects")
+	flag.StringVar(&params.CRDsDir, "crds-dir", "", "Directory with CustomResourceDefinition objects used to validate custom resources")
+	flag.BoolVar(&params.ValidateBeforeApply, "validate", false, "Validate objects against the OpenAPI schemas before running kubectl")
+	flag.StringVar(&params.Helm, "helm", "", "helm executable used to render charts (charts are refused if empty)")
+	flag.BoolVar(&sandbox, "sandbox", false, "Run kubectl on a sandbox (Linux only)")
+	flag.BoolVar(&sandboxOptions.Namespaces, "sandbox-namespaces", true,
+		"Run kubectl on new mount, PID, and user namespaces with a read-only root filesystem on the sandbox")
+	flag.BoolVar(&sandboxOptions.Seccomp, "sandbox-seccomp", true,
+		"Set no new privileges and a seccomp profile denying dangerous system calls on the sandbox")
+	flag.DurationVar(&sandboxOptions.CPUTime, "sandbox-cpu-time", time.Minute, "CPU time limit of kubectl on the sandbox")
+	flag.Uint64Var(&sandboxOptions.Memory, "sandbox-memory", 4<<30, "Memory (address space) limit of kubectl on the sandbox, in bytes")
+	flag.Uint64Var(&sandboxOptions.OpenFiles, "sandbox-open-files", 1024, "Open files limit of kubectl on the sandbox")
+	flag.Uint64Var(&sandboxOptions.Processes, "sandbox-processes", 0,
+		"Processes limit of the user running kubectl on the sandbox (0 for no limit)")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"OpenTelemetry collector OTLP/HTTP endpoint for exporting traces")
+	flag.StringVar(&traceFile, "trace-file", "traces.json",
+		"File to export traces to when no OTLP endpoint is set (empty disables tracing)")
 }
@@ -2,6 +2,7 @@ package kubeapply
 
 import (
 	"encoding/json"
+	"errors"
 )
 
 // Output can be used to encode an outgoing output value to a JSON structure.
@@ -17,3 +18,20 @@ func (o Output) MarshalJSON() ([]byte, error) {
 
 	return b, nil
 }
+
+// UnmarshalJSON decodes a JSON string as is, or keeps any other JSON value encoded.
+func (o *Output) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err == nil {
+		*o = Output(s)
+		return nil
+	}
+
+	if !json.Valid(data) {
+		return errors.New("invalid JSON output value")
+	}
+
+	*o = Output(data)
+	return nil
+}
@@ -40,3 +40,36 @@ func TestDecoding(t *testing.T) {
 		})
 	}
 }
+
+var outputUnmarshalCases = []struct {
+	name string
+	in   string
+	want Output
+}{
+	{
+		name: "string",
+		in:   `"hi"`,
+		want: Output(`hi`),
+	},
+	{
+		name: "structure",
+		in:   `{"json": true}`,
+		want: Output(`{"json": true}`),
+	},
+}
+
+func TestOutputUnmarshal(t *testing.T) {
+	for _, tt := range outputUnmarshalCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Output
+
+			if err := got.UnmarshalJSON([]byte(tt.in)); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Expected Output.Unmarshal(%v) = %v, got %v instead", tt.in, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package kubeapply
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/henvic/kubeapply/tracing"
+)
+
+// DefaultEnvAllowlist of environment variables passed to kubectl from the current process.
+var DefaultEnvAllowlist = []string{
+	"PATH",
+	"LANG",
+	"LC_ALL",
+	"LC_CTYPE",
+	"TZ",
+	"SSL_CERT_FILE",
+	"SSL_CERT_DIR",
+	"HTTP_PROXY",
+	"HTTPS_PROXY",
+	"NO_PROXY",
+	"http_proxy",
+	"https_proxy",
+	"no_proxy",
+}
+
+// unredactedEnv are recorded with their values on the description file.
+var unredactedEnv = map[string]struct{}{
+	"HOME":         {},
+	"KUBECACHEDIR": {},
+	"KUBECONFIG":   {},
+	"LANG":         {},
+	"LC_ALL":       {},
+	"LC_CTYPE":     {},
+	"PATH":         {},
+	"TMPDIR":       {},
+	"TRACEPARENT":  {},
+	"TZ":           {},
+}
+
+// prepareEnviron creates the home directory and sets the environment of the kubectl process.
+// The returned function removes the home directory if it was created only for this request.
+func (a *Apply) prepareEnviron(ctx context.Context) (cleanup func(), err error) {
+	cleanup = func() {}
+
+	var home = a.Home
+
+	if home == "" {
+		if home, err = ioutil.TempDir("", "kubeapply-home-"); err != nil {
+			return cleanup, err
+		}
+
+		var tmp = home
+		cleanup = func() {
+			_ = os.RemoveAll(tmp)
+		}
+	}
+
+	if home, err = filepath.Abs(home); err != nil {
+		return cleanup, err
+	}
+
+	for _, d := range []string{home, kubeCacheDir(home), tmpDir(home)} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return cleanup, err
+		}
+	}
+
+	a.env = a.environ(ctx, home)
+	return cleanup, nil
+}
+
+// Environ of kubectl for running it outside of Run, such as for health checks, with the same home directory and variables.
+// The returned function removes the home directory if it was created only for this call.
+func (a *Apply) Environ(ctx context.Context) (env []string, cleanup func(), err error) {
+	cleanup, err = a.prepareEnviron(ctx)
+	return a.env, cleanup, err
+}
+
+func kubeCacheDir(home string) string {
+	return filepath.Join(home, ".kube", "cache")
+}
+
+func tmpDir(home string) string {
+	return filepath.Join(home, "tmp")
+}
+
+// environ of the kubectl process, containing only allowed variables from the current process.
+func (a *Apply) environ(ctx context.Context, home string) []string {
+	var m = map[string]string{}
+	var allowlist = a.EnvAllowlist
+
+	if allowlist == nil {
+		allowlist = DefaultEnvAllowlist
+	}
+
+	for _, k := range allowlist {
+		if v, ok := os.LookupEnv(k); ok {
+			m[k] = v
+		}
+	}
+
+	for k, v := range a.Env {
+		m[k] = v
+	}
+
+	m["HOME"] = home
+	m["KUBECACHEDIR"] = kubeCacheDir(home)
+	m["TMPDIR"] = tmpDir(home)
+
+	if kc := a.kubeconfig(); kc != "" {
+		m["KUBECONFIG"] = kc
+	}
+
+	if tp := tracing.Traceparent(ctx); tp != "" {
+		// let tools that understand W3C trace context continue the trace
+		m["TRACEPARENT"] = tp
+	}
+
+	var env = []string{}
+
+	for k, v := range m {
+		env = append(env, k+"="+v)
+	}
+
+	sort.Strings(env)
+	return env
+}
+
+// kubeconfig returns the absolute paths of the kubeconfig for the request,
+// defaulting to the kubeconfig of the current process.
+func (a *Apply) kubeconfig() string {
+	var kc = a.Kubeconfig
+
+	if kc == "" {
+		kc = os.Getenv("KUBECONFIG")
+	}
+
+	if kc == "" {
+		var home, err = os.UserHomeDir()
+
+		if err != nil {
+			return ""
+		}
+
+		kc = filepath.Join(home, ".kube", "config")
+	}
+
+	var paths = filepath.SplitList(kc)
+
+	for i, p := range paths {
+		if abs, err := filepath.Abs(p); err == nil {
+			paths[i] = abs
+		}
+	}
+
+	return strings.Join(paths, string(os.PathListSeparator))
+}
+
+// redactedEnviron is the environment of the kubectl process with secret values redacted.
+func (a *Apply) redactedEnviron() []string {
+	var env = []string{}
+
+	for _, kv := range a.env {
+		var p = strings.SplitN(kv, "=", 2)
+
+		if _, ok := unredactedEnv[p[0]]; !ok {
+			kv = p[0] + "=REDACTED"
+		}
+
+		env = append(env, kv)
+	}
+
+	return env
+}
@@ -0,0 +1,110 @@
+package kubeapply
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("DEBUG", "true")
+	t.Setenv("LANG", "C")
+
+	var kubeconfig = filepath.Join("kubeconfigs", "dev")
+	var wantKubeconfig, _ = filepath.Abs(kubeconfig)
+
+	var a = Apply{
+		Kubeconfig:   kubeconfig,
+		EnvAllowlist: []string{"PATH", "LANG"},
+		Env: map[string]string{
+			"AWS_PROFILE": "dev",
+		},
+	}
+
+	var home = t.TempDir()
+	var got = strings.Join(a.environ(context.Background(), home), "\n")
+
+	var want = strings.Join([]string{
+		"AWS_PROFILE=dev",
+		"HOME=" + home,
+		"KUBECACHEDIR=" + filepath.Join(home, ".kube", "cache"),
+		"KUBECONFIG=" + wantKubeconfig,
+		"LANG=C",
+		"PATH=/usr/bin",
+		"TMPDIR=" + filepath.Join(home, "tmp"),
+	}, "\n")
+
+	if got != want {
+		t.Errorf("Expected environment to be:\n%v\ngot:\n%v\ninstead", want, got)
+	}
+}
+
+func TestEnvironAllowlist(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("DEBUG", "true")
+
+	var a = Apply{
+		Kubeconfig:   "/kubeconfig",
+		EnvAllowlist: []string{"DEBUG"},
+	}
+
+	var env = a.environ(context.Background(), "/home")
+
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			t.Errorf("Expected PATH to not be passed, got %v instead", kv)
+		}
+	}
+
+	if env[0] != "DEBUG=true" {
+		t.Errorf("Expected DEBUG to be passed, got %v instead", env)
+	}
+}
+
+func TestRedactedEnviron(t *testing.T) {
+	var a = Apply{
+		env: []string{
+			"AWS_PROFILE=dev",
+			"HOME=/home",
+			"TOKEN=a=b",
+		},
+	}
+
+	var got = strings.Join(a.redactedEnviron(), "\n")
+	var want = "AWS_PROFILE=REDACTED\nHOME=/home\nTOKEN=REDACTED"
+
+	if got != want {
+		t.Errorf("Expected redacted environment to be:\n%v\ngot:\n%v\ninstead", want, got)
+	}
+}
+
+func TestPrepareEnvironTemporaryHome(t *testing.T) {
+	var a = Apply{}
+	var cleanup, err = a.prepareEnviron(context.Background())
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var home string
+
+	for _, kv := range a.env {
+		if strings.HasPrefix(kv, "HOME=") {
+			home = strings.TrimPrefix(kv, "HOME=")
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(home, ".kube", "cache")); err != nil {
+		t.Errorf("Expected kube cache directory to exist, got %v instead", err)
+	}
+
+	cleanup()
+
+	if _, err := os.Stat(home); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary home directory to be removed, got %v instead", err)
+	}
+}
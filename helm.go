@@ -0,0 +1,204 @@
+package kubeapply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/henvic/kubeapply/tracing"
+	"gopkg.in/yaml.v3"
+)
+
+// RenderedDir is the directory of a recording where rendered manifests and their inputs are saved.
+const RenderedDir = "rendered"
+
+const (
+	renderedValues    = RenderedDir + "/values.yaml"
+	renderedManifests = RenderedDir + "/manifests.yaml"
+)
+
+// Chart to render with helm template before running kubectl.
+type Chart struct {
+	// Path of the chart directory or packaged chart (.tgz) on the uploaded files.
+	Path string `json:"path"`
+
+	// Release name. The name of the chart is used if empty.
+	Release string `json:"release,omitempty"`
+
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+// Render is the result of rendering the manifests.
+type Render struct {
+	Command string   `json:"cmd"`
+	Args    []string `json:"args"`
+	CmdLine string   `json:"cmdline"`
+
+	Stderr string `json:"stderr"`
+
+	ExitCode int `json:"exit_code"`
+
+	// File with the rendered manifests, relative to the recording directory.
+	File string `json:"file,omitempty"`
+}
+
+// ErrHelmNotConfigured is returned when a chart is uploaded, but no helm executable is set.
+var ErrHelmNotConfigured = errors.New("cannot render chart: helm is not configured")
+
+var releaseNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// packagedChartRegex matches the name of packaged charts: <chart>-<version>.tgz
+var packagedChartRegex = regexp.MustCompile(`^(.+)-v?[0-9]+\.[0-9]+\.[0-9]+.*\.tgz$`)
+
+// maxReleaseNameLength accepted by helm.
+const maxReleaseNameLength = 53
+
+// release name of the chart.
+func (c *Chart) release() string {
+	if c.Release != "" {
+		return c.Release
+	}
+
+	var name = path.Base(path.Clean(c.Path))
+
+	if m := packagedChartRegex.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+
+	return strings.TrimSuffix(name, ".tgz")
+}
+
+// CheckChart verifies the chart can be rendered.
+func (a *Apply) CheckChart() error {
+	if a.Chart == nil {
+		return nil
+	}
+
+	if a.Helm == "" {
+		return ErrHelmNotConfigured
+	}
+
+	var p, err = CleanUploadPath(a.Chart.Path)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := a.Files[p]; !ok || path.Ext(p) != ".tgz" {
+		if _, ok := a.Files[path.Join(p, "Chart.yaml")]; !ok {
+			return fmt.Errorf(`chart "%s" not found: upload a .tgz file or a directory with a Chart.yaml file`, a.Chart.Path)
+		}
+	}
+
+	var release = a.Chart.release()
+
+	if len(release) > maxReleaseNameLength || !releaseNameRegex.MatchString(release) {
+		return fmt.Errorf(`invalid release name "%s"`, release)
+	}
+
+	return nil
+}
+
+// helmCommand to render the chart.
+func (a *Apply) helmCommand() []string {
+	var args = []string{
+		"template",
+		a.Chart.release(),
+		"./" + path.Clean(a.Chart.Path),
+		"--values=" + renderedValues,
+	}
+
+	if a.Namespace != "" {
+		args = append(args, "--namespace="+a.Namespace)
+	}
+
+	return args
+}
+
+// renderChart with helm template, saving the values and the rendered manifests on the recording.
+// The objects found on the rendered manifests are returned.
+func (a *Apply) renderChart(ctx context.Context) (*Render, []Object, error) {
+	ctx, span := tracing.Start(ctx, "renderChart")
+	defer span.End()
+
+	var args = a.helmCommand()
+	var r = &Render{
+		Command: a.Helm,
+		Args:    args,
+		CmdLine: cmdLine(a.Helm, args),
+	}
+
+	if !a.checkStateful() {
+		r.ExitCode = -1
+		r.Stderr = "cannot render chart without recording the request"
+		return r, nil, errors.New(r.Stderr)
+	}
+
+	var values = a.Chart.Values
+
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	var b, err = yaml.Marshal(values)
+
+	if err == nil {
+		err = a.saveRendered(renderedValues, b)
+	}
+
+	if err != nil {
+		r.ExitCode = -1
+		r.Stderr = err.Error()
+		return r, nil, err
+	}
+
+	stderr, stdout, err := a.run(ctx, a.Helm, args, nil, nil)
+	r.Stderr = stderr
+	r.ExitCode = getExitStatus(err)
+	span.SetAttribute("exit_code", r.ExitCode)
+
+	if err != nil {
+		span.RecordError(err)
+
+		if r.Stderr == "" {
+			r.Stderr = err.Error()
+		}
+
+		return r, nil, err
+	}
+
+	if err := a.saveRendered(renderedManifests, []byte(stdout)); err != nil {
+		r.ExitCode = -1
+		r.Stderr = err.Error()
+		return r, nil, err
+	}
+
+	r.File = renderedManifests
+
+	var objects = []Object{}
+	var docs, errs = parseManifest(renderedManifests, []byte(stdout))
+
+	for _, d := range docs {
+		objects = append(objects, d.object)
+	}
+
+	if len(errs) != 0 {
+		r.ExitCode = -1
+		r.Stderr = errs.Error()
+		return r, objects, errs
+	}
+
+	return r, objects, nil
+}
+
+func (a *Apply) saveRendered(name string, b []byte) error {
+	if err := writeUpload(a.dir, name, b, fileMode); err != nil {
+		RecordingWriteFailures.With().Inc()
+		return fmt.Errorf("cannot write %s: %v", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package kubeapply
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var chartReleaseCases = []struct {
+	chart Chart
+	want  string
+}{
+	{Chart{Path: "charts/web"}, "web"},
+	{Chart{Path: "charts/web/"}, "web"},
+	{Chart{Path: "web-1.2.3.tgz"}, "web"},
+	{Chart{Path: "web2-v0.1.0-rc.1.tgz"}, "web2"},
+	{Chart{Path: "web.tgz"}, "web"},
+	{Chart{Path: "web-1.2.3.tgz", Release: "prod"}, "prod"},
+}
+
+func TestChartRelease(t *testing.T) {
+	for _, tt := range chartReleaseCases {
+		if got := tt.chart.release(); got != tt.want {
+			t.Errorf("Expected release of %+v to be %v, got %v instead", tt.chart, tt.want, got)
+		}
+	}
+}
+
+var checkChartCases = []struct {
+	name    string
+	in      *Apply
+	wantErr string
+}{
+	{
+		name: "no chart",
+		in:   &Apply{},
+	},
+	{
+		name:    "helm not configured",
+		in:      &Apply{Chart: &Chart{Path: "web"}},
+		wantErr: ErrHelmNotConfigured.Error(),
+	},
+	{
+		name: "directory",
+		in: &Apply{
+			Helm:  "helm",
+			Chart: &Chart{Path: "charts/web"},
+			Files: map[string][]byte{"charts/web/Chart.yaml": nil},
+		},
+	},
+	{
+		name: "packaged",
+		in: &Apply{
+			Helm:  "helm",
+			Chart: &Chart{Path: "web-1.0.0.tgz"},
+			Files: map[string][]byte{"web-1.0.0.tgz": nil},
+		},
+	},
+	{
+		name: "not found",
+		in: &Apply{
+			Helm:  "helm",
+			Chart: &Chart{Path: "charts/api"},
+			Files: map[string][]byte{"charts/web/Chart.yaml": nil},
+		},
+		wantErr: `chart "charts/api" not found`,
+	},
+	{
+		name: "unsafe path",
+		in: &Apply{
+			Helm:  "helm",
+			Chart: &Chart{Path: "../web"},
+		},
+		wantErr: "unsafe filepath",
+	},
+	{
+		name: "invalid release",
+		in: &Apply{
+			Helm:  "helm",
+			Chart: &Chart{Path: "web", Release: "Web_1"},
+			Files: map[string][]byte{"web/Chart.yaml": nil},
+		},
+		wantErr: `invalid release name "Web_1"`,
+	},
+}
+
+func TestCheckChart(t *testing.T) {
+	for _, tt := range checkChartCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var err = tt.in.CheckChart()
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Expected error to contain %q, got %v instead", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// fakeHelm prints the values it receives as a ConfigMap, or fails if the chart is invalid.
+const fakeHelm = `#!/bin/sh
+if [ ! -f "$3/Chart.yaml" ]; then
+	echo "Error: invalid chart" >&2
+	exit 1
+fi
+echo "apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: $2
+data:
+  values: |"
+sed 's/^/    /' rendered/values.yaml
+`
+
+func TestRenderChart(t *testing.T) {
+	var dir = t.TempDir()
+	var helm = filepath.Join(dir, "helm")
+
+	if err := ioutil.WriteFile(helm, []byte(fakeHelm), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var configurationsDir = ConfigurationsDir
+	ConfigurationsDir = filepath.Join(dir, "configurations")
+	defer func() {
+		ConfigurationsDir = configurationsDir
+	}()
+
+	var a = &Apply{
+		Helm:       helm,
+		Chart:      &Chart{Path: "web", Values: map[string]interface{}{"replicas": 3}},
+		Files:      map[string][]byte{"web/Chart.yaml": []byte("name: web\n"), "web/templates/cm.yaml": []byte("{{ .Values }}")},
+		Namespace:  "prod",
+		executable: "echo",
+	}
+
+	var resp, err = a.Run(context.Background())
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v (%+v)", err, resp)
+	}
+
+	var wantArgs = []string{"template", "web", "./web", "--values=rendered/values.yaml", "--namespace=prod"}
+
+	if resp.Render == nil || !reflect.DeepEqual(resp.Render.Args, wantArgs) || resp.Render.File != "rendered/manifests.yaml" {
+		t.Errorf("Unexpected render %+v", resp.Render)
+	}
+
+	if want := "--filename=rendered/manifests.yaml"; !strings.Contains(resp.CmdLine, want) {
+		t.Errorf("Expected command line to contain %v, got %v instead", want, resp.CmdLine)
+	}
+
+	var wantObjects = []Object{{File: "rendered/manifests.yaml", Line: 1, APIVersion: "v1", Kind: "ConfigMap", Name: "web"}}
+
+	if !reflect.DeepEqual(resp.Objects, wantObjects) {
+		t.Errorf("Expected objects to be %+v, got %+v instead", wantObjects, resp.Objects)
+	}
+
+	values, err := ioutil.ReadFile(filepath.Join(resp.Dir, "rendered", "values.yaml"))
+
+	if err != nil || string(values) != "replicas: 3\n" {
+		t.Errorf("Expected values to be recorded, got (%q, %v) instead", values, err)
+	}
+
+	manifests, err := ioutil.ReadFile(filepath.Join(resp.Dir, "rendered", "manifests.yaml"))
+
+	if err != nil || !strings.Contains(string(manifests), "    replicas: 3\n") {
+		t.Errorf("Expected rendered manifests to be recorded, got (%q, %v) instead", manifests, err)
+	}
+}
+
+func TestRenderChartFailure(t *testing.T) {
+	var dir = t.TempDir()
+	var helm = filepath.Join(dir, "helm")
+
+	if err := ioutil.WriteFile(helm, []byte(fakeHelm), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var configurationsDir = ConfigurationsDir
+	ConfigurationsDir = filepath.Join(dir, "configurations")
+	defer func() {
+		ConfigurationsDir = configurationsDir
+	}()
+
+	var a = &Apply{
+		Helm:       helm,
+		Chart:      &Chart{Path: "web-1.0.0.tgz"},
+		Files:      map[string][]byte{"web-1.0.0.tgz": []byte("not a chart")},
+		executable: "echo",
+	}
+
+	var resp, err = a.Run(context.Background())
+
+	if err == nil {
+		t.Errorf("Expected error, got none")
+	}
+
+	if resp.Render == nil || resp.Render.ExitCode != 1 || resp.Render.Stderr != "Error: invalid chart\n" {
+		t.Errorf("Expected render to fail, got %+v instead", resp.Render)
+	}
+
+	if resp.ExitCode != -1 || resp.Stdout != "" {
+		t.Errorf("Expected kubectl not to run, got %+v instead", resp)
+	}
+
+	if _, err := os.Stat(filepath.Join(resp.Dir, "response")); err != nil {
+		t.Errorf("Expected response to be recorded: %v", err)
+	}
+}
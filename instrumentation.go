@@ -0,0 +1,58 @@
+package kubeapply
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henvic/kubeapply/metrics"
+)
+
+// Metrics of the kubectl executions.
+// They are registered on metrics.DefaultRegistry.
+var (
+	ExecutionsTotal = metrics.NewCounterVec("kubeapply_executions_total",
+		"Number of kubectl executions by subcommand and exit code.",
+		"subcommand", "exit_code")
+
+	ExecutionDuration = metrics.NewHistogramVec("kubeapply_execution_duration_seconds",
+		"Duration of kubectl executions in seconds.",
+		[]float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		"subcommand")
+
+	ExecutionsInFlight = metrics.NewGaugeVec("kubeapply_executions_in_flight",
+		"Number of kubectl executions in progress.")
+
+	RecordingWriteFailures = metrics.NewCounterVec("kubeapply_recording_write_failures_total",
+		"Number of failures writing recordings to the configurations directory.")
+)
+
+func init() {
+	metrics.DefaultRegistry.MustRegister(
+		ExecutionsTotal,
+		ExecutionDuration,
+		ExecutionsInFlight,
+		RecordingWriteFailures,
+	)
+}
+
+// subcommandLabel avoids using arbitrary values as label as it might lead to unbounded cardinality.
+func subcommandLabel(subcommand string) string {
+	if subcommand == "" || len(subcommand) > 64 {
+		return "other"
+	}
+
+	for _, c := range subcommand {
+		if (c < 'a' || c > 'z') && c != '-' && c != ' ' {
+			return "other"
+		}
+	}
+
+	return strings.TrimSpace(subcommand)
+}
+
+func observeExecution(subcommand string, exitCode int, start time.Time) {
+	var label = subcommandLabel(subcommand)
+	ExecutionsTotal.With(label, strconv.Itoa(exitCode)).Inc()
+	ExecutionDuration.With(label).Observe(time.Since(start).Seconds())
+}
@@ -5,9 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -15,8 +16,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/henvic/kubeapply/tracing"
 	uuid "github.com/satori/go.uuid"
-	log "github.com/sirupsen/logrus"
 )
 
 // Executable to call.
@@ -25,30 +26,44 @@ const Executable = "kubectl"
 // Command is the first argument of "kubectl".
 var Command = "apply"
 
-// configurations directory
-const configurations = "configurations"
+// ConfigurationsDir is the directory where the requests are recorded.
+var ConfigurationsDir = "configurations"
 
 const fileMode = os.FileMode(0644)
 const dirFileMode = os.FileMode(0755)
 
-// Flags for kubectl.
-type Flags map[string]string
+// Flags for kubectl. A flag is used once for each of its values, in order.
+// Use an empty string as value for flags without a value.
+type Flags map[string][]string
 
 // Keys of the used flags.
 func (f Flags) Keys() []string {
 	var keys = []string{}
 
-	for k := range f {
-		keys = append(keys, k)
+	for k, v := range f {
+		if len(v) != 0 {
+			keys = append(keys, k)
+		}
 	}
 
 	sort.Strings(keys)
 	return keys
 }
 
+// Get the last value of a flag, which is the one kubectl uses for flags that don't repeat.
+func (f Flags) Get(name string) (value string, ok bool) {
+	var values = f[name]
+
+	if len(values) == 0 {
+		return "", false
+	}
+
+	return values[len(values)-1], true
+}
+
 // Timeout for the task.
 func (f Flags) Timeout() (time.Duration, error) {
-	timeout, ok := f["timeout"]
+	timeout, ok := f.Get("timeout")
 
 	if !ok {
 		return 0, nil
@@ -62,10 +77,68 @@ type Apply struct {
 	Flags Flags
 	Files map[string][]byte
 
+	// Args are positional arguments passed after the subcommand, such as "deployment/web".
+	Args []string
+
 	IP string
 
+	// RequestID used by the client to correlate the request.
+	RequestID string
+
+	// IdempotencyKey and PayloadHash are recorded to detect retries of the same request.
+	IdempotencyKey string
+	PayloadHash    string
+
 	RequestDump []byte
 
+	// Cluster name. Requests are recorded on a directory named after the cluster.
+	Cluster string
+
+	// Kubeconfig passed to kubectl using the KUBECONFIG environment variable.
+	Kubeconfig string
+
+	// Context and Namespace used by default.
+	Context   string
+	Namespace string
+
+	// EnvAllowlist of environment variables passed to kubectl from the current process.
+	// DefaultEnvAllowlist is used if nil.
+	EnvAllowlist []string
+
+	// Env variables passed to kubectl.
+	Env map[string]string
+
+	// Limits on the uploaded files.
+	Limits Limits
+
+	// Modes of the uploaded files. Only the permission bits are used, and files are 0644 by default.
+	Modes map[string]os.FileMode
+
+	// Sandbox of the kubectl process. kubectl runs without a sandbox if nil.
+	Sandbox *Sandbox
+
+	// Chart to render with helm template. The rendered manifests are applied instead of the uploaded files.
+	Chart *Chart
+
+	// Helm executable used to render charts.
+	Helm string
+
+	// Vars used to render the templates of manifests (*.tmpl.yaml files). Templates are not rendered if nil.
+	Vars map[string]interface{}
+
+	// Stdout and Stderr receive a copy of the output of kubectl as it runs, if set.
+	// Write errors are ignored, so that a slow or gone reader doesn't interrupt kubectl.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Home directory of the kubectl process, also used for its cache and temporary files.
+	// A temporary directory is created for the request if empty.
+	Home string
+
+	env []string
+
+	rendered map[string][]byte
+
 	name string
 	args []string
 
@@ -82,14 +155,7 @@ type Apply struct {
 }
 
 func (a *Apply) listFiles() []string {
-	var list = []string{}
-
-	for f := range a.Files {
-		list = append(list, f)
-	}
-
-	sort.Strings(list)
-	return list
+	return sortedKeys(a.Files)
 }
 
 // Command prepared to be invoked by Run.
@@ -113,35 +179,49 @@ func (a *Apply) unsafeCommand() (string, []string) {
 	}
 
 	args := strings.Split(a.Subcommand, " ")
+	args = append(args, a.Args...)
 
 	var flags = a.Flags
 
 	var filenameFlag bool
 	var outputFlag bool
+	var namespaceFlag bool
 
 	for _, f := range flags.Keys() {
 		af := addFlag(f)
 
 		switch af {
-		case "-af", "--filename":
+		case "-f", "--filename", "-k", "--kustomize":
 			filenameFlag = true
 		case "-o", "--output":
 			outputFlag = true
+		case "-n", "--namespace":
+			namespaceFlag = true
 		}
 
-		switch v := flags[f]; {
-		case v == "":
-			args = append(args, af)
-		default:
-			args = append(args, fmt.Sprintf("%s=%s", af, v))
+		for _, v := range flags[f] {
+			switch {
+			case v == "":
+				args = append(args, af)
+			default:
+				args = append(args, fmt.Sprintf("%s=%s", af, v))
+			}
 		}
 	}
 
+	if a.Context != "" {
+		args = append(args, "--context="+a.Context)
+	}
+
+	if a.Namespace != "" && !namespaceFlag {
+		args = append(args, "--namespace="+a.Namespace)
+	}
+
 	if !filenameFlag {
 		args = append(args, a.addFilenameFlag()...)
 	}
 
-	if !outputFlag {
+	if sc, _ := lookupSubcommand(a.Subcommand); !outputFlag && !sc.noOutput {
 		args = append(args, "--output=json")
 	}
 
@@ -149,11 +229,32 @@ func (a *Apply) unsafeCommand() (string, []string) {
 }
 
 func (a *Apply) addFilenameFlag() []string {
+	if a.Chart != nil {
+		return []string{"--filename=" + renderedManifests}
+	}
+
+	if root, _ := a.KustomizationRoot(); root != "" {
+		return []string{a.kustomizeFlag(root)}
+	}
+
+	if a.usesTemplates() {
+		var args = []string{}
+
+		for _, f := range a.listFiles() {
+			switch {
+			case isTemplate(f):
+				args = append(args, "--filename="+renderedTemplate(f))
+			case IsManifest(f):
+				args = append(args, "--filename="+path.Clean(f))
+			}
+		}
+
+		return args
+	}
+
 	for filename := range a.Files {
 		// expect Kubernetes configuration objects
-		if strings.HasSuffix(filename, ".json") ||
-			strings.HasSuffix(filename, ".yaml") ||
-			strings.HasSuffix(filename, ".yml") {
+		if IsManifest(filename) {
 			return []string{"--filename=./", "--recursive"}
 		}
 	}
@@ -175,7 +276,8 @@ func addFlag(f string) string {
 
 // Response for the apply command.
 type Response struct {
-	ID string `json:"id,omitempty"`
+	ID        string `json:"id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
 
 	Command string   `json:"cmd"`
 	Args    []string `json:"args"`
@@ -187,6 +289,12 @@ type Response struct {
 	ExitCode int `json:"exit_code"`
 
 	Dir string `json:"dir,omitempty"`
+
+	// Objects found on the uploaded manifests.
+	Objects []Object `json:"objects,omitempty"`
+
+	// Render of the chart, if any. kubectl doesn't run if rendering fails.
+	Render *Render `json:"render,omitempty"`
 }
 
 func (r *Response) embedError(err error) {
@@ -209,21 +317,63 @@ func (r *Response) embedError(err error) {
 func (a *Apply) Run(ctx context.Context) (Response, error) {
 	a.init()
 
-	if err := a.maybeConfigure(); err != nil {
+	var objects, em = a.CheckManifests()
+
+	if em == nil {
+		em = a.CheckChart()
+	}
+
+	if em == nil {
+		em = a.CheckArgs()
+	}
+
+	if err := em; err != nil {
+		return Response{
+			Stderr:   err.Error(),
+			ExitCode: -1,
+		}, err
+	}
+
+	var cleanup, ep = a.prepareEnviron(ctx)
+	defer cleanup()
+
+	if err := ep; err != nil {
+		return Response{
+			Stderr:   err.Error(),
+			ExitCode: -1,
+		}, err
+	}
+
+	if err := a.maybeConfigure(ctx); err != nil {
 		return Response{
 			Stderr:   err.Error(),
 			ExitCode: -1,
 		}, err
 	}
 
+	var render *Render
+
+	if a.Chart != nil {
+		var err error
+
+		if render, objects, err = a.renderChart(ctx); err != nil {
+			return a.renderFailed(ctx, render, objects), err
+		}
+	}
+
+	ExecutionsInFlight.With().Inc()
+	var start = time.Now()
 	var stderr, stdout, err = a.cmdRun(ctx)
+	ExecutionsInFlight.With().Dec()
+	observeExecution(a.Subcommand, getExitStatus(err), start)
 
 	var r = Response{
-		ID: a.id,
+		ID:        a.id,
+		RequestID: a.RequestID,
 
 		Command: a.executable,
 		Args:    a.args,
-		CmdLine: strings.Join(append([]string{a.executable}, a.args...), " "),
+		CmdLine: cmdLine(a.executable, a.args),
 
 		Stderr: stderr,
 		Stdout: Output(stdout),
@@ -231,38 +381,107 @@ func (a *Apply) Run(ctx context.Context) (Response, error) {
 		ExitCode: getExitStatus(err),
 
 		Dir: a.dir,
+
+		Objects: objects,
+		Render:  render,
 	}
 
 	if err != nil {
 		r.embedError(err)
 	}
 
-	if esr := a.maybeSaveResponse(r); esr != nil {
-		log.Errorf("cannot save response for request %v: %v", a.id, esr)
+	if esr := a.maybeSaveResponse(ctx, r); esr != nil {
+		Logger(ctx).Errorf("cannot save response for request %v: %v", a.id, esr)
 	}
 
 	return r, err
 }
 
+// renderFailed responds a request that failed to render, without running kubectl.
+func (a *Apply) renderFailed(ctx context.Context, render *Render, objects []Object) Response {
+	var r = Response{
+		ID:        a.id,
+		RequestID: a.RequestID,
+
+		Command: a.executable,
+		Args:    a.args,
+		CmdLine: cmdLine(a.executable, a.args),
+
+		Stderr:   "kubectl not run: cannot render manifests",
+		ExitCode: -1,
+
+		Dir: a.dir,
+
+		Objects: objects,
+		Render:  render,
+	}
+
+	if esr := a.maybeSaveResponse(ctx, r); esr != nil {
+		Logger(ctx).Errorf("cannot save response for request %v: %v", a.id, esr)
+	}
+
+	return r
+}
+
 func (a *Apply) cmdRun(ctx context.Context) (stderr, stdout string, err error) {
-	var cmd = exec.CommandContext(ctx, a.name, a.args...) // #nosec
+	ctx, span := tracing.Start(ctx, "cmdRun")
+	defer span.End()
 
+	stderr, stdout, err = a.run(ctx, a.name, a.args, a.Stdout, a.Stderr)
+	span.SetAttribute("exit_code", getExitStatus(err))
+	span.RecordError(err)
+	return stderr, stdout, err
+}
+
+// run a command on the recording directory with the environment of the request, sandboxed if configured.
+// The output is also copied to the out and errOut writers, if not nil.
+func (a *Apply) run(ctx context.Context, name string, args []string, out, errOut io.Writer) (stderr, stdout string, err error) {
 	var (
 		buf    bytes.Buffer
 		bufErr bytes.Buffer
 	)
 
-	if a.checkStateful() {
-		cmd.Dir = a.dir
+	var newCommand = func() *exec.Cmd {
+		var cmd = exec.CommandContext(ctx, name, args...) // #nosec
+
+		if a.checkStateful() {
+			cmd.Dir = a.dir
+		}
+
+		cmd.Env = a.env
+
+		cmd.Stderr = tee(&bufErr, errOut)
+		cmd.Stdout = tee(&buf, out)
+		return cmd
 	}
 
-	cmd.Stderr = &bufErr
-	cmd.Stdout = &buf
+	if a.Sandbox != nil {
+		err = a.Sandbox.run(ctx, newCommand)
+	} else {
+		err = newCommand().Run()
+	}
 
-	err = cmd.Run()
 	return bufErr.String(), buf.String(), err
 }
 
+// tee writes to the buffer and to w, if not nil, ignoring errors writing to w.
+func tee(buf *bytes.Buffer, w io.Writer) io.Writer {
+	if w == nil {
+		return buf
+	}
+
+	return io.MultiWriter(buf, ignoreErrors{w})
+}
+
+type ignoreErrors struct {
+	w io.Writer
+}
+
+func (i ignoreErrors) Write(p []byte) (int, error) {
+	_, _ = i.w.Write(p)
+	return len(p), nil
+}
+
 // checkStateful checks if it is needed to save anything or you can just safely run the command
 func (a *Apply) checkStateful() bool {
 	if a.dontSave {
@@ -283,16 +502,22 @@ func (a *Apply) checkStateful() bool {
 	return true
 }
 
-func (a *Apply) maybeSaveResponse(r Response) error {
+func (a *Apply) maybeSaveResponse(ctx context.Context, r Response) error {
 	if !a.checkStateful() {
 		return nil
 	}
 
-	return a.saveResponse(r)
+	return a.saveResponse(ctx, r)
 }
 
-func (a *Apply) saveResponse(r Response) error {
-	var b, err = json.MarshalIndent(r, "", "    ")
+func (a *Apply) saveResponse(ctx context.Context, r Response) (err error) {
+	_, span := tracing.Start(ctx, "saveResponse")
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	b, err := json.MarshalIndent(r, "", "    ")
 
 	if err != nil {
 		return err
@@ -303,16 +528,27 @@ func (a *Apply) saveResponse(r Response) error {
 	return a.saveFile("response", b)
 }
 
-func (a *Apply) maybeConfigure() error {
+func (a *Apply) maybeConfigure(ctx context.Context) error {
 	if !a.checkStateful() {
 		return nil
 	}
 
-	return a.configure()
+	return a.configure(ctx)
 }
 
-func (a *Apply) configure() error {
-	if err := a.checkUploads(); err != nil {
+func (a *Apply) configure(ctx context.Context) error {
+	_, span := tracing.Start(ctx, "configure")
+	defer span.End()
+
+	span.SetAttribute("files", len(a.Files))
+
+	var err = a.record()
+	span.RecordError(err)
+	return err
+}
+
+func (a *Apply) record() error {
+	if err := a.CheckUploads(); err != nil {
 		return err
 	}
 
@@ -328,45 +564,78 @@ func (a *Apply) configure() error {
 		return err
 	}
 
+	if err := a.saveRenderedTemplates(); err != nil {
+		return err
+	}
+
+	if err := a.maybeSaveIdempotency(); err != nil {
+		return err
+	}
+
 	return a.saveFile("request", a.RequestDump)
 }
 
-var blacklist = map[string]struct{}{
-	"description": {},
-	"request":     {},
-	"response":    {},
+// IdempotencyRecord is stored on the "idempotency" file of a recording.
+type IdempotencyRecord struct {
+	Key         string    `json:"key"`
+	PayloadHash string    `json:"payload_hash"`
+	Date        time.Time `json:"date"`
 }
 
-func (a *Apply) checkUploads() error {
-	for f := range a.Files {
-		_, blocked := blacklist[f]
+func (a *Apply) maybeSaveIdempotency() error {
+	if a.IdempotencyKey == "" {
+		return nil
+	}
+
+	var b, err = json.Marshal(IdempotencyRecord{
+		Key:         a.IdempotencyKey,
+		PayloadHash: a.PayloadHash,
+		Date:        a.timestamp,
+	})
 
-		if blocked || strings.Contains(f, "..") || filepath.IsAbs(f) {
-			return fmt.Errorf(`refusing to apply: unsafe filepath "%s"`, f)
-		}
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return a.saveFile("idempotency", append(b, '\n'))
+}
+
+var blacklist = map[string]struct{}{
+	"description": {},
+	"idempotency": {},
+	"request":     {},
+	"response":    {},
+	RenderedDir:   {},
 }
 
 func (a *Apply) initConfigurationDir() error {
 	if err := os.MkdirAll(a.dir, dirFileMode); err != nil {
+		RecordingWriteFailures.With().Inc()
 		return fmt.Errorf("can't create files configuration directory: %v", err)
 	}
 
 	return nil
 }
 
+func (a *Apply) fileMode(name string) os.FileMode {
+	if m, ok := a.Modes[name]; ok {
+		return m.Perm()
+	}
+
+	return fileMode
+}
+
 func (a *Apply) copyConfigurationFiles() error {
 	for f, v := range a.Files {
-		file := filepath.Join(a.dir, f)
+		var clean, err = CleanUploadPath(f)
 
-		if err := os.MkdirAll(filepath.Dir(file), dirFileMode); err != nil {
-			return fmt.Errorf("can't create files configuration directory: %v", err)
+		if err != nil {
+			return err
 		}
 
-		if err := ioutil.WriteFile(file, v, fileMode); err != nil {
-			return fmt.Errorf("error writing %s (%s): %v", f, file, err)
+		if err := writeUpload(a.dir, clean, v, a.fileMode(f)); err != nil {
+			RecordingWriteFailures.With().Inc()
+			return fmt.Errorf("error writing %s: %v", f, err)
 		}
 	}
 
@@ -374,12 +643,17 @@ func (a *Apply) copyConfigurationFiles() error {
 }
 
 const descriptionTemplate = `ID: %s
+Request ID: %s
+Cluster: %s
 Date: %v
 IP: %v
 
 Command:
 %s %s
 
+Environment:
+%s
+
 List of files:
 %v
 `
@@ -389,10 +663,13 @@ func (a *Apply) saveDescription() error {
 
 	var description = []byte(fmt.Sprintf(descriptionTemplate,
 		a.id,
+		a.RequestID,
+		a.Cluster,
 		a.timestamp.Format(time.RubyDate),
 		a.IP,
 		a.name,
 		strings.Join(a.args, " "),
+		strings.Join(a.redactedEnviron(), "\n"),
 		strings.Join(files, "\n"),
 	))
 
@@ -402,7 +679,8 @@ func (a *Apply) saveDescription() error {
 func (a *Apply) saveFile(name string, b []byte) error {
 	file := filepath.Join(a.dir, name)
 
-	if err := ioutil.WriteFile(file, b, fileMode); err != nil {
+	if err := writeNewFile(file, b, fileMode); err != nil {
+		RecordingWriteFailures.With().Inc()
 		return fmt.Errorf("cannot write %s: %v", file, err)
 	}
 
@@ -431,7 +709,8 @@ func (a *Apply) init() {
 		a.id = uuid.NewV4().String()
 
 		a.timestamp = time.Now()
-		a.dir = filepath.Join(configurations,
+		a.dir = filepath.Join(ConfigurationsDir,
+			a.Cluster,
 			a.timestamp.Format("2006-01-02"),
 			fmt.Sprintf("%v", a.timestamp.Unix())+"-"+a.id)
 	}
@@ -1,9 +1,11 @@
 package kubeapply
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"testing"
 	"time"
@@ -28,12 +30,12 @@ var flagsTests = []struct {
 	{
 		"unsorted",
 		Flags{
-			"b":   "",
-			"a":   "",
-			"-x":  "",
-			"c":   "",
-			"--z": "",
-			"d":   "",
+			"b":   {""},
+			"a":   {""},
+			"-x":  {""},
+			"c":   {""},
+			"--z": {""},
+			"d":   {""},
 		},
 		[]string{"--z", "-x", "a", "b", "c", "d"},
 		0,
@@ -42,14 +44,14 @@ var flagsTests = []struct {
 	{
 		"unsorted with timeout",
 		Flags{
-			"b":   "",
-			"a":   "none",
-			"-x":  "abc",
-			"c":   "",
-			"--z": "",
-			"d":   "def",
-
-			"timeout": "3m",
+			"b":   {""},
+			"a":   {"none"},
+			"-x":  {"abc"},
+			"c":   {""},
+			"--z": {""},
+			"d":   {"def"},
+
+			"timeout": {"3m"},
 		},
 		[]string{"--z", "-x", "a", "b", "c", "d", "timeout"},
 		3 * time.Minute,
@@ -58,21 +60,40 @@ var flagsTests = []struct {
 	{
 		"unsorted with invalid timeout",
 		Flags{
-			"b":   "",
-			"a":   "none",
-			"-x":  "abc",
-			"c":   "",
-			"--z": "true",
-			"d":   "def",
-
-			"timeout": "invalid",
+			"b":   {""},
+			"a":   {"none"},
+			"-x":  {"abc"},
+			"c":   {""},
+			"--z": {"true"},
+			"d":   {"def"},
+
+			"timeout": {"invalid"},
 		},
 		[]string{"--z", "-x", "a", "b", "c", "d", "timeout"},
 		0,
-		errors.New("time: invalid duration invalid"),
+		errors.New(`time: invalid duration "invalid"`),
 	},
 }
 
+func TestFlagsGet(t *testing.T) {
+	var f = Flags{
+		"timeout": {"1m", "2m"},
+		"empty":   {},
+	}
+
+	if v, ok := f.Get("timeout"); v != "2m" || !ok {
+		t.Errorf("Expected last value of repeated flag, got (%v, %v) instead", v, ok)
+	}
+
+	if v, ok := f.Get("empty"); v != "" || ok {
+		t.Errorf("Expected flag without values to be unset, got (%v, %v) instead", v, ok)
+	}
+
+	if timeout, err := f.Timeout(); timeout != 2*time.Minute || err != nil {
+		t.Errorf("Expected timeout to be 2m, got (%v, %v) instead", timeout, err)
+	}
+}
+
 func TestFlags(t *testing.T) {
 	for _, tt := range flagsTests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -110,7 +131,7 @@ var applyCommandTests = []struct {
 		"apply -f file.yaml",
 		&Apply{
 			Flags: Flags{
-				"f": "file.yaml",
+				"f": {"file.yaml"},
 			},
 		},
 		"kubectl",
@@ -120,7 +141,7 @@ var applyCommandTests = []struct {
 		"apply forced",
 		&Apply{
 			Flags: Flags{
-				"--force": "",
+				"--force": {""},
 			},
 		},
 		"kubectl",
@@ -130,9 +151,9 @@ var applyCommandTests = []struct {
 		"apply forced with timeout",
 		&Apply{
 			Flags: Flags{
-				"timeout": "1m",
-				"--force": "",
-				"-f":      "file.yaml",
+				"timeout": {"1m"},
+				"--force": {""},
+				"-f":      {"file.yaml"},
 			},
 		},
 		"kubectl",
@@ -142,14 +163,89 @@ var applyCommandTests = []struct {
 		"apply forced with timeout in bad order",
 		&Apply{
 			Flags: Flags{
-				"timeout=1m": "",
-				"--force":    "",
-				"-f":         "file.yaml",
+				"timeout=1m": {""},
+				"--force":    {""},
+				"-f":         {"file.yaml"},
 			},
 		},
 		"kubectl",
 		[]string{"apply", "--force", "-f=file.yaml", "--timeout=1m", "--output=json"},
 	},
+	{
+		"apply with cluster defaults",
+		&Apply{
+			Context:   "production",
+			Namespace: "default",
+			Flags: Flags{
+				"-f": {"file.yaml"},
+			},
+		},
+		"kubectl",
+		[]string{"apply", "-f=file.yaml", "--context=production", "--namespace=default", "--output=json"},
+	},
+	{
+		"apply with namespace overriding cluster default",
+		&Apply{
+			Context:   "production",
+			Namespace: "default",
+			Flags: Flags{
+				"n": {"web"},
+			},
+		},
+		"kubectl",
+		[]string{"apply", "-n=web", "--context=production", "--output=json"},
+	},
+	{
+		"apply with repeated flags",
+		&Apply{
+			Flags: Flags{
+				"f":               {"a.yaml", "b.yaml"},
+				"field-selector":  {"status.phase=Running"},
+				"l":               {"app=web"},
+				"prune":           {""},
+				"prune-whitelist": {"core/v1/ConfigMap", "apps/v1/Deployment"},
+				"unused":          {},
+			},
+		},
+		"kubectl",
+		[]string{"apply", "-f=a.yaml", "-f=b.yaml", "--field-selector=status.phase=Running", "-l=app=web",
+			"--prune", "--prune-whitelist=core/v1/ConfigMap", "--prune-whitelist=apps/v1/Deployment", "--output=json"},
+	},
+	{
+		"get with positional arguments",
+		&Apply{
+			Subcommand: "get",
+			Args:       []string{"deployment", "web server"},
+			Flags: Flags{
+				"n": {"web"},
+			},
+		},
+		"kubectl",
+		[]string{"get", "deployment", "web server", "-n=web", "--output=json"},
+	},
+	{
+		"rollout status without output",
+		&Apply{
+			Subcommand: "rollout status",
+			Args:       []string{"deployment/web"},
+			Flags: Flags{
+				"timeout": {"1m"},
+			},
+		},
+		"kubectl",
+		[]string{"rollout", "status", "deployment/web", "--timeout=1m"},
+	},
+	{
+		"diff without output",
+		&Apply{
+			Subcommand: "diff",
+			Flags: Flags{
+				"f": {"file.yaml"},
+			},
+		},
+		"kubectl",
+		[]string{"diff", "-f=file.yaml"},
+	},
 }
 
 func TestApplyCommand(t *testing.T) {
@@ -191,7 +287,7 @@ var applyRunTests = []struct {
 		context.Background(),
 		&Apply{
 			Flags: Flags{
-				"f": "file.yaml",
+				"f": {"file.yaml"},
 			},
 		},
 		"echo",
@@ -205,7 +301,7 @@ var applyRunTests = []struct {
 		context.Background(),
 		&Apply{
 			Flags: Flags{
-				"f": "file.yaml",
+				"f": {"file.yaml"},
 			},
 		},
 		"echo-not-found-12395234",
@@ -220,7 +316,7 @@ var applyRunTests = []struct {
 		context.Background(),
 		&Apply{
 			Flags: Flags{
-				"-": "",
+				"-": {""},
 			},
 		},
 		"go",
@@ -268,3 +364,36 @@ func mockBlacklist() {
 func restoreBlacklist() {
 	blacklist = restoredBlacklist
 }
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("reader is gone")
+}
+
+func TestRunApplyOutput(t *testing.T) {
+	var stdout bytes.Buffer
+
+	for _, w := range []io.Writer{&stdout, failingWriter{}} {
+		var a = &Apply{
+			Subcommand: "hello",
+			Stdout:     w,
+			executable: "echo",
+			dontSave:   true,
+		}
+
+		var resp, err = a.Run(context.Background())
+
+		if err != nil {
+			t.Fatalf("Expected no error, got %v instead", err)
+		}
+
+		if want := "hello --output=json\n"; string(resp.Stdout) != want {
+			t.Errorf("Expected stdout to be %q, got %q instead", want, resp.Stdout)
+		}
+	}
+
+	if want := "hello --output=json\n"; stdout.String() != want {
+		t.Errorf("Expected output to be copied to the writer, got %q instead", stdout.String())
+	}
+}
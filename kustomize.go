@@ -0,0 +1,97 @@
+package kubeapply
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kustomizationFiles recognized by kustomize.
+var kustomizationFiles = map[string]struct{}{
+	"kustomization.yaml": {},
+	"kustomization.yml":  {},
+	"Kustomization":      {},
+}
+
+type kustomization struct {
+	Resources  []string `yaml:"resources"`
+	Bases      []string `yaml:"bases"`
+	Components []string `yaml:"components"`
+}
+
+// KustomizationRoot returns the directory of the uploaded kustomization that isn't used by any other,
+// or an empty string if there is none.
+func (a *Apply) KustomizationRoot() (string, error) {
+	var dirs = []string{}
+	var used = map[string]bool{}
+
+	for _, f := range a.listFiles() {
+		var clean = path.Clean(f)
+
+		if _, ok := kustomizationFiles[path.Base(clean)]; !ok {
+			continue
+		}
+
+		var dir = path.Dir(clean)
+		dirs = append(dirs, dir)
+
+		var k kustomization
+
+		if err := yaml.Unmarshal(a.Files[f], &k); err != nil {
+			return "", ManifestErrors{syntaxError(f, err)}
+		}
+
+		for _, r := range append(append(k.Resources, k.Bases...), k.Components...) {
+			used[path.Join(dir, r)] = true
+		}
+	}
+
+	var roots = []string{}
+
+	for _, d := range dirs {
+		if !used[d] {
+			roots = append(roots, d)
+		}
+	}
+
+	switch {
+	case len(roots) == 1:
+		return roots[0], nil
+	case len(roots) > 1:
+		return "", fmt.Errorf("refusing to apply: multiple kustomization roots found (%s): set the kustomize flag",
+			strings.Join(roots, ", "))
+	case len(dirs) != 0:
+		return "", fmt.Errorf("refusing to apply: kustomizations use each other in a cycle (%s)", strings.Join(dirs, ", "))
+	}
+
+	return "", nil
+}
+
+// kustomizeFlag for kubectl, or the positional argument for kubectl kustomize.
+func (a *Apply) kustomizeFlag(root string) string {
+	var dir = "./"
+
+	if root != "." {
+		dir += root
+	}
+
+	if a.Subcommand == "kustomize" {
+		return dir
+	}
+
+	return "--kustomize=" + dir
+}
+
+// hasFilenameFlag checks if the files to use were set with the filename or kustomize flags.
+func (a *Apply) hasFilenameFlag() bool {
+	for _, f := range a.Flags.Keys() {
+		switch strings.TrimLeft(f, "-") {
+		case "f", "filename", "k", "kustomize":
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,159 @@
+package kubeapply
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var kustomizationRootCases = []struct {
+	name    string
+	files   map[string][]byte
+	want    string
+	wantErr string
+}{
+	{
+		name:  "no kustomization",
+		files: map[string][]byte{"app.yaml": []byte("")},
+	},
+	{
+		name: "top-level",
+		files: map[string][]byte{
+			"kustomization.yaml": []byte("resources:\n- app.yaml\n"),
+			"app.yaml":           []byte(""),
+		},
+		want: ".",
+	},
+	{
+		name: "overlay",
+		files: map[string][]byte{
+			"base/Kustomization":                []byte("resources:\n- app.yaml\n"),
+			"base/app.yaml":                     []byte(""),
+			"overlays/prod/kustomization.yml":   []byte("resources:\n- ../../base\n"),
+			"overlays/prod/replicas-patch.yaml": []byte(""),
+		},
+		want: "overlays/prod",
+	},
+	{
+		name: "multiple roots",
+		files: map[string][]byte{
+			"base/kustomization.yaml":          []byte("resources:\n- app.yaml\n"),
+			"overlays/dev/kustomization.yaml":  []byte("resources:\n- ../../base\n"),
+			"overlays/prod/kustomization.yaml": []byte("bases:\n- ../../base\n"),
+		},
+		wantErr: "multiple kustomization roots found (overlays/dev, overlays/prod)",
+	},
+	{
+		name: "cycle",
+		files: map[string][]byte{
+			"a/kustomization.yaml": []byte("resources:\n- ../b\n"),
+			"b/kustomization.yaml": []byte("components:\n- ../a\n"),
+		},
+		wantErr: "kustomizations use each other in a cycle (a, b)",
+	},
+	{
+		name: "invalid",
+		files: map[string][]byte{
+			"kustomization.yaml": []byte("resources: [\n"),
+		},
+		wantErr: "kustomization.yaml",
+	},
+}
+
+func TestKustomizationRoot(t *testing.T) {
+	for _, tt := range kustomizationRootCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var a = Apply{Files: tt.files}
+			var got, err = a.KustomizationRoot()
+
+			if got != tt.want {
+				t.Errorf("Expected root to be %q, got %q instead", tt.want, got)
+			}
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Expected error to contain %q, got %v instead", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+var kustomizeCommandCases = []struct {
+	name string
+	in   *Apply
+	want []string
+}{
+	{
+		name: "apply",
+		in: &Apply{
+			Files: map[string][]byte{
+				"overlays/prod/kustomization.yaml": []byte("resources:\n- ../../base\n"),
+				"base/kustomization.yaml":          []byte("resources:\n- app.yaml\n"),
+				"base/app.yaml":                    []byte(""),
+			},
+		},
+		want: []string{"apply", "--kustomize=./overlays/prod", "--output=json"},
+	},
+	{
+		name: "kustomize",
+		in: &Apply{
+			Subcommand: "kustomize",
+			Files: map[string][]byte{
+				"kustomization.yaml": []byte("resources:\n- app.yaml\n"),
+				"app.yaml":           []byte(""),
+			},
+		},
+		want: []string{"kustomize", "./"},
+	},
+	{
+		name: "kustomize flag",
+		in: &Apply{
+			Flags: Flags{"k": {"overlays/dev"}},
+			Files: map[string][]byte{
+				"overlays/dev/kustomization.yaml":  []byte(""),
+				"overlays/prod/kustomization.yaml": []byte(""),
+			},
+		},
+		want: []string{"apply", "-k=overlays/dev", "--output=json"},
+	},
+}
+
+func TestKustomizeCommand(t *testing.T) {
+	for _, tt := range kustomizeCommandCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var _, got = tt.in.Command()
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected arguments to be %v, got %v instead", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckManifestsKustomization(t *testing.T) {
+	var a = Apply{
+		Files: map[string][]byte{
+			// patches aren't complete objects
+			"kustomization.yaml": []byte("resources:\n- app.yaml\npatches:\n- path: patch.yaml\n"),
+			"patch.yaml":         []byte("kind: Deployment\n"),
+		},
+	}
+
+	if objects, err := a.CheckManifests(); objects != nil || err != nil {
+		t.Errorf("Expected kustomization not to be checked, got (%v, %v) instead", objects, err)
+	}
+
+	a.Files["overlay/kustomization.yaml"] = []byte("")
+
+	if _, err := a.CheckManifests(); err == nil || !strings.Contains(err.Error(), "set the kustomize flag") {
+		t.Errorf("Expected multiple kustomization roots error, got %v instead", err)
+	}
+
+	a.Flags = Flags{"kustomize": {"overlay"}}
+
+	if _, err := a.CheckManifests(); err != nil {
+		t.Errorf("Expected kustomize flag to be used, got error %v instead", err)
+	}
+}
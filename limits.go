@@ -0,0 +1,119 @@
+package kubeapply
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Limits on the uploaded files. Zero values mean no limit.
+type Limits struct {
+	// MaxFiles is the maximum number of files.
+	MaxFiles int
+
+	// MaxFileSize is the maximum size of each file, in bytes.
+	MaxFileSize int64
+
+	// MaxTotalSize is the maximum size of all files together, in bytes.
+	MaxTotalSize int64
+
+	// MaxPathDepth is the maximum number of components of each file path.
+	MaxPathDepth int
+}
+
+// DefaultLimits on the uploaded files.
+var DefaultLimits = Limits{
+	MaxFiles:     1000,
+	MaxFileSize:  8 << 20,
+	MaxTotalSize: 32 << 20,
+	MaxPathDepth: 16,
+}
+
+// LimitError is returned when a limit is exceeded.
+type LimitError struct {
+	// Limit exceeded, such as "max_files".
+	Limit string
+
+	// Max value allowed by the limit.
+	Max int64
+
+	// Value that exceeded the limit, or -1 if unknown.
+	Value int64
+
+	// File that exceeded the limit, if any.
+	File string
+}
+
+func (e *LimitError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf(`refusing to apply: limit %s of %d exceeded by "%s" (%d)`, e.Limit, e.Max, e.File, e.Value)
+	}
+
+	if e.Value < 0 {
+		return fmt.Sprintf("refusing to apply: limit %s of %d exceeded", e.Limit, e.Max)
+	}
+
+	return fmt.Sprintf("refusing to apply: limit %s of %d exceeded (%d)", e.Limit, e.Max, e.Value)
+}
+
+// Check if the files are within the limits.
+func (l Limits) Check(files map[string][]byte) error {
+	if l.MaxFiles != 0 && len(files) > l.MaxFiles {
+		return &LimitError{
+			Limit: "max_files",
+			Max:   int64(l.MaxFiles),
+			Value: int64(len(files)),
+		}
+	}
+
+	var total int64
+
+	for _, f := range sortedKeys(files) {
+		var size = int64(len(files[f]))
+		total += size
+
+		if l.MaxFileSize != 0 && size > l.MaxFileSize {
+			return &LimitError{
+				Limit: "max_file_size",
+				Max:   l.MaxFileSize,
+				Value: size,
+				File:  f,
+			}
+		}
+
+		if depth := pathDepth(f); l.MaxPathDepth != 0 && depth > l.MaxPathDepth {
+			return &LimitError{
+				Limit: "max_path_depth",
+				Max:   int64(l.MaxPathDepth),
+				Value: int64(depth),
+				File:  f,
+			}
+		}
+	}
+
+	if l.MaxTotalSize != 0 && total > l.MaxTotalSize {
+		return &LimitError{
+			Limit: "max_total_size",
+			Max:   l.MaxTotalSize,
+			Value: total,
+		}
+	}
+
+	return nil
+}
+
+func pathDepth(name string) int {
+	return len(strings.Split(filepath.ToSlash(filepath.Clean(name)), "/"))
+}
+
+func sortedKeys(files map[string][]byte) []string {
+	var list = []string{}
+
+	for f := range files {
+		list = append(list, f)
+	}
+
+	sort.Strings(list)
+	return list
+}
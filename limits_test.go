@@ -0,0 +1,71 @@
+package kubeapply
+
+import "testing"
+
+var limitsCases = []struct {
+	name   string
+	limits Limits
+	files  map[string][]byte
+	want   string
+}{
+	{
+		name:   "no limits",
+		limits: Limits{},
+		files:  map[string][]byte{"a/b/c/d.yaml": []byte("abc")},
+	},
+	{
+		name:   "within limits",
+		limits: Limits{MaxFiles: 2, MaxFileSize: 3, MaxTotalSize: 6, MaxPathDepth: 2},
+		files:  map[string][]byte{"a.yaml": []byte("abc"), "b/c.yaml": []byte("abc")},
+	},
+	{
+		name:   "too many files",
+		limits: Limits{MaxFiles: 1},
+		files:  map[string][]byte{"a.yaml": nil, "b.yaml": nil},
+		want:   "max_files",
+	},
+	{
+		name:   "file too large",
+		limits: Limits{MaxFileSize: 2},
+		files:  map[string][]byte{"a.yaml": []byte("ab"), "b.yaml": []byte("abc")},
+		want:   "max_file_size",
+	},
+	{
+		name:   "files too large",
+		limits: Limits{MaxTotalSize: 5},
+		files:  map[string][]byte{"a.yaml": []byte("abc"), "b.yaml": []byte("abc")},
+		want:   "max_total_size",
+	},
+	{
+		name:   "path too deep",
+		limits: Limits{MaxPathDepth: 2},
+		files:  map[string][]byte{"a/b/c.yaml": nil},
+		want:   "max_path_depth",
+	},
+}
+
+func TestLimitsCheck(t *testing.T) {
+	for _, tt := range limitsCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var err = tt.limits.Check(tt.files)
+
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			le, ok := err.(*LimitError)
+
+			if !ok {
+				t.Fatalf("Expected *LimitError, got %v instead", err)
+			}
+
+			if le.Limit != tt.want {
+				t.Errorf("Expected limit %v to be exceeded, got %v instead", tt.want, le.Limit)
+			}
+		})
+	}
+}
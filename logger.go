@@ -0,0 +1,23 @@
+package kubeapply
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx with a request-scoped logger.
+func ContextWithLogger(ctx context.Context, l *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// Logger returns the request-scoped logger of the context, or the standard logger.
+func Logger(ctx context.Context) *log.Entry {
+	if l, ok := ctx.Value(loggerKey{}).(*log.Entry); ok {
+		return l
+	}
+
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,399 @@
+package kubeapply
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/henvic/kubeapply/openapi"
+	"gopkg.in/yaml.v3"
+)
+
+// Object found on the uploaded manifests.
+type Object struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+// ManifestError is an error found on an uploaded manifest.
+type ManifestError struct {
+	File string `json:"file"`
+	Line int    `json:"line,omitempty"`
+
+	// Path of the invalid field, in JSONPath notation.
+	Path string `json:"path,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (e *ManifestError) Error() string {
+	var msg = e.Message
+
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, msg)
+	}
+
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, msg)
+}
+
+// ManifestErrors found on the uploaded manifests.
+type ManifestErrors []*ManifestError
+
+func (me ManifestErrors) Error() string {
+	var s = []string{}
+
+	for _, e := range me {
+		s = append(s, e.Error())
+	}
+
+	return "invalid manifests:\n" + strings.Join(s, "\n")
+}
+
+// ValidateManifests checks the uploaded manifests, and validates their objects against the OpenAPI schemas.
+// It returns the objects found, or ManifestErrors with the JSONPath of each invalid field.
+func (a *Apply) ValidateManifests(schemas *openapi.Schemas) ([]Object, error) {
+	if err := a.RenderTemplates(); err != nil {
+		return nil, err
+	}
+
+	if !a.usesManifests() {
+		return nil, nil
+	}
+
+	var docs, errs = a.parseManifests()
+	var objects = []Object{}
+
+	for _, d := range docs {
+		objects = append(objects, d.object)
+
+		for _, fe := range schemas.Validate(d.node) {
+			errs = append(errs, &ManifestError{
+				File:    d.object.File,
+				Line:    fe.Line,
+				Path:    fe.Path,
+				Message: fe.Message,
+			})
+		}
+	}
+
+	if len(errs) != 0 {
+		return objects, errs
+	}
+
+	return objects, nil
+}
+
+// IsManifest checks if the file is expected to contain Kubernetes configuration objects.
+// Files with the .json, .yaml, or .yml extensions are applied when no filename flag is set.
+func IsManifest(filename string) bool {
+	return strings.HasSuffix(filename, ".json") ||
+		strings.HasSuffix(filename, ".yaml") ||
+		strings.HasSuffix(filename, ".yml")
+}
+
+// usesManifests checks if kubectl reads the uploaded files with a manifest extension as Kubernetes objects,
+// and not as data for creating a ConfigMap or Secret, or as kustomizations.
+func (a *Apply) usesManifests() bool {
+	if a.Chart != nil {
+		return false
+	}
+
+	for _, f := range a.Flags.Keys() {
+		switch strings.TrimLeft(f, "-") {
+		case "from-file", "from-env-file", "k", "kustomize":
+			return false
+		}
+	}
+
+	if root, err := a.KustomizationRoot(); root != "" || err != nil {
+		return false
+	}
+
+	return a.addFilenameFlag() != nil
+}
+
+// readsFile returns a function checking if kubectl reads the uploaded file as manifests.
+// When the filename flag is set, only the files it names are read, and the manifests of the directories it names
+// (and of their subdirectories, with the recursive flag).
+func (a *Apply) readsFile() func(f string) bool {
+	var names = []string{}
+	var recursive bool
+
+	for _, f := range a.Flags.Keys() {
+		switch strings.TrimLeft(f, "-") {
+		case "f", "filename":
+			for _, v := range a.Flags[f] {
+				names = append(names, path.Clean(v))
+			}
+		case "R", "recursive":
+			v, _ := a.Flags.Get(f)
+			recursive = v == "" || v == "true"
+		}
+	}
+
+	if len(names) == 0 {
+		return IsManifest
+	}
+
+	return func(f string) bool {
+		f = path.Clean(f)
+
+		for _, n := range names {
+			var rel = strings.TrimPrefix(f, n+"/")
+
+			switch {
+			case f == n:
+				return true
+			case n == ".":
+				rel = f
+			case rel == f:
+				continue
+			}
+
+			if IsManifest(f) && (recursive || !strings.Contains(rel, "/")) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// CheckManifests parses each document of the uploaded manifests, checking that they are Kubernetes objects.
+// It returns the objects found, or ManifestErrors.
+func (a *Apply) CheckManifests() ([]Object, error) {
+	if err := a.RenderTemplates(); err != nil {
+		return nil, err
+	}
+
+	if !a.hasFilenameFlag() {
+		if _, err := a.KustomizationRoot(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !a.usesManifests() {
+		return nil, nil
+	}
+
+	var docs, errs = a.parseManifests()
+	var objects = []Object{}
+
+	for _, d := range docs {
+		objects = append(objects, d.object)
+	}
+
+	if len(errs) != 0 {
+		return objects, errs
+	}
+
+	return objects, nil
+}
+
+// manifestObject is an object found on a manifest and its node.
+type manifestObject struct {
+	object Object
+	node   *yaml.Node
+}
+
+func (a *Apply) parseManifests() ([]manifestObject, ManifestErrors) {
+	var objects = []manifestObject{}
+	var errs = ManifestErrors{}
+
+	var reads = a.readsFile()
+
+	for _, f := range a.listFiles() {
+		if !reads(f) || a.rendered != nil && isTemplate(f) {
+			continue
+		}
+
+		var o, e = parseManifest(f, a.Files[f])
+		objects = append(objects, o...)
+		errs = append(errs, e...)
+	}
+
+	for _, f := range sortedKeys(a.rendered) {
+		var o, e = parseManifest(f, a.rendered[f])
+		objects = append(objects, o...)
+		errs = append(errs, e...)
+	}
+
+	return objects, errs
+}
+
+var yamlErrorRegex = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func parseManifest(file string, b []byte) ([]manifestObject, ManifestErrors) {
+	var objects = []manifestObject{}
+	var errs = ManifestErrors{}
+	var dec = yaml.NewDecoder(bytes.NewReader(b))
+
+	for {
+		var doc yaml.Node
+
+		err := dec.Decode(&doc)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			errs = append(errs, syntaxError(file, err))
+			break
+		}
+
+		if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+			continue
+		}
+
+		o, e := parseObjects(file, doc.Content[0])
+		objects = append(objects, o...)
+		errs = append(errs, e...)
+	}
+
+	return objects, errs
+}
+
+func syntaxError(file string, err error) *ManifestError {
+	var me = &ManifestError{
+		File:    file,
+		Message: strings.TrimPrefix(err.Error(), "yaml: "),
+	}
+
+	if m := yamlErrorRegex.FindStringSubmatch(err.Error()); m != nil {
+		me.Line, _ = strconv.Atoi(m[1])
+		me.Message = m[2]
+	}
+
+	return me
+}
+
+// parseObjects of a document, which is either an object or a list of objects.
+func parseObjects(file string, n *yaml.Node) ([]manifestObject, ManifestErrors) {
+	var o, err = parseObject(file, n)
+
+	if err != nil {
+		return nil, ManifestErrors{err}
+	}
+
+	var items = mappingValue(n, "items")
+
+	if !strings.HasSuffix(o.Kind, "List") || items == nil {
+		return []manifestObject{{o, n}}, nil
+	}
+
+	if items.Kind != yaml.SequenceNode {
+		return nil, ManifestErrors{{File: file, Line: items.Line, Message: "items is not a list"}}
+	}
+
+	var objects = []manifestObject{}
+	var errs = ManifestErrors{}
+
+	for _, item := range items.Content {
+		if o, err := parseObject(file, item); err != nil {
+			errs = append(errs, err)
+		} else {
+			objects = append(objects, manifestObject{o, item})
+		}
+	}
+
+	return objects, errs
+}
+
+func parseObject(file string, n *yaml.Node) (Object, *ManifestError) {
+	var o = Object{
+		File: file,
+		Line: n.Line,
+	}
+
+	if n.Kind != yaml.MappingNode {
+		return o, &ManifestError{File: file, Line: n.Line, Message: "document is not an object"}
+	}
+
+	var missing = []string{}
+
+	if o.APIVersion = scalarValue(n, "apiVersion"); o.APIVersion == "" {
+		missing = append(missing, "apiVersion")
+	}
+
+	if o.Kind = scalarValue(n, "kind"); o.Kind == "" {
+		missing = append(missing, "kind")
+	}
+
+	if strings.HasSuffix(o.Kind, "List") && mappingValue(n, "items") != nil {
+		return o, checkMissing(o, missing)
+	}
+
+	var metadata = mappingValue(n, "metadata")
+
+	if metadata == nil || metadata.Kind != yaml.MappingNode {
+		missing = append(missing, "metadata.name")
+		return o, checkMissing(o, missing)
+	}
+
+	o.Name = scalarValue(metadata, "name")
+	o.Namespace = scalarValue(metadata, "namespace")
+
+	if o.Name == "" && scalarValue(metadata, "generateName") == "" {
+		missing = append(missing, "metadata.name")
+	}
+
+	return o, checkMissing(o, missing)
+}
+
+func checkMissing(o Object, missing []string) *ManifestError {
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var what = "object"
+
+	if o.Kind != "" {
+		what = o.Kind
+	}
+
+	return &ManifestError{
+		File:    o.File,
+		Line:    o.Line,
+		Message: fmt.Sprintf("%s is missing %s", what, strings.Join(missing, ", ")),
+	}
+}
+
+// mappingValue returns the value of a key of a mapping node, or nil if not found.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// scalarValue returns the value of a key of a mapping node if it is a non-null scalar, or an empty string.
+func scalarValue(n *yaml.Node, key string) string {
+	var v = mappingValue(n, key)
+
+	if v == nil || v.Kind != yaml.ScalarNode || v.Tag == "!!null" {
+		return ""
+	}
+
+	return v.Value
+}
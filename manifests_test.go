@@ -0,0 +1,160 @@
+package kubeapply
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckManifests(t *testing.T) {
+	var a = Apply{
+		Files: map[string][]byte{
+			"app.yaml": []byte(`# deployment and service
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+---
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`),
+			"list.json": []byte(`{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config"}}
+  ]
+}`),
+			"job.yml": []byte(`apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: migration-
+`),
+			"README.md": []byte("not a manifest: ["),
+		},
+	}
+
+	var got, err = a.CheckManifests()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var want = []Object{
+		{File: "app.yaml", Line: 2, APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Namespace: "prod"},
+		{File: "app.yaml", Line: 9, APIVersion: "v1", Kind: "Service", Name: "web"},
+		{File: "job.yml", Line: 1, APIVersion: "batch/v1", Kind: "Job"},
+		{File: "list.json", Line: 5, APIVersion: "v1", Kind: "ConfigMap", Name: "config"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected objects to be %+v, got %+v instead", want, got)
+	}
+}
+
+var checkManifestsErrorsCases = []struct {
+	name  string
+	files map[string][]byte
+	want  string
+}{
+	{
+		name: "syntax error",
+		files: map[string][]byte{
+			"a.yaml": []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\nkind: [\n"),
+		},
+		want: "invalid manifests:\na.yaml:6: did not find expected node content",
+	},
+	{
+		name: "missing fields",
+		files: map[string][]byte{
+			"a.yaml": []byte("apiVersion: v1\nkind: ConfigMap\n---\nmetadata:\n  name: b\n"),
+			"b.json": []byte(`["not", "an", "object"]`),
+		},
+		want: "invalid manifests:\n" +
+			"a.yaml:1: ConfigMap is missing metadata.name\n" +
+			"a.yaml:4: object is missing apiVersion, kind\n" +
+			"b.json:1: document is not an object",
+	},
+	{
+		name: "invalid list item",
+		files: map[string][]byte{
+			"a.yaml": []byte("apiVersion: v1\nkind: List\nitems:\n- kind: ConfigMap\n"),
+		},
+		want: "invalid manifests:\na.yaml:4: ConfigMap is missing apiVersion, metadata.name",
+	},
+}
+
+func TestCheckManifestsErrors(t *testing.T) {
+	for _, tt := range checkManifestsErrorsCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var a = Apply{
+				Files: tt.files,
+			}
+
+			var _, err = a.CheckManifests()
+
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Expected error to be %q, got %v instead", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestCheckManifestsFromFile(t *testing.T) {
+	var a = Apply{
+		Subcommand: "create configmap app",
+		Flags:      Flags{"from-file": {"config.yaml"}},
+		Files:      map[string][]byte{"config.yaml": []byte("debug: true")},
+	}
+
+	if _, err := a.CheckManifests(); err != nil {
+		t.Errorf("Expected files used as data to not be checked, got %v instead", err)
+	}
+}
+
+var checkManifestsFilenameCases = []struct {
+	name  string
+	flags Flags
+	want  []string
+}{
+	{"file", Flags{"f": {"deploy.yaml"}}, []string{"deploy.yaml"}},
+	{"files", Flags{"filename": {"deploy.yaml", "k8s/svc.yaml"}}, []string{"deploy.yaml", "k8s/svc.yaml"}},
+	{"directory", Flags{"f": {"k8s"}}, []string{"k8s/svc.yaml"}},
+	{"recursive", Flags{"f": {"./k8s/"}, "R": {""}}, []string{"k8s/jobs/job.yaml", "k8s/svc.yaml"}},
+}
+
+func TestCheckManifestsFilename(t *testing.T) {
+	for _, tt := range checkManifestsFilenameCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var a = Apply{
+				Flags: tt.flags,
+				Files: map[string][]byte{
+					"deploy.yaml":       []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n"),
+					"k8s/svc.yaml":      []byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n"),
+					"k8s/jobs/job.yaml": []byte("apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migration\n"),
+					"values.yaml":       []byte("- not an object\n"),
+					"config.json":       []byte(`{"debug": true}`),
+				},
+			}
+
+			var objects, err = a.CheckManifests()
+
+			if err != nil {
+				t.Fatalf("Expected files not named by the filename flag to not be checked, got %v instead", err)
+			}
+
+			var got = []string{}
+
+			for _, o := range objects {
+				got = append(got, o.File)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected objects of %v, got %v instead", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Package metrics exposes instrumentation in the Prometheus text exposition format.
+//
+// It implements only the small subset of the Prometheus data model used by kubeapply
+// (counters, gauges and histograms with labels) without pulling external dependencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector of a metric family.
+type Collector interface {
+	// Name of the metric family.
+	Name() string
+
+	// Write the metric family using the Prometheus text exposition format.
+	Write(w io.Writer) error
+}
+
+// Registry of collectors.
+type Registry struct {
+	collectors map[string]Collector
+
+	m sync.RWMutex
+}
+
+// NewRegistry creates a registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: map[string]Collector{},
+	}
+}
+
+// DefaultRegistry is used by kubeapply to register its metrics.
+var DefaultRegistry = NewRegistry()
+
+// Register collector.
+func (r *Registry) Register(c Collector) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.collectors[c.Name()]; ok {
+		return fmt.Errorf("metric %s is already registered", c.Name())
+	}
+
+	r.collectors[c.Name()] = c
+	return nil
+}
+
+// MustRegister collectors or panic.
+func (r *Registry) MustRegister(cs ...Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Write all registered metrics sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.m.RLock()
+	var names = []string{}
+
+	for n := range r.collectors {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	var cs = make([]Collector, 0, len(names))
+
+	for _, n := range names {
+		cs = append(cs, r.collectors[n])
+	}
+
+	r.m.RUnlock()
+
+	bw := bufio.NewWriter(w)
+
+	for _, c := range cs {
+		if err := c.Write(bw); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP exposes the metrics of the registry.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+
+	if err := r.Write(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Handler for the default registry.
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+// family of metrics sharing a name and label names.
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	children map[string]*child
+
+	m sync.Mutex
+}
+
+type child struct {
+	values []string
+	metric interface{}
+}
+
+func newFamily(name, help, typ string, labels []string) *family {
+	return &family{
+		name:     name,
+		help:     help,
+		typ:      typ,
+		labels:   labels,
+		children: map[string]*child{},
+	}
+}
+
+func (f *family) Name() string {
+	return f.name
+}
+
+func (f *family) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	var key = strings.Join(values, "\xff")
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	c, ok := f.children[key]
+
+	if !ok {
+		c = &child{
+			values: append([]string{}, values...),
+			metric: create(),
+		}
+
+		f.children[key] = c
+	}
+
+	return c.metric
+}
+
+func (f *family) sorted() []*child {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	var keys = []string{}
+
+	for k := range f.children {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var cs = make([]*child, 0, len(keys))
+
+	for _, k := range keys {
+		cs = append(cs, f.children[k])
+	}
+
+	return cs
+}
+
+func (f *family) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+	return err
+}
+
+func (f *family) labelPairs(values []string, extra ...string) string {
+	var pairs = []string{}
+
+	for i, l := range f.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, escapeLabelValue(values[i])))
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (f *family) writeSample(w io.Writer, suffix string, values []string, v float64, extra ...string) error {
+	_, err := fmt.Fprintf(w, "%s%s%s %s\n", f.name, suffix, f.labelPairs(values, extra...), formatFloat(v))
+	return err
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// value is a float64 safe for concurrent use.
+type value struct {
+	v float64
+	m sync.Mutex
+}
+
+func (v *value) add(n float64) {
+	v.m.Lock()
+	v.v += n
+	v.m.Unlock()
+}
+
+func (v *value) set(n float64) {
+	v.m.Lock()
+	v.v = n
+	v.m.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.m.Lock()
+	defer v.m.Unlock()
+	return v.v
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	var r = NewRegistry()
+
+	var requests = NewCounterVec("requests_total", "Requests.", "endpoint", "code")
+	var inFlight = NewGaugeVec("in_flight", "In-flight\nrequests.")
+	var duration = NewHistogramVec("duration_seconds", "Duration.", []float64{1, 0.5}, "endpoint")
+
+	r.MustRegister(requests, inFlight, duration)
+
+	requests.With("/apply", "200").Inc()
+	requests.With("/apply", "200").Add(2)
+	requests.With(`/a"b`, "500").Inc()
+	inFlight.With().Inc()
+	inFlight.With().Inc()
+	inFlight.With().Dec()
+	duration.With("/apply").Observe(0.3)
+	duration.With("/apply").Observe(0.7)
+	duration.With("/apply").Observe(3)
+
+	var buf bytes.Buffer
+
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var want = `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{endpoint="/apply",le="0.5"} 1
+duration_seconds_bucket{endpoint="/apply",le="1"} 2
+duration_seconds_bucket{endpoint="/apply",le="+Inf"} 3
+duration_seconds_sum{endpoint="/apply"} 4
+duration_seconds_count{endpoint="/apply"} 3
+# HELP in_flight In-flight\nrequests.
+# TYPE in_flight gauge
+in_flight 1
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{endpoint="/a\"b",code="500"} 1
+requests_total{endpoint="/apply",code="200"} 3
+`
+
+	if got := buf.String(); got != want {
+		t.Errorf("Expected metrics to be:\n%v\ngot:\n%v", want, got)
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	var r = NewRegistry()
+
+	if err := r.Register(NewCounterVec("x", "")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := r.Register(NewGaugeVec("x", "")); err == nil {
+		t.Errorf("Expected error registering duplicated metric")
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	var r = NewRegistry()
+	r.MustRegister(NewCounterVec("x_total", "X."))
+
+	var rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentType {
+		t.Errorf("Unexpected response %v (%v)", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	if want := "# HELP x_total X.\n# TYPE x_total counter\n"; rec.Body.String() != want {
+		t.Errorf("Expected body %q, got %q instead", want, rec.Body.String())
+	}
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v value
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add a non-negative value to the counter.
+func (c *Counter) Add(n float64) {
+	if n < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+
+	c.v.add(n)
+}
+
+// Value of the counter.
+func (c *Counter) Value() float64 {
+	return c.v.get()
+}
+
+// CounterVec is a collection of counters partitioned by label values.
+type CounterVec struct {
+	*family
+}
+
+// NewCounterVec creates a counter metric family.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newFamily(name, help, "counter", labels)}
+}
+
+// With returns the counter for the given label values.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.get(values, func() interface{} {
+		return &Counter{}
+	}).(*Counter)
+}
+
+// Write the counters.
+func (c *CounterVec) Write(w io.Writer) error {
+	if err := c.writeHeader(w); err != nil {
+		return err
+	}
+
+	for _, ch := range c.sorted() {
+		if err := c.writeSample(w, "", ch.values, ch.metric.(*Counter).Value()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v value
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+// Add value to the gauge.
+func (g *Gauge) Add(n float64) {
+	g.v.add(n)
+}
+
+// Set the gauge value.
+func (g *Gauge) Set(n float64) {
+	g.v.set(n)
+}
+
+// Value of the gauge.
+func (g *Gauge) Value() float64 {
+	return g.v.get()
+}
+
+// GaugeVec is a collection of gauges partitioned by label values.
+type GaugeVec struct {
+	*family
+}
+
+// NewGaugeVec creates a gauge metric family.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newFamily(name, help, "gauge", labels)}
+}
+
+// With returns the gauge for the given label values.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.get(values, func() interface{} {
+		return &Gauge{}
+	}).(*Gauge)
+}
+
+// Write the gauges.
+func (g *GaugeVec) Write(w io.Writer) error {
+	if err := g.writeHeader(w); err != nil {
+		return err
+	}
+
+	for _, ch := range g.sorted() {
+		if err := g.writeSample(w, "", ch.values, ch.metric.(*Gauge).Value()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+
+	m sync.Mutex
+}
+
+// Observe a value.
+func (h *Histogram) Observe(v float64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if i := sort.SearchFloat64s(h.upperBounds, v); i < len(h.counts) {
+		h.counts[i]++
+	}
+
+	h.count++
+	h.sum += v
+}
+
+// Count of observations.
+func (h *Histogram) Count() uint64 {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.count
+}
+
+func (h *Histogram) snapshot() (cumulative []uint64, count uint64, sum float64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	var acc uint64
+	cumulative = make([]uint64, len(h.counts))
+
+	for i, c := range h.counts {
+		acc += c
+		cumulative[i] = acc
+	}
+
+	return cumulative, h.count, h.sum
+}
+
+// HistogramVec is a collection of histograms partitioned by label values.
+type HistogramVec struct {
+	*family
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram metric family.
+// The DefBuckets are used if buckets is nil.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+
+	var b = append([]float64{}, buckets...)
+	sort.Float64s(b)
+
+	return &HistogramVec{
+		family:  newFamily(name, help, "histogram", labels),
+		buckets: b,
+	}
+}
+
+// With returns the histogram for the given label values.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.get(values, func() interface{} {
+		return &Histogram{
+			upperBounds: h.buckets,
+			counts:      make([]uint64, len(h.buckets)),
+		}
+	}).(*Histogram)
+}
+
+// Write the histograms.
+func (h *HistogramVec) Write(w io.Writer) error {
+	if err := h.writeHeader(w); err != nil {
+		return err
+	}
+
+	for _, ch := range h.sorted() {
+		if err := h.writeHistogram(w, ch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *HistogramVec) writeHistogram(w io.Writer, ch *child) error {
+	cumulative, count, sum := ch.metric.(*Histogram).snapshot()
+
+	for i, ub := range h.buckets {
+		if err := h.writeSample(w, "_bucket", ch.values, float64(cumulative[i]), "le", formatFloat(ub)); err != nil {
+			return err
+		}
+	}
+
+	if err := h.writeSample(w, "_bucket", ch.values, float64(count), "le", "+Inf"); err != nil {
+		return err
+	}
+
+	if err := h.writeSample(w, "_sum", ch.values, sum); err != nil {
+		return err
+	}
+
+	return h.writeSample(w, "_count", ch.values, float64(count))
+}
@@ -0,0 +1,233 @@
+// Package openapi validates Kubernetes objects against OpenAPI schemas offline.
+//
+// Schemas are loaded from the OpenAPI v2 specification of a cluster
+// (kubectl get --raw /openapi/v2 > swagger.json), and from the schemas of
+// CustomResourceDefinition objects (apiextensions.k8s.io/v1).
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is the subset of an OpenAPI schema used for validation.
+type Schema struct {
+	Ref string `json:"$ref,omitempty"`
+
+	Type   string `json:"type,omitempty"`
+	Format string `json:"format,omitempty"`
+
+	Properties           map[string]*Schema    `json:"properties,omitempty"`
+	AdditionalProperties *AdditionalProperties `json:"additionalProperties,omitempty"`
+	Items                *Schema               `json:"items,omitempty"`
+
+	Required []string      `json:"required,omitempty"`
+	Enum     []interface{} `json:"enum,omitempty"`
+
+	PreserveUnknownFields bool `json:"x-kubernetes-preserve-unknown-fields,omitempty"`
+	IntOrString           bool `json:"x-kubernetes-int-or-string,omitempty"`
+	EmbeddedResource      bool `json:"x-kubernetes-embedded-resource,omitempty"`
+
+	GroupVersionKinds []GroupVersionKind `json:"x-kubernetes-group-version-kind,omitempty"`
+}
+
+// AdditionalProperties of an object: either allowed or not, or a schema for them.
+type AdditionalProperties struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// UnmarshalJSON accepts either a boolean or a schema.
+func (ap *AdditionalProperties) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &ap.Allowed); err == nil {
+		return nil
+	}
+
+	ap.Allowed = true
+	return json.Unmarshal(b, &ap.Schema)
+}
+
+// GroupVersionKind of a Kubernetes object.
+type GroupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// ParseGroupVersionKind from the apiVersion and kind of an object.
+func ParseGroupVersionKind(apiVersion, kind string) GroupVersionKind {
+	var gvk = GroupVersionKind{
+		Version: apiVersion,
+		Kind:    kind,
+	}
+
+	if i := strings.LastIndex(apiVersion, "/"); i != -1 {
+		gvk.Group = apiVersion[:i]
+		gvk.Version = apiVersion[i+1:]
+	}
+
+	return gvk
+}
+
+func (gvk GroupVersionKind) String() string {
+	if gvk.Group == "" {
+		return gvk.Version + " " + gvk.Kind
+	}
+
+	return gvk.Group + "/" + gvk.Version + " " + gvk.Kind
+}
+
+const (
+	definitionsRef = "#/definitions/"
+	objectMetaRef  = definitionsRef + "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"
+	quantityRef    = definitionsRef + "io.k8s.apimachinery.pkg.api.resource.Quantity"
+)
+
+// Schemas of the Kubernetes objects.
+type Schemas struct {
+	definitions map[string]*Schema
+	kinds       map[GroupVersionKind]*Schema
+}
+
+// Load the schemas from an OpenAPI v2 specification file (swagger.json),
+// and from the CustomResourceDefinition objects on the YAML or JSON files of a directory.
+// Both are optional.
+func Load(spec, crdsDir string) (*Schemas, error) {
+	var s = &Schemas{
+		definitions: map[string]*Schema{},
+		kinds:       map[GroupVersionKind]*Schema{},
+	}
+
+	if spec != "" {
+		if err := s.loadSpec(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	if crdsDir != "" {
+		if err := s.loadCRDs(crdsDir); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Schemas) loadSpec(name string) error {
+	var b, err = ioutil.ReadFile(name) // #nosec
+
+	if err != nil {
+		return err
+	}
+
+	var spec struct {
+		Definitions map[string]*Schema `json:"definitions"`
+	}
+
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return fmt.Errorf("cannot decode OpenAPI specification %s: %v", name, err)
+	}
+
+	for name, d := range spec.Definitions {
+		s.definitions[definitionsRef+name] = d
+
+		for _, gvk := range d.GroupVersionKinds {
+			s.kinds[gvk] = d
+		}
+	}
+
+	return nil
+}
+
+func (s *Schemas) loadCRDs(dir string) error {
+	var files, err = ioutil.ReadDir(dir)
+
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		switch filepath.Ext(f.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		if err := s.loadCRD(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type crd struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Versions []struct {
+			Name   string `json:"name"`
+			Schema struct {
+				OpenAPIV3Schema *Schema `json:"openAPIV3Schema"`
+			} `json:"schema"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+func (s *Schemas) loadCRD(name string) error {
+	var b, err = ioutil.ReadFile(name) // #nosec
+
+	if err != nil {
+		return err
+	}
+
+	var dec = yaml.NewDecoder(bytes.NewReader(b))
+
+	for {
+		var doc interface{}
+
+		err := dec.Decode(&doc)
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("cannot decode %s: %v", name, err)
+		}
+
+		var c crd
+
+		// yaml.v3 decodes mappings as map[string]interface{}, which can be converted to JSON
+		if j, err := json.Marshal(doc); err != nil || json.Unmarshal(j, &c) != nil {
+			continue
+		}
+
+		if c.APIVersion != "apiextensions.k8s.io/v1" || c.Kind != "CustomResourceDefinition" {
+			continue
+		}
+
+		for _, v := range c.Spec.Versions {
+			if v.Schema.OpenAPIV3Schema != nil {
+				s.kinds[GroupVersionKind{c.Spec.Group, v.Name, c.Spec.Names.Kind}] = v.Schema.OpenAPIV3Schema
+			}
+		}
+	}
+}
+
+// Has checks if there is a schema for the given kind.
+func (s *Schemas) Has(gvk GroupVersionKind) bool {
+	_, ok := s.kinds[gvk]
+	return ok
+}
@@ -0,0 +1,181 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func load(t *testing.T) *Schemas {
+	t.Helper()
+
+	var s, err = Load("testdata/swagger.json", "testdata/crds")
+
+	if err != nil {
+		t.Fatalf("Unexpected error loading schemas: %v", err)
+	}
+
+	return s
+}
+
+func TestLoad(t *testing.T) {
+	var s = load(t)
+
+	for _, gvk := range []GroupVersionKind{
+		{"apps", "v1", "Deployment"},
+		{"example.com", "v1", "Widget"},
+	} {
+		if !s.Has(gvk) {
+			t.Errorf("Expected schema for %v to be loaded", gvk)
+		}
+	}
+}
+
+func TestParseGroupVersionKind(t *testing.T) {
+	if got, want := ParseGroupVersionKind("apps/v1", "Deployment"), (GroupVersionKind{"apps", "v1", "Deployment"}); got != want {
+		t.Errorf("Expected %v, got %v instead", want, got)
+	}
+
+	if got, want := ParseGroupVersionKind("v1", "Pod"), (GroupVersionKind{"", "v1", "Pod"}); got != want {
+		t.Errorf("Expected %v, got %v instead", want, got)
+	}
+}
+
+var validateCases = []struct {
+	name string
+	doc  string
+	want []FieldError
+}{
+	{
+		name: "valid deployment",
+		doc: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  strategy:
+    type: RollingUpdate
+    rollingUpdate:
+      maxSurge: 25%
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx
+        resources:
+          limits:
+            cpu: 1
+            memory: 128Mi
+`,
+	},
+	{
+		name: "invalid deployment",
+		doc: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    version: 2
+spec:
+  replicas: "3"
+  replica: 3
+  strategy:
+    type: Rolling
+  template:
+    spec:
+      containers:
+      - image: [nginx]
+`,
+		want: []FieldError{
+			{Path: "$.metadata.labels.version", Line: 6, Message: "expected string, got integer"},
+			{Path: "$.spec.replicas", Line: 8, Message: "expected integer, got string"},
+			{Path: "$.spec.replica", Line: 9, Message: `unknown field "replica"`},
+			{Path: "$.spec.strategy.type", Line: 11, Message: `unsupported value "Rolling": must be one of "Recreate", "RollingUpdate"`},
+			{Path: "$.spec.template.spec.containers[0].image", Line: 15, Message: "expected string, got array"},
+			{Path: "$.spec.template.spec.containers[0]", Line: 15, Message: `missing required field "name"`},
+			{Path: "$.spec", Line: 8, Message: `missing required field "selector"`},
+		},
+	},
+	{
+		name: "custom resource",
+		doc: `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: w
+  annotations:
+    a.b/c: d
+spec:
+  size: big
+  extra:
+    anything: [1, 2]
+  color: blue
+`,
+		want: []FieldError{
+			{Path: "$.spec.size", Line: 8, Message: "expected integer, got string"},
+			{Path: "$.spec.color", Line: 11, Message: `unknown field "color"`},
+		},
+	},
+	{
+		name: "custom resource with invalid metadata",
+		doc: `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: w
+  label:
+    a: b
+`,
+		want: []FieldError{
+			{Path: "$.metadata.label", Line: 5, Message: `unknown field "label"`},
+		},
+	},
+	{
+		name: "unknown kind",
+		doc: `apiVersion: example.com/v2
+kind: Widget
+`,
+		want: []FieldError{
+			{Path: "$", Line: 1, Message: "no schema found for example.com/v2 Widget"},
+		},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	var s = load(t)
+
+	for _, tt := range validateCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc yaml.Node
+
+			if err := yaml.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			var got = []FieldError{}
+
+			for _, fe := range s.Validate(doc.Content[0]) {
+				got = append(got, *fe)
+			}
+
+			if tt.want == nil {
+				tt.want = []FieldError{}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected errors to be %+v, got %+v instead", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	if got, want := fieldPath("$.metadata.annotations", "a.b/c"), `$.metadata.annotations['a.b/c']`; got != want {
+		t.Errorf("Expected %v, got %v instead", want, got)
+	}
+}
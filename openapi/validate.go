@@ -0,0 +1,253 @@
+package openapi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError is an invalid field of an object.
+type FieldError struct {
+	// Path of the field, in JSONPath notation.
+	Path string `json:"path"`
+
+	// Line of the field on the manifest.
+	Line int `json:"line,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Validate an object against the schema of its kind.
+func (s *Schemas) Validate(n *yaml.Node) []*FieldError {
+	var v = &validator{schemas: s}
+	var apiVersion, kind string
+
+	if n.Kind == yaml.MappingNode {
+		apiVersion, kind = scalar(n, "apiVersion"), scalar(n, "kind")
+	}
+
+	var gvk = ParseGroupVersionKind(apiVersion, kind)
+	var schema, ok = s.kinds[gvk]
+
+	if !ok {
+		v.errorf("$", n, "no schema found for %v", gvk)
+		return v.errors
+	}
+
+	v.validate("$", n, schema)
+	return v.errors
+}
+
+type validator struct {
+	schemas *Schemas
+	errors  []*FieldError
+}
+
+func (v *validator) errorf(path string, n *yaml.Node, format string, a ...interface{}) {
+	v.errors = append(v.errors, &FieldError{
+		Path:    path,
+		Line:    n.Line,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// resolve references to other definitions.
+func (v *validator) resolve(s *Schema) *Schema {
+	for i := 0; s != nil && s.Ref != "" && i < 100; i++ {
+		s = v.schemas.definitions[s.Ref]
+	}
+
+	return s
+}
+
+func (v *validator) validate(path string, n *yaml.Node, s *Schema) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	var ref = s.Ref
+
+	if s = v.resolve(s); s == nil {
+		return
+	}
+
+	if n.Tag == "!!null" {
+		return
+	}
+
+	switch {
+	case ref == quantityRef:
+		v.checkScalar(path, n, "quantity", "!!str", "!!int", "!!float")
+	case s.IntOrString || s.Format == "int-or-string":
+		v.checkScalar(path, n, "integer or string", "!!str", "!!int")
+	case s.Type == "object" || s.Properties != nil:
+		v.validateObject(path, n, s)
+	case s.Type == "array":
+		v.validateArray(path, n, s)
+	case s.Type == "string":
+		v.checkScalar(path, n, "string", "!!str", "!!timestamp", "!!binary")
+	case s.Type == "integer":
+		v.checkScalar(path, n, "integer", "!!int")
+	case s.Type == "number":
+		v.checkScalar(path, n, "number", "!!int", "!!float")
+	case s.Type == "boolean":
+		v.checkScalar(path, n, "boolean", "!!bool")
+	}
+
+	if len(s.Enum) != 0 && n.Kind == yaml.ScalarNode && !inEnum(n.Value, s.Enum) {
+		v.errorf(path, n, "unsupported value %q: must be one of %v", n.Value, enumValues(s.Enum))
+	}
+}
+
+func (v *validator) checkScalar(path string, n *yaml.Node, want string, tags ...string) {
+	for _, t := range tags {
+		if n.Kind == yaml.ScalarNode && n.Tag == t {
+			return
+		}
+	}
+
+	v.errorf(path, n, "expected %s, got %s", want, nodeType(n))
+}
+
+func (v *validator) validateObject(path string, n *yaml.Node, s *Schema) {
+	if n.Kind != yaml.MappingNode {
+		v.errorf(path, n, "expected object, got %s", nodeType(n))
+		return
+	}
+
+	var keys = map[string]bool{}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		var key, value = n.Content[i].Value, n.Content[i+1]
+
+		if key == "<<" {
+			continue
+		}
+
+		keys[key] = true
+
+		var child = fieldPath(path, key)
+
+		if p, ok := s.Properties[key]; ok {
+			if s.EmbeddedResource && key == "metadata" || path == "$" && key == "metadata" {
+				p = v.objectMeta(p)
+			}
+
+			v.validate(child, value, p)
+			continue
+		}
+
+		switch ap := s.AdditionalProperties; {
+		case ap != nil && ap.Schema != nil:
+			v.validate(child, value, ap.Schema)
+		case ap != nil && ap.Allowed,
+			s.PreserveUnknownFields,
+			ap == nil && len(s.Properties) == 0:
+		default:
+			v.errorf(child, n.Content[i], "unknown field %q", key)
+		}
+	}
+
+	for _, r := range s.Required {
+		if !keys[r] {
+			v.errorf(path, n, "missing required field %q", r)
+		}
+	}
+}
+
+// objectMeta replaces the usually incomplete metadata schema of custom resources with the ObjectMeta definition.
+func (v *validator) objectMeta(s *Schema) *Schema {
+	if s.Ref == "" && len(s.Properties) == 0 {
+		if om, ok := v.schemas.definitions[objectMetaRef]; ok {
+			return om
+		}
+	}
+
+	return s
+}
+
+func (v *validator) validateArray(path string, n *yaml.Node, s *Schema) {
+	if n.Kind != yaml.SequenceNode {
+		v.errorf(path, n, "expected array, got %s", nodeType(n))
+		return
+	}
+
+	if s.Items == nil {
+		return
+	}
+
+	for i, item := range n.Content {
+		v.validate(fmt.Sprintf("%s[%d]", path, i), item, s.Items)
+	}
+}
+
+var identifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+// fieldPath in JSONPath notation.
+func fieldPath(path, key string) string {
+	if identifierRegex.MatchString(key) {
+		return path + "." + key
+	}
+
+	return path + "['" + strings.Replace(key, "'", `\'`, -1) + "']"
+}
+
+func nodeType(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+
+	switch n.Tag {
+	case "!!str":
+		return "string"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	}
+
+	return strings.TrimPrefix(n.Tag, "!!")
+}
+
+func inEnum(value string, enum []interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func enumValues(enum []interface{}) string {
+	var s = []string{}
+
+	for _, e := range enum {
+		s = append(s, strconv.Quote(fmt.Sprint(e)))
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// scalar value of a key of a mapping node, or an empty string.
+func scalar(n *yaml.Node, key string) string {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key && n.Content[i+1].Kind == yaml.ScalarNode {
+			return n.Content[i+1].Value
+		}
+	}
+
+	return ""
+}
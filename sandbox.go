@@ -0,0 +1,49 @@
+package kubeapply
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Sandbox limits what kubectl can do on the machine it runs on.
+// It is only supported on Linux, and kubectl runs without it elsewhere.
+// Programs using it must call SandboxInit at the start of main.
+type Sandbox struct {
+	// Namespaces runs kubectl on new mount, PID, and user namespaces, with a read-only root filesystem
+	// except for the recording and home directories. kubectl runs without them if they aren't available.
+	Namespaces bool
+
+	// CPUTime limit of the kubectl process, rounded up to seconds.
+	CPUTime time.Duration
+
+	// Memory limit (address space) of the kubectl process, in bytes.
+	Memory uint64
+
+	// OpenFiles limit of the kubectl process.
+	OpenFiles uint64
+
+	// Processes limit of the user running kubectl.
+	Processes uint64
+
+	// Seccomp sets no new privileges and denies system calls used to mount filesystems,
+	// change namespaces, trace processes, load kernel modules, and similar.
+	Seccomp bool
+}
+
+// sandboxArg0 is used to execute the current program again as the sandbox helper.
+const sandboxArg0 = "kubeapply-sandbox"
+
+var sandboxReady bool
+
+var errSandboxInit = errors.New("kubeapply.SandboxInit must be called at the start of main to use the sandbox")
+
+// SandboxInit must be called at the start of main by programs using Sandbox.
+// When the program is executed as the sandbox helper, it sets up the sandbox and executes kubectl, never returning.
+func SandboxInit() {
+	if len(os.Args) != 0 && os.Args[0] == sandboxArg0 {
+		sandboxMain(os.Args[1:])
+	}
+
+	sandboxReady = true
+}
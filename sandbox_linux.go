@@ -0,0 +1,440 @@
+package kubeapply
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// sandboxConfig passed to the sandbox helper.
+type sandboxConfig struct {
+	Namespaces bool     `json:"namespaces,omitempty"`
+	Writable   []string `json:"writable,omitempty"`
+
+	CPUTime   uint64 `json:"cpu_time,omitempty"`
+	Memory    uint64 `json:"memory,omitempty"`
+	OpenFiles uint64 `json:"open_files,omitempty"`
+	Processes uint64 `json:"processes,omitempty"`
+
+	Seccomp bool `json:"seccomp,omitempty"`
+}
+
+var (
+	namespacesUnavailable int32
+	namespacesWarning     sync.Once
+)
+
+// run the command inside the sandbox.
+func (s *Sandbox) run(ctx context.Context, newCommand func() *exec.Cmd) error {
+	if !sandboxReady {
+		return errSandboxInit
+	}
+
+	var namespaces = s.Namespaces && atomic.LoadInt32(&namespacesUnavailable) == 0
+	var cmd = newCommand()
+
+	if err := s.wrap(cmd, namespaces); err != nil {
+		return err
+	}
+
+	var err = cmd.Start()
+
+	if namespaces && isNamespaceError(err) {
+		atomic.StoreInt32(&namespacesUnavailable, 1)
+		namespacesWarning.Do(func() {
+			Logger(ctx).Warnf("cannot create namespaces for the kubectl sandbox (%v): running kubectl without them", err)
+		})
+
+		cmd = newCommand()
+
+		if err := s.wrap(cmd, false); err != nil {
+			return err
+		}
+
+		err = cmd.Start()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return cmd.Wait()
+}
+
+// isNamespaceError checks if the process couldn't be created due to namespaces being unavailable.
+func isNamespaceError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EPERM, syscall.EACCES, syscall.EINVAL, syscall.ENOSPC, syscall.EUSERS} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wrap the command so that it executes the sandbox helper, which then executes the command.
+func (s *Sandbox) wrap(cmd *exec.Cmd, namespaces bool) error {
+	var path, err = exec.LookPath(cmd.Path)
+
+	if err != nil {
+		return err
+	}
+
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+
+	var c = sandboxConfig{
+		Namespaces: namespaces,
+		CPUTime:    uint64(math.Ceil(s.CPUTime.Seconds())),
+		Memory:     s.Memory,
+		OpenFiles:  s.OpenFiles,
+		Processes:  s.Processes,
+		Seccomp:    s.Seccomp,
+	}
+
+	if namespaces {
+		if c.Writable, err = writableDirs(cmd); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(c)
+
+	if err != nil {
+		return err
+	}
+
+	cmd.Args = append([]string{sandboxArg0, string(b), path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	if namespaces {
+		cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	}
+
+	return nil
+}
+
+// writableDirs of the command: its working directory (the recording directory) and home directory.
+func writableDirs(cmd *exec.Cmd) ([]string, error) {
+	var dirs = []string{}
+
+	if cmd.Dir != "" {
+		dirs = append(dirs, cmd.Dir)
+	}
+
+	for _, kv := range cmd.Env {
+		if strings.HasPrefix(kv, "HOME=") {
+			dirs = append(dirs, strings.TrimPrefix(kv, "HOME="))
+		}
+	}
+
+	for i, d := range dirs {
+		var err error
+
+		if d, err = filepath.Abs(d); err != nil {
+			return nil, err
+		}
+
+		if dirs[i], err = filepath.EvalSymlinks(d); err != nil {
+			return nil, err
+		}
+	}
+
+	return dirs, nil
+}
+
+// sandboxMain sets up the sandbox and executes the command.
+func sandboxMain(args []string) {
+	// no new privileges and seccomp are set for the thread calling execve
+	runtime.LockOSThread()
+
+	if err := sandboxExec(args); err != nil {
+		fmt.Fprintf(os.Stderr, "kubeapply sandbox: %v\n", err)
+	}
+
+	os.Exit(126)
+}
+
+func sandboxExec(args []string) error {
+	if len(args) < 3 {
+		return errors.New("missing arguments")
+	}
+
+	var c sandboxConfig
+
+	if err := json.Unmarshal([]byte(args[0]), &c); err != nil {
+		return err
+	}
+
+	if c.Namespaces {
+		if err := readOnlyRoot(c.Writable); err != nil {
+			return err
+		}
+	}
+
+	if err := setRlimits(c); err != nil {
+		return err
+	}
+
+	if c.Seccomp {
+		if err := setNoNewPrivs(); err != nil {
+			return err
+		}
+
+		if err := setSeccomp(); err != nil {
+			return err
+		}
+	}
+
+	return syscall.Exec(args[1], args[2:], os.Environ())
+}
+
+// readOnlyRoot remounts every mount read-only, except for the writable directories.
+func readOnlyRoot(writable []string) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("cannot make mounts private: %v", err)
+	}
+
+	// best effort: the procfs of the PID namespace cannot be mounted if the one of the host is partially hidden
+	_ = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+
+	for _, w := range writable {
+		if err := syscall.Mount(w, w, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("cannot bind mount %s: %v", w, err)
+		}
+	}
+
+	var mounts, err = mountPoints()
+
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		if isWritable(m, writable) {
+			continue
+		}
+
+		if err := remountReadOnly(m); err != nil {
+			return fmt.Errorf("cannot remount %s read-only: %v", m, err)
+		}
+	}
+
+	// the working directory was entered before the bind mounts and must be entered again
+	var wd string
+
+	if wd, err = os.Getwd(); err != nil {
+		return err
+	}
+
+	return os.Chdir(wd)
+}
+
+func isWritable(path string, writable []string) bool {
+	for _, w := range writable {
+		if path == w || strings.HasPrefix(path, w+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mountPoints of the current mount namespace.
+func mountPoints() ([]string, error) {
+	var f, err = os.Open("/proc/self/mountinfo")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var mounts = []string{}
+	var scanner = bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		var fields = strings.Fields(scanner.Text())
+
+		if len(fields) < 5 {
+			continue
+		}
+
+		mounts = append(mounts, unescapeMountPoint(fields[4]))
+	}
+
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPoint replaces the octal escapes used on /proc/self/mountinfo for spaces and similar characters.
+func unescapeMountPoint(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// mountFlags from statfs that must be kept when remounting mounts locked by a user namespace.
+var mountFlags = map[int64]uintptr{
+	0x2:    syscall.MS_NOSUID,
+	0x4:    syscall.MS_NODEV,
+	0x8:    syscall.MS_NOEXEC,
+	0x10:   syscall.MS_SYNCHRONOUS,
+	0x40:   syscall.MS_MANDLOCK,
+	0x400:  syscall.MS_NOATIME,
+	0x800:  syscall.MS_NODIRATIME,
+	0x1000: syscall.MS_RELATIME,
+}
+
+func remountReadOnly(path string) error {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &st); err != nil {
+		return err
+	}
+
+	var flags uintptr = syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY
+
+	for f, mf := range mountFlags {
+		if int64(st.Flags)&f != 0 {
+			flags |= mf
+		}
+	}
+
+	return syscall.Mount("", path, "", flags, "")
+}
+
+func setRlimits(c sandboxConfig) error {
+	var limits = []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_CPU, c.CPUTime},
+		{syscall.RLIMIT_AS, c.Memory},
+		{syscall.RLIMIT_NOFILE, c.OpenFiles},
+		{rlimitNproc, c.Processes},
+	}
+
+	for _, l := range limits {
+		if l.value == 0 || l.resource < 0 {
+			continue
+		}
+
+		if err := syscall.Setrlimit(l.resource, &syscall.Rlimit{Cur: l.value, Max: l.value}); err != nil {
+			return fmt.Errorf("cannot set resource limit %d: %v", l.resource, err)
+		}
+	}
+
+	return nil
+}
+
+const prSetNoNewPrivs = 38
+
+func setNoNewPrivs() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("cannot set no new privileges: %v", errno)
+	}
+
+	return nil
+}
+
+const (
+	prSetSeccomp      = 22
+	seccompModeFilter = 2
+
+	seccompRetAllow = 0x7fff0000
+	seccompRetErrno = 0x00050000
+
+	// x32SyscallBit marks system calls of the x32 ABI, which shares the architecture of x86-64.
+	x32SyscallBit = 0x40000000
+
+	// cloneNamespaceFlags are the CLONE_NEWNS, CLONE_NEWCGROUP, CLONE_NEWUTS, CLONE_NEWIPC, CLONE_NEWUSER,
+	// CLONE_NEWPID, and CLONE_NEWNET flags of clone.
+	cloneNamespaceFlags = 0x7e020000
+)
+
+// seccompFilter denying the system calls on deniedSyscalls and those from other architectures.
+// clone is denied when creating namespaces, as unshare and setns are denied too.
+// clone3 fails with ENOSYS, as its flags can't be filtered, so that libc falls back to clone.
+func seccompFilter() []syscall.SockFilter {
+	var deny = seccompRetErrno | uint32(syscall.EPERM)
+
+	var filter = []syscall.SockFilter{
+		// seccomp_data.arch
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 4},
+		{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: 1, K: auditArch},
+		{Code: syscall.BPF_RET | syscall.BPF_K, K: deny},
+		// seccomp_data.nr
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 0},
+		{Code: syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K, Jf: 1, K: x32SyscallBit},
+		{Code: syscall.BPF_RET | syscall.BPF_K, K: deny},
+		// clone: the lower 32 bits of seccomp_data.args[0], with the flags
+		{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jf: 4, K: sysClone},
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 16},
+		{Code: syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K, Jf: 1, K: cloneNamespaceFlags},
+		{Code: syscall.BPF_RET | syscall.BPF_K, K: deny},
+		{Code: syscall.BPF_RET | syscall.BPF_K, K: seccompRetAllow},
+		// clone3
+		{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jf: 1, K: sysClone3},
+		{Code: syscall.BPF_RET | syscall.BPF_K, K: seccompRetErrno | uint32(syscall.ENOSYS)},
+	}
+
+	for _, nr := range deniedSyscalls {
+		filter = append(filter,
+			syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jf: 1, K: nr},
+			syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: deny})
+	}
+
+	return append(filter, syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: seccompRetAllow})
+}
+
+func setSeccomp() error {
+	if auditArch == 0 {
+		fmt.Fprintf(os.Stderr, "kubeapply sandbox: seccomp is not supported on %s: skipping it\n", runtime.GOARCH)
+		return nil
+	}
+
+	var filter = seccompFilter()
+
+	var prog = syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter,
+		uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("cannot set seccomp filter: %v", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package kubeapply
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	SandboxInit()
+
+	if os.Getenv("KUBEAPPLY_TEST_CLONE") == "1" {
+		testClone()
+	}
+
+	os.Exit(m.Run())
+}
+
+// testClone prints the errors of creating a user namespace with clone, and of calling clone3.
+func testClone() {
+	pid, _, errno := syscall.RawSyscall(syscall.SYS_CLONE, syscall.CLONE_NEWUSER|uintptr(syscall.SIGCHLD), 0, 0)
+
+	switch {
+	case errno != 0:
+		fmt.Printf("clone: %v\n", errno)
+	case pid == 0:
+		syscall.RawSyscall(syscall.SYS_EXIT_GROUP, 0, 0, 0)
+	default:
+		var ws syscall.WaitStatus
+		_, _ = syscall.Wait4(int(pid), &ws, 0, nil)
+		fmt.Println("clone: created namespace")
+	}
+
+	_, _, errno = syscall.RawSyscall(sysClone3, 0, 0, 0)
+	fmt.Printf("clone3: %v\n", errno)
+	os.Exit(0)
+}
+
+func runSandboxed(t *testing.T, s *Sandbox, dir, script string) (string, error) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	var err = s.run(context.Background(), func() *exec.Cmd {
+		var cmd = exec.Command("sh", "-c", script)
+		cmd.Dir = dir
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir}
+		cmd.Stdout = &buf
+		cmd.Stderr = &buf
+		return cmd
+	})
+
+	return buf.String(), err
+}
+
+func TestSandboxLimits(t *testing.T) {
+	var s = &Sandbox{
+		OpenFiles: 64,
+		Seccomp:   true,
+	}
+
+	var out, err = runSandboxed(t, s, t.TempDir(), "ulimit -n; grep -E '^(NoNewPrivs|Seccomp):' /proc/self/status")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v (output: %s)", err, out)
+	}
+
+	var want = "64\nNoNewPrivs:\t1\nSeccomp:\t2\n"
+
+	if out != want {
+		t.Errorf("Expected output to be %q, got %q instead", want, out)
+	}
+}
+
+func TestSandboxSeccompClone(t *testing.T) {
+	var s = &Sandbox{
+		Seccomp: true,
+	}
+
+	var out, err = runSandboxed(t, s, t.TempDir(), "KUBEAPPLY_TEST_CLONE=1 "+os.Args[0])
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v (output: %s)", err, out)
+	}
+
+	var want = "clone: operation not permitted\nclone3: function not implemented\n"
+
+	if out != want {
+		t.Errorf("Expected output to be %q, got %q instead", want, out)
+	}
+}
+
+func TestSandboxNamespaces(t *testing.T) {
+	var dir = t.TempDir()
+	var other = t.TempDir()
+
+	var s = &Sandbox{
+		Namespaces: true,
+	}
+
+	var out, err = runSandboxed(t, s, dir,
+		"echo $$; touch ok && ! touch "+filepath.Join(other, "fail")+" 2>/dev/null && echo read-only")
+
+	if atomic.LoadInt32(&namespacesUnavailable) != 0 {
+		t.Skip("namespaces are not available")
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v (output: %s)", err, out)
+	}
+
+	if want := "1\nread-only\n"; out != want {
+		t.Errorf("Expected output to be %q, got %q instead", want, out)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "ok")); err != nil {
+		t.Errorf("Expected file to be written on writable directory, got %v instead", err)
+	}
+}
+
+func TestSandboxRequiresInit(t *testing.T) {
+	sandboxReady = false
+	defer func() {
+		sandboxReady = true
+	}()
+
+	var _, err = runSandboxed(t, &Sandbox{}, t.TempDir(), "true")
+
+	if err != errSandboxInit {
+		t.Errorf("Expected error to be %v, got %v instead", errSandboxInit, err)
+	}
+}
+
+func TestUnescapeMountPoint(t *testing.T) {
+	if got := unescapeMountPoint(`/mnt/a\040b\`); got != `/mnt/a b\` {
+		t.Errorf("Expected mount point to be unescaped, got %q instead", got)
+	}
+
+	if got := strings.Count(unescapeMountPoint(`\134\134`), `\`); got != 2 {
+		t.Errorf("Expected 2 backslashes, got %d instead", got)
+	}
+}
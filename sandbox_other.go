@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package kubeapply
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+var sandboxWarning sync.Once
+
+func sandboxMain(args []string) {
+	fmt.Fprintln(os.Stderr, "kubeapply sandbox: only supported on Linux")
+	os.Exit(126)
+}
+
+// run the command without the sandbox, as it is not supported.
+func (s *Sandbox) run(ctx context.Context, newCommand func() *exec.Cmd) error {
+	sandboxWarning.Do(func() {
+		Logger(ctx).Warn("kubectl sandbox is only supported on Linux: running kubectl without it")
+	})
+
+	return newCommand().Run()
+}
@@ -0,0 +1,17 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package kubeapply
+
+// auditArch is unknown: seccomp is not used.
+const auditArch = 0
+
+// rlimitNproc varies across architectures: the process limit is not used.
+const rlimitNproc = -1
+
+var deniedSyscalls = []uint32{}
+
+const (
+	sysClone  = 0
+	sysClone3 = 0
+)
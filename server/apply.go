@@ -1,31 +1,64 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/henvic/kubeapply"
 	"github.com/henvic/kubeapply/server/decoding"
-	log "github.com/sirupsen/logrus"
+	"github.com/henvic/kubeapply/tracing"
 )
 
 // ApplyRequestBody for the apply endpoint.
 type ApplyRequestBody struct {
+	Cluster string                        `json:"cluster,omitempty"`
 	Command string                        `json:"command,omitempty"`
+	Args    []string                      `json:"args,omitempty"`
 	Files   map[string]decoding.FileValue `json:"files,omitempty"`
 	Flags   map[string]decoding.FlagValue `json:"flags,omitempty"`
+
+	// Modes of the uploaded files, such as "0755".
+	Modes map[string]decoding.FileMode `json:"modes,omitempty"`
+
+	// Chart to render with helm template and apply.
+	Chart *kubeapply.Chart `json:"chart,omitempty"`
+
+	// Vars used to render the templates of manifests (*.tmpl.yaml files).
+	Vars map[string]interface{} `json:"vars,omitempty"`
 }
 
-// FlagsMap gets the flags on a map[string]string.
-func (a *ApplyRequestBody) FlagsMap() map[string]string {
-	var m = map[string]string{}
+// FlagsMap gets the flags.
+func (a *ApplyRequestBody) FlagsMap() kubeapply.Flags {
+	var m = kubeapply.Flags{}
 
 	for k, v := range a.Flags {
-		m[k] = string(v)
+		m[k] = []string(v)
+	}
+
+	return m
+}
+
+// ModesMap gets the modes of the files, set either with modes or on the files.
+func (a *ApplyRequestBody) ModesMap() map[string]os.FileMode {
+	var m = map[string]os.FileMode{}
+
+	for k, v := range a.Modes {
+		m[k] = os.FileMode(v)
+	}
+
+	for k, v := range a.Files {
+		if v.Mode != nil {
+			m[k] = os.FileMode(*v.Mode)
+		}
 	}
 
 	return m
@@ -36,73 +69,326 @@ func (a *ApplyRequestBody) FilesMap() map[string][]byte {
 	var m = map[string][]byte{}
 
 	for k, v := range a.Files {
-		m[k] = v
+		m[k] = v.Data
 	}
 
 	return m
 }
 
-func handleApply(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleApply(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "handleApply")
+	defer span.End()
+
+	span.SetAttribute("request_id", RequestID(ctx))
+	r = r.WithContext(ctx)
+
 	if r.Method != http.MethodPut && r.Method != http.MethodPost {
 		ErrorHandler(w, r, http.StatusMethodNotAllowed,
 			"kubectl reference: https://kubernetes.io/docs/reference/generated/kubectl/kubectl-commands#apply")
 		return
 	}
 
-	if t := r.Header.Get("Content-Type"); !strings.Contains(t, "application/json") {
-		ErrorHandler(w, r, http.StatusNotAcceptable)
+	arb, dump, ok := s.decodeApplyRequest(w, r)
+
+	if !ok {
 		return
 	}
 
-	var dump, err = httputil.DumpRequest(r, true)
+	s.runApply(w, r, arb, dump)
+}
+
+// decodeApplyRequest and dump it for recording, writing an error response on failure.
+func (s *Server) decodeApplyRequest(w http.ResponseWriter, r *http.Request) (arb ApplyRequestBody, dump []byte, ok bool) {
+	var t = r.Header.Get("Content-Type")
+	var mediaType, params, _ = mime.ParseMediaType(t)
+
+	switch {
+	case strings.Contains(t, "application/json"):
+		mediaType = "application/json"
+	case mediaType == "multipart/form-data", mediaType == "application/gzip", mediaType == "application/x-gzip",
+		isYAML(mediaType):
+	default:
+		ErrorHandler(w, r, http.StatusNotAcceptable)
+		return arb, nil, false
+	}
+
+	if !s.limitBody(w, r) {
+		return arb, nil, false
+	}
+
+	dump, err := httputil.DumpRequest(r, true)
+
+	if isBodyTooLarge(err) {
+		ErrorHandler(w, r, http.StatusRequestEntityTooLarge, s.bodyLimitError(r.ContentLength).Error())
+		return arb, nil, false
+	}
 
 	if err != nil {
 		ErrorHandler(w, r, http.StatusInternalServerError, "cannot record request")
-		log.Errorf("cannot dump request (remote IP: %v", r.RemoteAddr)
+		logger(r).Errorf("cannot dump request (remote IP: %v", r.RemoteAddr)
+		return arb, nil, false
+	}
+
+	switch mediaType {
+	case "multipart/form-data":
+		arb, err = s.decodeMultipart(r, params["boundary"])
+	case "application/gzip", "application/x-gzip":
+		arb, err = s.decodeTarball(r)
+	case "application/json":
+		if ed := json.NewDecoder(r.Body).Decode(&arb); ed != nil {
+			ErrorHandler(w, r, http.StatusBadRequest, "cannot decode request body as JSON: "+ed.Error())
+			logger(r).Debugf("bad request: %v", ed)
+			return arb, nil, false
+		}
+	default:
+		arb, err = decodeYAML(r)
+	}
+
+	if err == nil {
+		err = arb.checkModes()
+	}
+
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return arb, nil, false
+	}
+
+	return arb, dump, true
+}
+
+func (s *Server) runApply(w http.ResponseWriter, r *http.Request, arb ApplyRequestBody, dump []byte) {
+	var c, err = s.resolveCluster(r, arb)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	arb := ApplyRequestBody{}
+	var a = s.newApply(r, arb, dump, c)
+
+	if err := a.CheckArgs(); err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.CheckUploads(); err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	if _, err := a.CheckManifests(); err != nil {
+		ErrorHandler(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if err := a.CheckChart(); err != nil {
+		var code = http.StatusUnprocessableEntity
+
+		if err == kubeapply.ErrHelmNotConfigured {
+			code = http.StatusNotImplemented
+		}
 
-	if ed := json.NewDecoder(r.Body).Decode(&arb); ed != nil {
-		ErrorHandler(w, r, http.StatusBadRequest, "cannot decode request body as JSON")
-		log.Debugf("bad request: %v", ed)
+		ErrorHandler(w, r, code, err.Error())
+		return
+	}
+
+	if !s.preflight(w, r, a, c) {
+		return
+	}
+
+	if wantsAsync(r) {
+		s.submitJob(w, r, a, arb)
+		return
+	}
+
+	if acceptsStream(r) {
+		w = newOutputStream(w, a)
+	}
+
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		s.runIdempotentApply(w, r, key, a, arb)
+		return
+	}
+
+	resp, err := s.execute(r, a, nil)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusServiceUnavailable, err.Error())
 		return
 	}
 
-	runApply(w, r, arb, dump)
+	writeApplyResponse(w, r, resp)
 }
 
-func runApply(w http.ResponseWriter, r *http.Request, arb ApplyRequestBody, dump []byte) {
-	a := &kubeapply.Apply{
+func (s *Server) runIdempotentApply(w http.ResponseWriter, r *http.Request, key string, a *kubeapply.Apply, arb ApplyRequestBody) {
+	if !idempotencyKeyRegex.MatchString(key) {
+		ErrorHandler(w, r, http.StatusBadRequest, "invalid "+IdempotencyKeyHeader+" header")
+		return
+	}
+
+	var hash, err = payloadHash(r.URL.Path, arb)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusInternalServerError, "cannot hash request payload")
+		logger(r).Errorf("cannot hash request payload: %v", err)
+		return
+	}
+
+	e, leader, err := s.idempotency.begin(key, hash)
+
+	if err != nil {
+		ErrorHandler(w, r, idempotencyErrorStatus(err), err.Error())
+		return
+	}
+
+	if !leader {
+		s.replayApply(w, r, e)
+		return
+	}
+
+	a.IdempotencyKey = key
+	a.PayloadHash = hash
+
+	// run detached from the client, so that its retries get the response even if its connection drops
+	resp, err := s.execute(r.WithContext(detachedContext{s.ctx, r.Context()}), a, nil)
+
+	if err != nil {
+		s.idempotency.abandon(key, e)
+		ErrorHandler(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	s.idempotency.complete(e, resp)
+	writeApplyResponse(w, r, resp)
+}
+
+func idempotencyErrorStatus(err error) int {
+	if err == errIdempotencyStoreFull {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+// detachedContext has the values of a request, such as its request ID and logger, but is only canceled with the server.
+type detachedContext struct {
+	context.Context
+	values context.Context
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.values.Value(key)
+}
+
+func (s *Server) replayApply(w http.ResponseWriter, r *http.Request, e *idempotencyEntry) {
+	logger(r).Debugf("waiting for request with the same %v", IdempotencyKeyHeader)
+
+	select {
+	case <-e.done:
+	case <-r.Context().Done():
+		ErrorHandler(w, r, http.StatusServiceUnavailable, "request canceled while waiting for in-flight request")
+		return
+	}
+
+	if e.abandoned {
+		ErrorHandler(w, r, http.StatusConflict, errIdempotencyKeyAbandoned.Error())
+		return
+	}
+
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	writeApplyResponse(w, r, e.resp)
+}
+
+func (s *Server) newApply(r *http.Request, arb ApplyRequestBody, dump []byte, c *Cluster) *kubeapply.Apply {
+	var params = s.settings()
+	var a = &kubeapply.Apply{
 		Subcommand: arb.Command,
 
+		Args:  arb.Args,
 		Flags: arb.FlagsMap(),
 		Files: arb.FilesMap(),
+		Modes: arb.ModesMap(),
 
-		IP: filterIP(r.RemoteAddr),
+		IP:        filterIP(r.RemoteAddr),
+		RequestID: RequestID(r.Context()),
 
 		RequestDump: dump,
+
+		Limits:       params.Limits,
+		EnvAllowlist: params.EnvAllowlist,
+		Sandbox:      params.Sandbox,
+
+		Chart: arb.Chart,
+		Helm:  params.Helm,
+		Vars:  arb.Vars,
 	}
 
-	log.Debugf("Preparing to run kubectl apply request from IP %v", r.RemoteAddr)
-	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	if c != nil {
+		a.Cluster = c.Name
+		a.Kubeconfig = c.Kubeconfig
+		a.Context = c.Context
+		a.Namespace = c.Namespace
+		a.Env = c.Env
+
+		if params.HomesDir != "" {
+			a.Home = filepath.Join(params.HomesDir, c.Name)
+		}
+	}
+
+	return a
+}
+
+// execute the command once a slot on the queue is available, calling started (if not nil) when it is.
+func (s *Server) execute(r *http.Request, a *kubeapply.Apply, started func()) (kubeapply.Response, error) {
+	for _, f := range a.Files {
+		UploadedBytes.With().Add(float64(len(f)))
+	}
+
+	logger(r).Debugf("Preparing to run kubectl apply request from IP %v", r.RemoteAddr)
+
+	if err := s.queue.acquire(r.Context()); err != nil {
+		return kubeapply.Response{}, errors.New("request canceled while waiting in the queue")
+	}
+
+	defer s.queue.release()
+
+	if started != nil {
+		started()
+	}
 
 	var resp, err = a.Run(r.Context())
 
 	if err != nil {
+		logger(r).Errorf("request %s had an unexpected error: %v", resp.ID, err)
+	}
+
+	return resp, nil
+}
+
+// writeApplyResponse with a status code depending on the exit code, or as the last event of a streamed response.
+func writeApplyResponse(w http.ResponseWriter, r *http.Request, resp kubeapply.Response) {
+	if o, ok := w.(*outputStream); ok {
+		o.done(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+
+	switch {
+	case resp.Render != nil && resp.Render.ExitCode != 0:
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	case resp.ExitCode != 0:
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Errorf("request %s had an unexpected error: %v", resp.ID, err)
 	}
 
 	if ee := json.NewEncoder(w).Encode(resp); ee != nil {
 		ErrorHandler(w, r, -1, fmt.Sprintf("cannot encode response for request %s: %v", resp.ID, ee))
-		log.Errorf("cannot encode response for request %s: %v", resp.ID, ee)
+		logger(r).Errorf("cannot encode response for request %s: %v", resp.ID, ee)
 		return
 	}
 
-	if err == nil {
-		log.Infof("request %v fulfilled with success", resp.ID)
+	if resp.ExitCode == 0 {
+		logger(r).Infof("request %v fulfilled with success", resp.ID)
 	}
 }
 
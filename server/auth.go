@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// APIToken accepted by the API.
+type APIToken struct {
+	// Name of the token, logged on each request using it.
+	Name string `json:"name"`
+
+	Token string `json:"token"`
+}
+
+// publicPaths don't require an API token.
+// The admin API uses its own token.
+var publicPaths = map[string]struct{}{
+	"/":        {},
+	"/healthz": {},
+	"/readyz":  {},
+}
+
+func isPublic(path string) bool {
+	if _, ok := publicPaths[path]; ok {
+		return true
+	}
+
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// authenticate requests with the API tokens, if any is set.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tokens = s.settings().APITokens
+
+		if len(tokens) == 0 || isPublic(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, ok := checkToken(tokens, bearerToken(r))
+
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kubeapply"`)
+			ErrorHandler(w, r, http.StatusUnauthorized)
+			logger(r).Warnf("unauthorized request from IP %v", r.RemoteAddr)
+			return
+		}
+
+		logger(r).Debugf("Request authenticated with token %v", name)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken of the Authorization header, or an empty string if it doesn't use the Bearer scheme.
+func bearerToken(r *http.Request) string {
+	var h = r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimPrefix(h, "Bearer ")
+}
+
+// checkToken returns the name of the matching token, comparing all of them in constant time.
+func checkToken(tokens []APIToken, token string) (name string, ok bool) {
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 && t.Token != "" {
+			name, ok = t.Name, true
+		}
+	}
+
+	return name, ok
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/henvic/kubeapply"
+)
+
+// Cluster profile.
+type Cluster struct {
+	Name string `json:"name"`
+
+	// Kubeconfig file path. The default kubeconfig is used if empty.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+
+	// Context of the kubeconfig. The current context is used if empty.
+	Context string `json:"context,omitempty"`
+
+	// Namespace used by default.
+	Namespace string `json:"namespace,omitempty"`
+
+	// AllowedSubcommands of kubectl. All subcommands are allowed if empty.
+	AllowedSubcommands []string `json:"allowed_subcommands,omitempty"`
+
+	// OpenAPISpec is the OpenAPI v2 specification file (swagger.json) of the cluster, used to validate objects.
+	OpenAPISpec string `json:"openapi_spec,omitempty"`
+
+	// Env variables passed to kubectl, such as the ones required by exec credential plugins.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+var envNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var clusterProfileRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]{0,62})$`)
+
+// Validate the cluster profile.
+func (c Cluster) Validate() error {
+	if !clusterProfileRegex.MatchString(c.Name) {
+		return fmt.Errorf(`invalid cluster name "%s": use up to 63 lowercase letters, digits, ".", "_", or "-"`, c.Name)
+	}
+
+	for _, sc := range c.AllowedSubcommands {
+		if strings.TrimSpace(sc) == "" {
+			return fmt.Errorf(`cluster "%s" has an empty allowed subcommand`, c.Name)
+		}
+	}
+
+	for k := range c.Env {
+		if !envNameRegex.MatchString(k) {
+			return fmt.Errorf(`cluster "%s" has an invalid environment variable name "%s"`, c.Name, k)
+		}
+	}
+
+	return nil
+}
+
+// Allows checks if the subcommand can be used on the cluster.
+func (c Cluster) Allows(subcommand string) bool {
+	return allows(c.AllowedSubcommands, subcommand)
+}
+
+// allows checks if the subcommand is on the allowed list, or if the list is empty.
+func allows(allowed []string, subcommand string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	if subcommand == "" {
+		subcommand = kubeapply.Command
+	}
+
+	for _, a := range allowed {
+		if subcommand == a || strings.HasPrefix(subcommand, a+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadClusters from a JSON file containing a list of cluster profiles.
+func LoadClusters(name string) ([]Cluster, error) {
+	b, err := ioutil.ReadFile(name) // #nosec
+
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []Cluster
+
+	if err := json.Unmarshal(b, &clusters); err != nil {
+		return nil, fmt.Errorf("cannot decode clusters file %s: %v", name, err)
+	}
+
+	return clusters, ValidateClusters(clusters)
+}
+
+// ValidateClusters checks the cluster profiles.
+func ValidateClusters(clusters []Cluster) error {
+	var names = map[string]struct{}{}
+
+	for _, c := range clusters {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf(`duplicated cluster "%s"`, c.Name)
+		}
+
+		names[c.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// connectionFlags can't be used on requests to clusters with profiles.
+var connectionFlags = map[string]struct{}{
+	"as":                       {},
+	"as-group":                 {},
+	"as-uid":                   {},
+	"certificate-authority":    {},
+	"client-certificate":       {},
+	"client-key":               {},
+	"cluster":                  {},
+	"context":                  {},
+	"insecure-skip-tls-verify": {},
+	"kubeconfig":               {},
+	"password":                 {},
+	"s":                        {},
+	"server":                   {},
+	"tls-server-name":          {},
+	"token":                    {},
+	"user":                     {},
+	"username":                 {},
+}
+
+// flagName normalizes keys such as "--kubeconfig" or "kubeconfig=x" to "kubeconfig".
+func flagName(key string) string {
+	return strings.SplitN(strings.TrimLeft(key, "-"), "=", 2)[0]
+}
+
+var errClusterRequired = errors.New("cluster is required: use the cluster field or /clusters/{name}/apply")
+
+// resolveCluster for the request.
+// It returns nil if no cluster profile is configured.
+func (s *Server) resolveCluster(r *http.Request, arb ApplyRequestBody) (*Cluster, error) {
+	var name = arb.Cluster
+
+	if !allows(s.settings().AllowedSubcommands, arb.Command) {
+		return nil, fmt.Errorf(`subcommand "%s" is not allowed`, arb.Command)
+	}
+
+	if pn := clusterFromPath(r.Context()); pn != "" {
+		if name != "" && name != pn {
+			return nil, fmt.Errorf(`cluster "%s" on the request body doesn't match cluster "%s" on the path`, name, pn)
+		}
+
+		name = pn
+	}
+
+	if len(s.clusters()) == 0 {
+		if name != "" {
+			return nil, errors.New("no cluster profile is configured")
+		}
+
+		return nil, nil
+	}
+
+	if name == "" {
+		name = s.defaultCluster()
+	}
+
+	if name == "" {
+		return nil, errClusterRequired
+	}
+
+	c, ok := s.findCluster(name)
+
+	if !ok {
+		return nil, fmt.Errorf(`cluster "%s" not found`, name)
+	}
+
+	if !c.Allows(arb.Command) {
+		return nil, fmt.Errorf(`subcommand "%s" is not allowed on cluster "%s"`, arb.Command, name)
+	}
+
+	for k := range arb.Flags {
+		if _, ok := connectionFlags[flagName(k)]; ok {
+			return nil, fmt.Errorf(`flag "%s" is not allowed: cluster connection is set by the cluster profile`, k)
+		}
+	}
+
+	return &c, nil
+}
+
+func (s *Server) defaultCluster() string {
+	if name := s.settings().DefaultCluster; name != "" {
+		return name
+	}
+
+	if cs := s.clusters(); len(cs) == 1 {
+		return cs[0].Name
+	}
+
+	return ""
+}
+
+// clusters returns the cluster profiles and the clusters of the registered kubeconfigs.
+func (s *Server) clusters() []Cluster {
+	var cs = append([]Cluster{}, s.settings().Clusters...)
+
+	for _, name := range s.kubeconfigs.names() {
+		if _, ok := s.findProfile(name); !ok {
+			cs = append(cs, s.kubeconfigs.cluster(name))
+		}
+	}
+
+	return cs
+}
+
+func (s *Server) findProfile(name string) (Cluster, bool) {
+	for _, c := range s.settings().Clusters {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return Cluster{}, false
+}
+
+func (s *Server) findCluster(name string) (Cluster, bool) {
+	if c, ok := s.findProfile(name); ok {
+		return c, true
+	}
+
+	if s.kubeconfigs.exists(name) {
+		return s.kubeconfigs.cluster(name), true
+	}
+
+	return Cluster{}, false
+}
+
+type clusterPathKey struct{}
+
+func clusterFromPath(ctx context.Context) string {
+	name, _ := ctx.Value(clusterPathKey{}).(string)
+	return name
+}
+
+// handleClusters routes /clusters, /clusters/{name}/apply, /clusters/{name}/validate, and /clusters/{name}/render.
+func (s *Server) handleClusters(w http.ResponseWriter, r *http.Request) {
+	var parts = strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/clusters"), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		s.handleListClusters(w, r)
+	case len(parts) == 2 && parts[1] == "apply" && clusterProfileRegex.MatchString(parts[0]):
+		ctx := context.WithValue(r.Context(), clusterPathKey{}, parts[0])
+		s.handleApply(w, r.WithContext(ctx))
+	case len(parts) == 2 && parts[1] == "validate" && clusterProfileRegex.MatchString(parts[0]):
+		ctx := context.WithValue(r.Context(), clusterPathKey{}, parts[0])
+		s.handleValidate(w, r.WithContext(ctx))
+	case len(parts) == 2 && parts[1] == "render" && clusterProfileRegex.MatchString(parts[0]):
+		ctx := context.WithValue(r.Context(), clusterPathKey{}, parts[0])
+		s.handleRender(w, r.WithContext(ctx))
+	default:
+		ErrorHandler(w, r, http.StatusNotFound)
+	}
+}
+
+type clusterInfo struct {
+	Name               string   `json:"name"`
+	Namespace          string   `json:"namespace,omitempty"`
+	AllowedSubcommands []string `json:"allowed_subcommands,omitempty"`
+	Default            bool     `json:"default,omitempty"`
+}
+
+func (s *Server) handleListClusters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var list = []clusterInfo{}
+	var def = s.defaultCluster()
+
+	for _, c := range s.clusters() {
+		list = append(list, clusterInfo{
+			Name:               c.Name,
+			Namespace:          c.Namespace,
+			AllowedSubcommands: c.AllowedSubcommands,
+			Default:            c.Name == def,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		logger(r).Errorf("error responding /clusters request: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/henvic/kubeapply/server/decoding"
+)
+
+var clusterAllowsCases = []struct {
+	allowed    []string
+	subcommand string
+	want       bool
+}{
+	{nil, "delete", true},
+	{[]string{"apply"}, "", true},
+	{[]string{"apply"}, "apply", true},
+	{[]string{"apply"}, "applyx", false},
+	{[]string{"rollout"}, "rollout status", true},
+	{[]string{"rollout status"}, "rollout restart", false},
+}
+
+func TestClusterAllows(t *testing.T) {
+	for _, tt := range clusterAllowsCases {
+		var c = Cluster{Name: "c", AllowedSubcommands: tt.allowed}
+
+		if got := c.Allows(tt.subcommand); got != tt.want {
+			t.Errorf("Expected Cluster{%v}.Allows(%v) = %v, got %v instead", tt.allowed, tt.subcommand, tt.want, got)
+		}
+	}
+}
+
+func TestValidateClusters(t *testing.T) {
+	if err := ValidateClusters([]Cluster{{Name: "prod"}, {Name: "dev-1"}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := ValidateClusters([]Cluster{{Name: "prod"}, {Name: "prod"}}); err == nil {
+		t.Errorf("Expected error for duplicated cluster")
+	}
+
+	if err := ValidateClusters([]Cluster{{Name: "../prod"}}); err == nil {
+		t.Errorf("Expected error for invalid cluster name")
+	}
+
+	if err := ValidateClusters([]Cluster{{Name: "prod", Env: map[string]string{"A=B": "C"}}}); err == nil {
+		t.Errorf("Expected error for invalid environment variable name")
+	}
+}
+
+var resolveClusterCases = []struct {
+	name     string
+	path     string
+	arb      ApplyRequestBody
+	want     string
+	wantFail bool
+}{
+	{"default", "", ApplyRequestBody{}, "dev", false},
+	{"body", "", ApplyRequestBody{Cluster: "prod"}, "prod", false},
+	{"path", "prod", ApplyRequestBody{}, "prod", false},
+	{"path and body mismatch", "prod", ApplyRequestBody{Cluster: "dev"}, "", true},
+	{"registered kubeconfig", "", ApplyRequestBody{Cluster: "staging"}, "staging", false},
+	{"not found", "", ApplyRequestBody{Cluster: "qa"}, "", true},
+	{"subcommand not allowed", "prod", ApplyRequestBody{Command: "delete"}, "", true},
+	{"subcommand allowed on server", "", ApplyRequestBody{Command: "delete"}, "dev", false},
+	{"subcommand not allowed on server", "", ApplyRequestBody{Command: "rollout status"}, "", true},
+	{"connection flag", "", ApplyRequestBody{
+		Flags: map[string]decoding.FlagValue{"--kubeconfig": {"/etc/x"}},
+	}, "", true},
+}
+
+func TestResolveCluster(t *testing.T) {
+	var dir = t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "staging"), []byte("apiVersion: v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var s = &Server{
+		kubeconfigs: &kubeconfigs{dir: dir},
+		params: Params{
+			Clusters: []Cluster{
+				{Name: "prod", AllowedSubcommands: []string{"apply"}},
+				{Name: "dev"},
+			},
+			DefaultCluster:     "dev",
+			AllowedSubcommands: []string{"apply", "delete"},
+		},
+	}
+
+	for _, tt := range resolveClusterCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodPut, "/apply", nil)
+
+			if tt.path != "" {
+				r = r.WithContext(context.WithValue(r.Context(), clusterPathKey{}, tt.path))
+			}
+
+			c, err := s.resolveCluster(r, tt.arb)
+
+			if (err != nil) != tt.wantFail {
+				t.Fatalf("Expected failure = %v, got error %v instead", tt.wantFail, err)
+			}
+
+			if err == nil && c.Name != tt.want {
+				t.Errorf("Expected cluster %v, got %v instead", tt.want, c.Name)
+			}
+
+			if err == nil && c.Name == "staging" && c.Kubeconfig != filepath.Join(dir, "staging") {
+				t.Errorf("Expected registered kubeconfig to be used, got %v instead", c.Kubeconfig)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/henvic/kubeapply"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file of the server, in YAML or JSON.
+type Config struct {
+	Listen []Listener `json:"listen,omitempty"`
+
+	Auth     Auth     `json:"auth"`
+	Policies Policies `json:"policies"`
+
+	Clusters       []Cluster `json:"clusters,omitempty"`
+	DefaultCluster string    `json:"default_cluster,omitempty"`
+	CheckClusters  bool      `json:"check_clusters"`
+
+	Limits    ConfigLimits `json:"limits"`
+	Retention Retention    `json:"retention"`
+	Logging   Logging      `json:"logging"`
+}
+
+// Auth settings.
+type Auth struct {
+	// AdminToken for the admin API. The admin API is disabled if empty.
+	AdminToken string `json:"admin_token,omitempty"`
+
+	// Tokens accepted by the API. Requests don't require a token if empty.
+	Tokens []APIToken `json:"tokens,omitempty"`
+}
+
+// Policies of the kubectl executions.
+type Policies struct {
+	// ValidateBeforeApply validates the objects against the OpenAPI schemas before running kubectl.
+	ValidateBeforeApply bool `json:"validate_before_apply"`
+
+	// AllowedSubcommands of kubectl on any cluster. All subcommands are allowed if empty.
+	AllowedSubcommands []string `json:"allowed_subcommands,omitempty"`
+
+	// EnvAllowlist of environment variables passed to kubectl. kubeapply.DefaultEnvAllowlist is used if empty.
+	EnvAllowlist []string `json:"env_allowlist,omitempty"`
+}
+
+// ConfigLimits on the requests and on the server (use 0 for no limit).
+type ConfigLimits struct {
+	MaxConcurrency int    `json:"max_concurrency"`
+	MaxBodySize    int64  `json:"max_body_size"`
+	MaxFiles       int    `json:"max_files"`
+	MaxFileSize    int64  `json:"max_file_size"`
+	MaxTotalSize   int64  `json:"max_total_size"`
+	MaxPathDepth   int    `json:"max_path_depth"`
+	MinFreeSpace   uint64 `json:"min_free_space"`
+}
+
+// Retention of the idempotency keys, jobs, and cached responses.
+type Retention struct {
+	IdempotencyTTL  Duration `json:"idempotency_ttl"`
+	JobTTL          Duration `json:"job_ttl"`
+	VersionCacheTTL Duration `json:"version_cache_ttl"`
+}
+
+// Logging settings.
+type Logging struct {
+	// Level of logging, such as "debug", "info", or "warn".
+	Level string `json:"level,omitempty"`
+
+	// Format of the logs: "text" (default) or "json".
+	Format string `json:"format,omitempty"`
+}
+
+// Duration encoded as a string, such as "90s" or "24h".
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes the duration from a string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf(`invalid duration %s: use a string such as "90s" or "24h"`, b)
+	}
+
+	v, err := time.ParseDuration(s)
+
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// NewConfig with the values of the params.
+func NewConfig(p Params) Config {
+	var c = Config{
+		Listen: p.Listeners,
+		Auth: Auth{
+			AdminToken: p.AdminToken,
+			Tokens:     p.APITokens,
+		},
+		Policies: Policies{
+			ValidateBeforeApply: p.ValidateBeforeApply,
+			AllowedSubcommands:  p.AllowedSubcommands,
+			EnvAllowlist:        p.EnvAllowlist,
+		},
+		Clusters:       p.Clusters,
+		DefaultCluster: p.DefaultCluster,
+		CheckClusters:  p.CheckClusters,
+		Limits: ConfigLimits{
+			MaxConcurrency: p.MaxConcurrency,
+			MaxBodySize:    p.MaxBodySize,
+			MaxFiles:       p.Limits.MaxFiles,
+			MaxFileSize:    p.Limits.MaxFileSize,
+			MaxTotalSize:   p.Limits.MaxTotalSize,
+			MaxPathDepth:   p.Limits.MaxPathDepth,
+			MinFreeSpace:   p.MinFreeSpace,
+		},
+		Retention: Retention{
+			IdempotencyTTL:  Duration(p.IdempotencyTTL),
+			JobTTL:          Duration(p.JobTTL),
+			VersionCacheTTL: Duration(p.VersionCacheTTL),
+		},
+	}
+
+	if len(c.Listen) == 0 && p.Address != "" {
+		c.Listen = []Listener{{Address: p.Address}}
+	}
+
+	return c
+}
+
+// Params with the values of the config, and the other values from base.
+func (c Config) Params(base Params) Params {
+	var p = base
+
+	p.Listeners = c.Listen
+	p.AdminToken = c.Auth.AdminToken
+	p.APITokens = c.Auth.Tokens
+	p.ValidateBeforeApply = c.Policies.ValidateBeforeApply
+	p.AllowedSubcommands = c.Policies.AllowedSubcommands
+	p.EnvAllowlist = c.Policies.EnvAllowlist
+	p.Clusters = c.Clusters
+	p.DefaultCluster = c.DefaultCluster
+	p.CheckClusters = c.CheckClusters
+	p.MaxConcurrency = c.Limits.MaxConcurrency
+	p.MaxBodySize = c.Limits.MaxBodySize
+	p.Limits = kubeapply.Limits{
+		MaxFiles:     c.Limits.MaxFiles,
+		MaxFileSize:  c.Limits.MaxFileSize,
+		MaxTotalSize: c.Limits.MaxTotalSize,
+		MaxPathDepth: c.Limits.MaxPathDepth,
+	}
+	p.MinFreeSpace = c.Limits.MinFreeSpace
+	p.IdempotencyTTL = time.Duration(c.Retention.IdempotencyTTL)
+	p.JobTTL = time.Duration(c.Retention.JobTTL)
+	p.VersionCacheTTL = time.Duration(c.Retention.VersionCacheTTL)
+	return p
+}
+
+// LoadConfig from a YAML or JSON file, over the values of base.
+// Settings missing on the file keep the values of base. Objects are merged, and lists are replaced.
+func LoadConfig(name string, base Params) (Config, error) {
+	b, err := ioutil.ReadFile(name) // #nosec
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	c, err := parseConfig(b, NewConfig(base))
+
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid config file %s: %v", name, err)
+	}
+
+	return c, nil
+}
+
+func parseConfig(b []byte, base Config) (Config, error) {
+	var doc interface{}
+
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return Config{}, err
+	}
+
+	if doc == nil {
+		return base, base.Validate()
+	}
+
+	overrides, ok := doc.(map[string]interface{})
+
+	if !ok {
+		return Config{}, errors.New("config must be an object")
+	}
+
+	var merged map[string]interface{}
+
+	if err := convert(base, &merged); err != nil {
+		return Config{}, err
+	}
+
+	merge(merged, overrides)
+
+	var c Config
+
+	// yaml.v3 decodes mappings as map[string]interface{}, which can be converted to JSON
+	j, err := json.Marshal(merged)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	var dec = json.NewDecoder(bytes.NewReader(j))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&c); err != nil {
+		return Config{}, errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	return c, c.Validate()
+}
+
+// convert v to another type through JSON.
+func convert(v, to interface{}) error {
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, to)
+}
+
+// merge the objects of src into dst, replacing any other values.
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+
+		if ok && dok {
+			merge(dm, sm)
+			continue
+		}
+
+		dst[k] = v
+	}
+}
+
+// Validate the config.
+func (c Config) Validate() error {
+	var addresses = map[string]struct{}{}
+
+	for i, l := range c.Listen {
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return fmt.Errorf("listen[%d].address: %v", i, err)
+		}
+
+		if _, ok := addresses[l.Address]; ok {
+			return fmt.Errorf(`listen[%d].address: duplicated address "%s"`, i, l.Address)
+		}
+
+		addresses[l.Address] = struct{}{}
+
+		if l.TLS == nil {
+			continue
+		}
+
+		if _, err := l.TLS.Config(); err != nil {
+			return fmt.Errorf("listen[%d].tls: %v", i, err)
+		}
+	}
+
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+
+	for i, sc := range c.Policies.AllowedSubcommands {
+		if strings.TrimSpace(sc) == "" {
+			return fmt.Errorf("policies.allowed_subcommands[%d]: empty subcommand", i)
+		}
+	}
+
+	if err := ValidateClusters(c.Clusters); err != nil {
+		return fmt.Errorf("clusters: %v", err)
+	}
+
+	if err := c.Limits.validate(); err != nil {
+		return err
+	}
+
+	if c.Retention.IdempotencyTTL < 0 || c.Retention.JobTTL < 0 || c.Retention.VersionCacheTTL < 0 {
+		return errors.New("retention: durations cannot be negative")
+	}
+
+	return c.Logging.validate()
+}
+
+func (a Auth) validate() error {
+	var names = map[string]struct{}{}
+	var tokens = map[string]struct{}{}
+
+	for i, t := range a.Tokens {
+		if t.Name == "" || t.Token == "" {
+			return fmt.Errorf("auth.tokens[%d]: name and token are required", i)
+		}
+
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf(`auth.tokens[%d]: duplicated name "%s"`, i, t.Name)
+		}
+
+		if _, ok := tokens[t.Token]; ok {
+			return fmt.Errorf(`auth.tokens[%d]: token of "%s" is already used`, i, t.Name)
+		}
+
+		names[t.Name] = struct{}{}
+		tokens[t.Token] = struct{}{}
+	}
+
+	return nil
+}
+
+func (l ConfigLimits) validate() error {
+	var limits = []struct {
+		name  string
+		value int64
+	}{
+		{"max_concurrency", int64(l.MaxConcurrency)},
+		{"max_body_size", l.MaxBodySize},
+		{"max_files", int64(l.MaxFiles)},
+		{"max_file_size", l.MaxFileSize},
+		{"max_total_size", l.MaxTotalSize},
+		{"max_path_depth", int64(l.MaxPathDepth)},
+	}
+
+	for _, limit := range limits {
+		if limit.value < 0 {
+			return fmt.Errorf("limits.%s: cannot be negative", limit.name)
+		}
+	}
+
+	return nil
+}
+
+func (l Logging) validate() error {
+	if l.Level != "" {
+		if _, err := log.ParseLevel(l.Level); err != nil {
+			return fmt.Errorf("logging.level: %v", err)
+		}
+	}
+
+	switch l.Format {
+	case "", "text", "json":
+		return nil
+	default:
+		return fmt.Errorf(`logging.format: invalid format "%s": use "text" or "json"`, l.Format)
+	}
+}
+
+// Configure the logger.
+func (l Logging) Configure() {
+	if level, err := log.ParseLevel(l.Level); err == nil {
+		log.SetLevel(level)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+
+	switch l.Format {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.SetFormatter(&log.TextFormatter{})
+	}
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/henvic/kubeapply"
+)
+
+var baseParams = Params{
+	Address:        "127.0.0.1:9000",
+	MaxConcurrency: 16,
+	MaxBodySize:    DefaultMaxBodySize,
+	Limits:         kubeapply.DefaultLimits,
+	JobTTL:         DefaultJobTTL,
+	Clusters:       []Cluster{{Name: "dev", Env: map[string]string{"A": "B"}}},
+	DefaultCluster: "dev",
+}
+
+var parseConfigCases = []struct {
+	name    string
+	config  string
+	want    func(p *Params)
+	wantErr string
+}{
+	{
+		name:   "empty",
+		config: "",
+		want: func(p *Params) {
+			p.Listeners = []Listener{{Address: "127.0.0.1:9000"}}
+		},
+	},
+	{
+		name: "yaml",
+		config: `listen:
+- address: ":9001"
+- address: "127.0.0.1:9002"
+auth:
+  admin_token: secret
+  tokens:
+  - name: ci
+    token: abc
+policies:
+  validate_before_apply: false
+  allowed_subcommands: [apply, diff]
+clusters:
+- name: prod
+  context: prod
+default_cluster: prod
+limits:
+  max_files: 10
+retention:
+  job_ttl: 10m
+logging:
+  level: debug
+`,
+		want: func(p *Params) {
+			p.Listeners = []Listener{{Address: ":9001"}, {Address: "127.0.0.1:9002"}}
+			p.AdminToken = "secret"
+			p.APITokens = []APIToken{{Name: "ci", Token: "abc"}}
+			p.AllowedSubcommands = []string{"apply", "diff"}
+			p.Clusters = []Cluster{{Name: "prod", Context: "prod"}}
+			p.DefaultCluster = "prod"
+			p.Limits.MaxFiles = 10
+			p.JobTTL = 10 * time.Minute
+		},
+	},
+	{
+		name:   "json",
+		config: `{"limits": {"max_body_size": 0}, "check_clusters": true}`,
+		want: func(p *Params) {
+			p.Listeners = []Listener{{Address: "127.0.0.1:9000"}}
+			p.MaxBodySize = 0
+			p.CheckClusters = true
+		},
+	},
+	{
+		name:    "not an object",
+		config:  "- a",
+		wantErr: "config must be an object",
+	},
+	{
+		name:    "unknown field",
+		config:  "limits:\n  max_file: 1\n",
+		wantErr: `unknown field "max_file"`,
+	},
+	{
+		name:    "invalid duration",
+		config:  "retention:\n  job_ttl: 10\n",
+		wantErr: `invalid duration 10: use a string such as "90s" or "24h"`,
+	},
+	{
+		name:    "invalid address",
+		config:  "listen:\n- address: localhost\n",
+		wantErr: "listen[0].address: address localhost: missing port in address",
+	},
+	{
+		name:    "duplicated address",
+		config:  "listen:\n- address: :9000\n- address: :9000\n",
+		wantErr: `listen[1].address: duplicated address ":9000"`,
+	},
+	{
+		name:    "missing certificate",
+		config:  "listen:\n- address: :9443\n  tls:\n    key_file: key.pem\n",
+		wantErr: "listen[0].tls: cert_file and key_file are required",
+	},
+	{
+		name:    "token without name",
+		config:  "auth:\n  tokens:\n  - token: abc\n",
+		wantErr: "auth.tokens[0]: name and token are required",
+	},
+	{
+		name:    "duplicated token",
+		config:  "auth:\n  tokens:\n  - {name: a, token: abc}\n  - {name: b, token: abc}\n",
+		wantErr: `auth.tokens[1]: token of "b" is already used`,
+	},
+	{
+		name:    "invalid cluster",
+		config:  "clusters:\n- name: Prod\n",
+		wantErr: `clusters: invalid cluster name "Prod": use up to 63 lowercase letters, digits, ".", "_", or "-"`,
+	},
+	{
+		name:    "negative limit",
+		config:  "limits:\n  max_files: -1\n",
+		wantErr: "limits.max_files: cannot be negative",
+	},
+	{
+		name:    "invalid logging level",
+		config:  "logging:\n  level: verbose\n",
+		wantErr: `logging.level: not a valid logrus Level: "verbose"`,
+	},
+	{
+		name:    "invalid logging format",
+		config:  "logging:\n  format: xml\n",
+		wantErr: `logging.format: invalid format "xml": use "text" or "json"`,
+	},
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, tt := range parseConfigCases {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseConfig([]byte(tt.config), NewConfig(baseParams))
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Expected error to be %q, got %v instead", tt.wantErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, got %v instead", err)
+			}
+
+			var want = baseParams
+			tt.want(&want)
+
+			if got := c.Params(baseParams); !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected params to be %+v, got %+v instead", want, got)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	var name = filepath.Join(t.TempDir(), "kubeapply.yaml")
+
+	if _, err := LoadConfig(name, baseParams); err == nil {
+		t.Errorf("Expected error loading missing config file")
+	}
+
+	if err := ioutil.WriteFile(name, []byte("logging:\n  format: json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(name, baseParams)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if c.Logging.Format != "json" {
+		t.Errorf("Expected logging format to be json, got %v instead", c.Logging.Format)
+	}
+
+	if err := ioutil.WriteFile(name, []byte("limits: [1]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var wantErr = "invalid config file " + name +
+		": cannot unmarshal array into Go struct field Config.limits of type server.ConfigLimits"
+
+	if _, err := LoadConfig(name, baseParams); err == nil || err.Error() != wantErr {
+		t.Errorf("Expected error to be %q, got %v instead", wantErr, err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	var s = &Server{}
+
+	if err := s.setup(context.Background(), baseParams); err != nil {
+		t.Fatal(err)
+	}
+
+	var params = baseParams
+	params.Address = "127.0.0.1:9001"
+	params.MaxConcurrency = 4
+	params.AdminToken = "secret"
+	params.JobTTL = time.Minute
+
+	if err := s.Reload(params); err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	var got = s.settings()
+
+	if got.Address != baseParams.Address || got.MaxConcurrency != baseParams.MaxConcurrency {
+		t.Errorf("Expected address and max concurrency to be kept, got %v and %v instead", got.Address, got.MaxConcurrency)
+	}
+
+	if got.AdminToken != "secret" {
+		t.Errorf("Expected admin token to be reloaded, got %q instead", got.AdminToken)
+	}
+
+	if s.jobs.ttl != time.Minute {
+		t.Errorf("Expected job TTL to be reloaded, got %v instead", s.jobs.ttl)
+	}
+
+	params.DefaultCluster = "prod"
+
+	if err := s.Reload(params); err == nil {
+		t.Errorf("Expected error reloading with a default cluster that doesn't exist")
+	}
+
+	if got := s.settings().DefaultCluster; got != "dev" {
+		t.Errorf("Expected settings to be kept after failed reload, got default cluster %v instead", got)
+	}
+}
+
+var authenticateCases = []struct {
+	name          string
+	path          string
+	authorization string
+	status        int
+}{
+	{"public", "/healthz", "", http.StatusOK},
+	{"admin", "/admin/kubeconfigs", "", http.StatusNotFound},
+	{"missing token", "/jobs/x", "", http.StatusUnauthorized},
+	{"wrong token", "/jobs/x", "Bearer xyz", http.StatusUnauthorized},
+	{"token", "/jobs/x", "Bearer abc", http.StatusNotFound},
+	{"other token", "/jobs/x", "Bearer def", http.StatusNotFound},
+	{"token without scheme", "/jobs/x", "abc", http.StatusUnauthorized},
+	{"token with other scheme", "/jobs/x", "Basic abc", http.StatusUnauthorized},
+	{"metrics without token", "/metrics", "", http.StatusUnauthorized},
+	{"metrics", "/metrics", "Bearer abc", http.StatusNotFound},
+}
+
+func TestAuthenticate(t *testing.T) {
+	var s = &Server{
+		params: Params{
+			APITokens: []APIToken{{Name: "ci", Token: "abc"}, {Name: "deploy", Token: "def"}},
+		},
+	}
+
+	var h = s.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	for _, tt := range authenticateCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodGet, tt.path, nil)
+
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			var w = httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d instead", tt.status, w.Code)
+			}
+
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Expected WWW-Authenticate header on unauthorized response")
+			}
+		})
+	}
+}
+
+var checkAdminCases = []struct {
+	name          string
+	authorization string
+	want          bool
+}{
+	{"missing token", "", false},
+	{"wrong token", "Bearer xyz", false},
+	{"token without scheme", "secret", false},
+	{"token", "Bearer secret", true},
+}
+
+func TestCheckAdmin(t *testing.T) {
+	var s = &Server{params: Params{AdminToken: "secret"}}
+
+	for _, tt := range checkAdminCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodGet, "/admin/kubeconfigs", nil)
+
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			var w = httptest.NewRecorder()
+
+			if got := s.checkAdmin(w, r); got != tt.want {
+				t.Errorf("Expected checkAdmin to return %v, got %v instead", tt.want, got)
+			}
+
+			if !tt.want && (w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "") {
+				t.Errorf("Expected unauthorized response with WWW-Authenticate header, got %d instead", w.Code)
+			}
+		})
+	}
+}
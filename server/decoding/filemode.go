@@ -0,0 +1,47 @@
+package decoding
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// FileMode can be used to decode the permission bits of a file from an octal string, such as "0755".
+type FileMode os.FileMode
+
+var fileModeRegex = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+// ParseFileMode from an octal string.
+func ParseFileMode(s string) (FileMode, error) {
+	if !fileModeRegex.MatchString(s) {
+		return 0, fmt.Errorf(`invalid file mode "%s": use an octal string between "0000" and "0777"`, s)
+	}
+
+	m, err := strconv.ParseUint(s, 8, 32)
+	return FileMode(m), err
+}
+
+// MarshalJSON returns the mode as an octal string.
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%04o", uint32(m)))
+}
+
+// UnmarshalJSON parses the mode from an octal string.
+func (m *FileMode) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid file mode %s: use an octal string, such as \"0644\"", data)
+	}
+
+	var fm, err = ParseFileMode(s)
+
+	if err != nil {
+		return err
+	}
+
+	*m = fm
+	return nil
+}
@@ -0,0 +1,67 @@
+package decoding
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+var fileModeEncodingCases = []struct {
+	name    string
+	in      string
+	decoded FileMode
+	err     string
+}{
+	{
+		name:    "executable",
+		in:      `"0755"`,
+		decoded: 0755,
+	},
+	{
+		name:    "without leading zero",
+		in:      `"600"`,
+		decoded: 0600,
+	},
+	{
+		name: "number",
+		in:   "420",
+		err:  `invalid file mode 420: use an octal string, such as "0644"`,
+	},
+	{
+		name: "not octal",
+		in:   `"0981"`,
+		err:  `invalid file mode "0981": use an octal string between "0000" and "0777"`,
+	},
+	{
+		name: "setuid",
+		in:   `"4755"`,
+		err:  `invalid file mode "4755": use an octal string between "0000" and "0777"`,
+	},
+	{
+		name: "empty",
+		in:   `""`,
+		err:  `invalid file mode "": use an octal string between "0000" and "0777"`,
+	},
+}
+
+func TestFileModeEncoding(t *testing.T) {
+	for _, tt := range fileModeEncodingCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var m FileMode
+			var err = json.Unmarshal([]byte(tt.in), &m)
+
+			if m != tt.decoded || tt.err != "" && fmt.Sprint(err) != tt.err || tt.err == "" && err != nil {
+				t.Errorf("Expected FileMode.Unmarshal(%v) = (%o, %v), got (%o, %v) instead",
+					tt.in, tt.decoded, tt.err, m, err)
+			}
+		})
+	}
+}
+
+func TestFileModeMarshal(t *testing.T) {
+	var b, err = json.Marshal(FileMode(0644))
+
+	if string(b) != `"0644"` || err != nil {
+		t.Errorf(`Expected FileMode(0644) to be encoded as "0644", got (%s, %v) instead`, b, err)
+	}
+}
@@ -1,22 +1,52 @@
 package decoding
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
 )
 
 // FileValue can be used to decode an incoming file value.
-type FileValue []byte
+//
+// It is either a JSON string with the content of the file, a JSON object embedded as is,
+// or an object with the content as a string or encoded with base64, and an optional mode:
+// {"content": "...", "mode": "0600"} or {"base64": "...", "mode": "0600"}.
+type FileValue struct {
+	Data []byte
+
+	// Mode of the file, if set.
+	Mode *FileMode
+}
+
+// fileObject is the object form of a file value.
+type fileObject struct {
+	Base64  *string   `json:"base64,omitempty"`
+	Content *string   `json:"content,omitempty"`
+	Mode    *FileMode `json:"mode,omitempty"`
+}
+
+// MarshalJSON encodes the file value using the object form.
+func (f FileValue) MarshalJSON() ([]byte, error) {
+	var s = base64.StdEncoding.EncodeToString(f.Data)
+
+	return json.Marshal(fileObject{
+		Base64: &s,
+		Mode:   f.Mode,
+	})
+}
 
 // UnmarshalJSON parses the JSON-encoded flag value.
 func (f *FileValue) UnmarshalJSON(data []byte) error {
-	if err := f.unmarshalJSONData(data); err == nil {
-		return nil
+	if err := f.unmarshalJSONData(data); err != errNotObject {
+		return err
 	}
 
 	var s string
 	err := json.Unmarshal(data, &s)
-	*f = FileValue(s)
+	*f = FileValue{Data: []byte(s)}
 
 	if ec, ok := err.(*json.UnmarshalTypeError); ok {
 		ec.Type = reflect.TypeOf(f)
@@ -26,12 +56,50 @@ func (f *FileValue) UnmarshalJSON(data []byte) error {
 	return err
 }
 
+var errNotObject = errors.New("not an object")
+
 func (f *FileValue) unmarshalJSONData(data []byte) error {
 	var r map[string]json.RawMessage
 	if err := json.Unmarshal(data, &r); err != nil {
-		return err
+		return errNotObject
+	}
+
+	_, hasBase64 := r["base64"]
+	_, hasContent := r["content"]
+
+	if !hasBase64 && !hasContent {
+		*f = FileValue{Data: data}
+		return nil
+	}
+
+	return f.unmarshalFileObject(data)
+}
+
+func (f *FileValue) unmarshalFileObject(data []byte) error {
+	var o fileObject
+	var dec = json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&o); err != nil {
+		return fmt.Errorf("invalid file object: %v", err)
+	}
+
+	switch {
+	case o.Base64 != nil && o.Content != nil:
+		return errors.New(`invalid file object: use either "base64" or "content"`)
+	case o.Content != nil:
+		*f = FileValue{Data: []byte(*o.Content), Mode: o.Mode}
+		return nil
+	case o.Base64 == nil:
+		return errors.New(`invalid file object: "base64" or "content" must be a string`)
+	}
+
+	b, err := base64.StdEncoding.Strict().DecodeString(*o.Base64)
+
+	if err != nil {
+		return fmt.Errorf("invalid file object: cannot decode base64: %v", err)
 	}
 
-	*f = FileValue(data)
+	*f = FileValue{Data: b, Mode: o.Mode}
 	return nil
 }
@@ -1,33 +1,40 @@
 package decoding
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
+func fileMode(m FileMode) *FileMode {
+	return &m
+}
+
 var fileValueEncodingCases = []struct {
 	name    string
 	in      string
-	decoded FileValue
+	decoded string
+	mode    *FileMode
 	err     error
 }{
 	{
 		name:    "empty string",
 		in:      `""`,
-		decoded: FileValue(""),
+		decoded: "",
 		err:     nil,
 	},
 	{
 		name:    "string",
 		in:      `"common"`,
-		decoded: FileValue("common"),
+		decoded: "common",
 		err:     nil,
 	},
 	{
 		name:    "empty object",
 		in:      "{}",
-		decoded: FileValue("{}"),
+		decoded: "{}",
 		err:     nil,
 	},
 	{
@@ -38,13 +45,70 @@ var fileValueEncodingCases = []struct {
 	{
 		name:    "object",
 		in:      `{"foo": "bar"}`,
-		decoded: FileValue(`{"foo": "bar"}`),
+		decoded: `{"foo": "bar"}`,
 		err:     nil,
 	},
+	{
+		name:    "content",
+		in:      `{"content": "key", "mode": "0600"}`,
+		decoded: "key",
+		mode:    fileMode(0600),
+	},
+	{
+		name:    "base64",
+		in:      `{"base64": "AP8KLQ=="}`,
+		decoded: "\x00\xff\n-",
+	},
+	{
+		name:    "base64 with mode",
+		in:      `{"base64": "", "mode": "755"}`,
+		decoded: "",
+		mode:    fileMode(0755),
+	},
+	{
+		name: "invalid base64",
+		in:   `{"base64": "AP8KLQ="}`,
+		err:  errors.New("invalid file object: cannot decode base64: illegal base64 data at input byte 7"),
+	},
+	{
+		name:    "base64 with line breaks",
+		in:      `{"base64": "AP8K\nLQ=="}`,
+		decoded: "\x00\xff\n-",
+	},
+	{
+		name: "base64 with non-zero padding bits",
+		in:   `{"base64": "AP9="}`,
+		err:  errors.New("invalid file object: cannot decode base64: illegal base64 data at input byte 3"),
+	},
+	{
+		name: "base64 and content",
+		in:   `{"base64": "", "content": ""}`,
+		err:  errors.New(`invalid file object: use either "base64" or "content"`),
+	},
+	{
+		name: "unknown field",
+		in:   `{"content": "", "mod": "0600"}`,
+		err:  errors.New(`invalid file object: json: unknown field "mod"`),
+	},
+	{
+		name: "invalid mode",
+		in:   `{"content": "", "mode": "0800"}`,
+		err:  errors.New(`invalid file object: invalid file mode "0800": use an octal string between "0000" and "0777"`),
+	},
+	{
+		name: "null content",
+		in:   `{"content": null}`,
+		err:  errors.New(`invalid file object: "base64" or "content" must be a string`),
+	},
+	{
+		name: "content not a string",
+		in:   `{"content": 1}`,
+		err:  errors.New("invalid file object: json: cannot unmarshal number into Go struct field fileObject.content of type string"),
+	},
 	{
 		name:    "unexpected end of JSON input",
 		in:      "",
-		decoded: FileValue(""),
+		decoded: "",
 		err:     errors.New(`unexpected end of JSON input`),
 	},
 }
@@ -55,10 +119,25 @@ func TestFileValueEncoding(t *testing.T) {
 			var v FileValue
 			var err = v.UnmarshalJSON([]byte(tt.in))
 
-			if string(v) != string(tt.decoded) || fmt.Sprint(tt.err) != fmt.Sprint(err) {
-				t.Errorf("Expected FileValue.Unmarshal(%v) = (%v, %v), got (%v, %v) instead",
-					tt.in, tt.decoded, tt.err, v, err)
+			if string(v.Data) != tt.decoded || !reflect.DeepEqual(v.Mode, tt.mode) || fmt.Sprint(tt.err) != fmt.Sprint(err) {
+				t.Errorf("Expected FileValue.Unmarshal(%v) = (%v, %v, %v), got (%v, %v, %v) instead",
+					tt.in, tt.decoded, tt.mode, tt.err, string(v.Data), v.Mode, err)
 			}
 		})
 	}
 }
+
+func TestFileValueMarshal(t *testing.T) {
+	var in = FileValue{Data: []byte("\x00\xff"), Mode: fileMode(0600)}
+	var b, err = json.Marshal(in)
+
+	if string(b) != `{"base64":"AP8=","mode":"0600"}` || err != nil {
+		t.Errorf("Unexpected encoding: (%s, %v)", b, err)
+	}
+
+	var out FileValue
+
+	if err := json.Unmarshal(b, &out); err != nil || !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected %+v, got (%+v, %v) instead", in, out, err)
+	}
+}
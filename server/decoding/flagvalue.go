@@ -1,49 +1,96 @@
 package decoding
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 )
 
 // FlagValue can be used to decode an incoming flag value.
-type FlagValue string
+// It is either a string, number, or boolean, or an array of them for flags used more than once.
+type FlagValue []string
 
-// MarshalJSON returns a JSON string encoding of v.
+// MarshalJSON returns a JSON string encoding of v, or an array if it has more than one value.
 func (f FlagValue) MarshalJSON() ([]byte, error) {
-	return json.Marshal(string(f))
+	if len(f) == 1 {
+		return json.Marshal(f[0])
+	}
+
+	return json.Marshal([]string(f))
 }
 
 // UnmarshalJSON parses the JSON-encoded flag value.
+// A null value is decoded as a flag without a value.
 func (f *FlagValue) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*f = FlagValue{""}
+		return nil
+	}
+
+	var values []json.RawMessage
+
+	if err := json.Unmarshal(data, &values); err == nil {
+		return f.unmarshalJSONArray(values)
+	}
+
+	var s, err = unmarshalScalar(data)
+
+	if err != nil {
+		if ec, ok := err.(*json.UnmarshalTypeError); ok {
+			ec.Type = reflect.TypeOf(f)
+		}
+
+		return err
+	}
+
+	*f = FlagValue{s}
+	return nil
+}
+
+func (f *FlagValue) unmarshalJSONArray(values []json.RawMessage) error {
+	if len(values) == 0 {
+		return errors.New("flag value can't be an empty array")
+	}
+
+	var fv = FlagValue{}
+
+	for _, v := range values {
+		s, err := unmarshalScalar(v)
+
+		if err != nil {
+			return errors.New("flag values must be strings, numbers, or booleans")
+		}
+
+		fv = append(fv, s)
+	}
+
+	*f = fv
+	return nil
+}
+
+func unmarshalScalar(data []byte) (string, error) {
 	var s string
 	err := json.Unmarshal(data, &s)
 
 	if err == nil {
-		*f = FlagValue(s)
-		return nil
+		return s, nil
 	}
 
-	return f.unmarshalJSONSubtypes(data, err)
+	return unmarshalJSONSubtypes(data, err)
 }
 
-func (f *FlagValue) unmarshalJSONSubtypes(data []byte, ie error) error {
+func unmarshalJSONSubtypes(data []byte, ie error) (string, error) {
 	var n json.Number
 	if err := json.Unmarshal(data, &n); err == nil {
-		*f = FlagValue(fmt.Sprintf("%v", n))
-		return nil
+		return fmt.Sprintf("%v", n), nil
 	}
 
 	var b bool
 	if err := json.Unmarshal(data, &b); err == nil {
-		*f = FlagValue(fmt.Sprintf("%v", b))
-		return nil
-	}
-
-	if ec, ok := ie.(*json.UnmarshalTypeError); ok {
-		ec.Type = reflect.TypeOf(f)
-		return ec
+		return fmt.Sprintf("%v", b), nil
 	}
 
-	return ie
+	return "", ie
 }
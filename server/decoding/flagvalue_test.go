@@ -3,6 +3,7 @@ package decoding
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -15,55 +16,85 @@ var flagValueEncodingCases = []struct {
 	{
 		name:    "empty string",
 		in:      `""`,
-		decoded: FlagValue(""),
+		decoded: FlagValue{""},
+		err:     nil,
+	},
+	{
+		name:    "null",
+		in:      `null`,
+		decoded: FlagValue{""},
 		err:     nil,
 	},
 	{
 		name:    "string",
 		in:      `"common"`,
-		decoded: FlagValue("common"),
+		decoded: FlagValue{"common"},
 		err:     nil,
 	},
 	{
 		name:    "boolean value 'false'",
 		in:      `false`,
-		decoded: FlagValue("false"),
+		decoded: FlagValue{"false"},
 		err:     nil,
 	},
 	{
 		name:    "boolean value 'true'",
 		in:      `true`,
-		decoded: FlagValue("true"),
+		decoded: FlagValue{"true"},
 		err:     nil,
 	},
 	{
 		name:    "number value 0",
 		in:      `0`,
-		decoded: FlagValue("0"),
+		decoded: FlagValue{"0"},
 		err:     nil,
 	},
 	{
 		name:    "number value 123",
 		in:      `123`,
-		decoded: FlagValue("123"),
+		decoded: FlagValue{"123"},
 		err:     nil,
 	},
 	{
 		name:    "number value 3.14159265",
 		in:      `3.14159265`,
-		decoded: FlagValue("3.14159265"),
+		decoded: FlagValue{"3.14159265"},
 		err:     nil,
 	},
 	{
 		name:    "object error",
 		in:      "{}",
-		decoded: FlagValue(""),
+		decoded: nil,
 		err:     errors.New("json: cannot unmarshal object into Go value of type *decoding.FlagValue"),
 	},
+	{
+		name:    "array",
+		in:      `["a.yaml", "b.yaml"]`,
+		decoded: FlagValue{"a.yaml", "b.yaml"},
+		err:     nil,
+	},
+	{
+		name:    "array with numbers and booleans",
+		in:      `["app=web", 3, true]`,
+		decoded: FlagValue{"app=web", "3", "true"},
+		err:     nil,
+	},
+	{
+		name:    "empty array",
+		in:      `[]`,
+		decoded: nil,
+		err:     errors.New("flag value can't be an empty array"),
+	},
+	{
+		name:    "nested array",
+		in:      `["a", ["b"]]`,
+		decoded: nil,
+		err:     errors.New("flag values must be strings, numbers, or booleans"),
+	},
 	{
 		name:    "unexpected end of JSON input",
 		in:      "",
-		decoded: FlagValue(""),
+		decoded: nil,
 		err:     errors.New(`unexpected end of JSON input`),
 	},
 }
@@ -74,7 +105,7 @@ func TestFlagValueEncoding(t *testing.T) {
 			var v FlagValue
 			var err = v.UnmarshalJSON([]byte(tt.in))
 
-			if v != tt.decoded || fmt.Sprint(tt.err) != fmt.Sprint(err) {
+			if !reflect.DeepEqual(v, tt.decoded) || fmt.Sprint(tt.err) != fmt.Sprint(err) {
 				t.Errorf("Expected FlagValue.Unmarshal(%v) = (%v, %v), got (%v, %v) instead",
 					tt.in, tt.decoded, tt.err, v, err)
 			}
@@ -83,7 +114,7 @@ func TestFlagValueEncoding(t *testing.T) {
 }
 
 func TestFlagValueMarshal(t *testing.T) {
-	var v = FlagValue(`hello`)
+	var v = FlagValue{`hello`}
 
 	var b, err = v.MarshalJSON()
 
@@ -91,4 +122,11 @@ func TestFlagValueMarshal(t *testing.T) {
 		t.Errorf("Unexepcted FlagValue.Marshal value")
 	}
 
+	v = FlagValue{"a", "b"}
+	b, err = v.MarshalJSON()
+
+	if string(b) != `["a","b"]` || err != nil {
+		t.Errorf("Unexpected FlagValue.Marshal value for array: (%s, %v)", b, err)
+	}
+
 }
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package server
+
+// freeSpace is not supported on this platform.
+func freeSpace(path string) (uint64, bool, error) {
+	return 0, false, nil
+}
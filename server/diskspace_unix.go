@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package server
+
+import "syscall"
+
+// freeSpace available for unprivileged users on the filesystem of the given path.
+func freeSpace(path string) (uint64, bool, error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, true, err
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), true, nil // #nosec
+}
@@ -4,8 +4,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
-
-	log "github.com/sirupsen/logrus"
 )
 
 type response struct {
@@ -18,12 +16,16 @@ type response struct {
 // Use code = -1 to bypass writing header or trying to set Content-Type. Useful when headers are already sent.
 func ErrorHandler(w http.ResponseWriter, r *http.Request, code int, errors ...string) {
 	if code >= 0 {
+		if id := RequestID(r.Context()); id != "" {
+			w.Header().Set(RequestIDHeader, id)
+		}
+
 		w.Header().Set("Content-Type", "application/json; charset=utf8")
 		w.WriteHeader(code)
 	}
 
 	if code != http.StatusNotFound {
-		log.Debugf("Request error: %v (%v)", code, http.StatusText(code))
+		logger(r).Debugf("Request error: %v (%v)", code, http.StatusText(code))
 	}
 
 	if err := json.NewEncoder(w).Encode(response{
@@ -31,6 +33,6 @@ func ErrorHandler(w http.ResponseWriter, r *http.Request, code int, errors ...st
 		Message: http.StatusText(code),
 		Errors:  strings.Join(errors, "\n"),
 	}); err != nil {
-		log.Error(err)
+		logger(r).Error(err)
 	}
 }
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henvic/kubeapply"
+)
+
+// DefaultMinFreeSpace on the configurations directory for the service to be ready.
+const DefaultMinFreeSpace = 100 << 20
+
+const checkTimeout = 10 * time.Second
+
+type check struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Duration string `json:"duration"`
+}
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type checker struct {
+	name string
+	fn   func(context.Context) (string, error)
+}
+
+type healthResponse struct {
+	Status string  `json:"status"`
+	Checks []check `json:"checks,omitempty"`
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, healthResponse{Status: statusOK})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	var checkers = []checker{
+		{"kubectl", s.checkKubectl},
+		{"configurations", s.checkConfigurations},
+		{"queue", s.checkQueue},
+	}
+
+	for _, c := range s.settings().ReadinessContexts {
+		checkers = append(checkers, checker{"context:" + c, s.clusterChecker(Cluster{Context: c})})
+	}
+
+	if s.settings().CheckClusters {
+		for _, c := range s.clusters() {
+			checkers = append(checkers, checker{"cluster:" + c.Name, s.clusterChecker(c)})
+		}
+	}
+
+	var hr = healthResponse{
+		Status: statusOK,
+		Checks: make([]check, len(checkers)),
+	}
+
+	var wg sync.WaitGroup
+
+	for i, c := range checkers {
+		wg.Add(1)
+
+		go func(i int, c checker) {
+			defer wg.Done()
+			hr.Checks[i] = runCheck(ctx, c)
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	for _, c := range hr.Checks {
+		if c.Status != statusOK {
+			hr.Status = statusFail
+		}
+	}
+
+	writeHealth(w, r, hr)
+}
+
+func runCheck(ctx context.Context, ch checker) check {
+	var start = time.Now()
+	var msg, err = ch.fn(ctx)
+
+	var c = check{
+		Name:     ch.name,
+		Status:   statusOK,
+		Message:  msg,
+		Duration: time.Since(start).String(),
+	}
+
+	if err != nil {
+		c.Status = statusFail
+		c.Message = err.Error()
+		kubeapply.Logger(ctx).Debugf("readiness check %v failed: %v", ch.name, err)
+	}
+
+	return c
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, hr healthResponse) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if hr.Status != statusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(hr); err != nil {
+		logger(r).Errorf("error responding %v request: %v", r.URL.Path, err)
+	}
+}
+
+func (s *Server) checkKubectl(ctx context.Context) (string, error) {
+	return "", kubectlFunc(s.kubectlCommand).run(ctx, nil, "version", "--client", "--output=json")
+}
+
+func (s *Server) clusterChecker(c Cluster) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		return "", kubectlFunc(s.kubectlCommand).run(ctx, &c, "version", "--output=json", "--request-timeout=5s")
+	}
+}
+
+// kubectlFunc creates a kubectl command for the cluster profile, if any.
+// The returned function must be called once the command is done.
+type kubectlFunc func(ctx context.Context, c *Cluster, args ...string) (*exec.Cmd, func(), error)
+
+// kubectlCommand for the cluster profile, if any, with the same environment as the apply requests.
+func (s *Server) kubectlCommand(ctx context.Context, c *Cluster, args ...string) (*exec.Cmd, func(), error) {
+	var params = s.settings()
+	var a = &kubeapply.Apply{
+		EnvAllowlist: params.EnvAllowlist,
+	}
+
+	if c != nil {
+		a.Kubeconfig = c.Kubeconfig
+		a.Env = c.Env
+
+		if c.Context != "" {
+			args = append(args, "--context="+c.Context)
+		}
+
+		if params.HomesDir != "" && c.Name != "" {
+			a.Home = filepath.Join(params.HomesDir, c.Name)
+		}
+	}
+
+	env, cleanup, err := a.Environ(ctx)
+
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	var cmd = exec.CommandContext(ctx, kubeapply.Executable, args...) // #nosec
+	cmd.Env = env
+	return cmd, cleanup, nil
+}
+
+// run kubectl, returning an error with its standard error if it fails.
+func (k kubectlFunc) run(ctx context.Context, c *Cluster, args ...string) error {
+	_, err := k.output(ctx, c, args...)
+	return err
+}
+
+// output of kubectl, returning an error with its standard error if it fails.
+func (k kubectlFunc) output(ctx context.Context, c *Cluster, args ...string) ([]byte, error) {
+	cmd, cleanup, err := k(ctx, c, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cleanup()
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *Server) checkConfigurations(ctx context.Context) (string, error) {
+	var dir = kubeapply.ConfigurationsDir
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile(dir, ".readyz-")
+
+	if err != nil {
+		return "", fmt.Errorf("directory is not writable: %v", err)
+	}
+
+	var name = f.Name()
+	_, err = f.Write([]byte("ok"))
+
+	if ec := f.Close(); err == nil {
+		err = ec
+	}
+
+	if er := os.Remove(name); err == nil {
+		err = er
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("directory is not writable: %v", err)
+	}
+
+	return s.checkFreeSpace(dir)
+}
+
+func (s *Server) checkFreeSpace(dir string) (string, error) {
+	free, supported, err := freeSpace(dir)
+
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("cannot get free space: %v", err)
+	case !supported:
+		return "free space check not supported on this platform", nil
+	}
+
+	var min = s.settings().MinFreeSpace
+
+	if min == 0 {
+		min = DefaultMinFreeSpace
+	}
+
+	if free < min {
+		return "", fmt.Errorf("free space %d bytes is below threshold of %d bytes", free, min)
+	}
+
+	return fmt.Sprintf("%d bytes free", free), nil
+}
+
+func (s *Server) checkQueue(ctx context.Context) (string, error) {
+	if s.queue.saturated() {
+		return "", fmt.Errorf("saturated: %v", s.queue)
+	}
+
+	return s.queue.String(), nil
+}
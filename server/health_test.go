@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestKubectlCommandEnv(t *testing.T) {
+	t.Setenv("KUBEAPPLY_TEST_SECRET", "secret")
+	t.Setenv("KUBEAPPLY_TEST_ALLOWED", "allowed")
+
+	var s = &Server{
+		params: Params{
+			EnvAllowlist: []string{"PATH", "KUBEAPPLY_TEST_ALLOWED"},
+		},
+	}
+
+	var c = &Cluster{
+		Name:       "prod",
+		Kubeconfig: "/etc/kubeapply/prod.yaml",
+		Context:    "prod",
+		Env:        map[string]string{"AWS_PROFILE": "prod"},
+	}
+
+	cmd, cleanup, err := s.kubectlCommand(context.Background(), c, "version")
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	defer cleanup()
+
+	var env = strings.Join(cmd.Env, "\n") + "\n"
+
+	for _, want := range []string{"KUBEAPPLY_TEST_ALLOWED=allowed\n", "AWS_PROFILE=prod\n", "KUBECONFIG=/etc/kubeapply/prod.yaml\n"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("Expected environment to have %q, got %v instead", want, cmd.Env)
+		}
+	}
+
+	if strings.Contains(env, "KUBEAPPLY_TEST_SECRET") {
+		t.Errorf("Expected environment to not have variables outside of the allowlist, got %v instead", cmd.Env)
+	}
+
+	if got := cmd.Args[len(cmd.Args)-1]; got != "--context=prod" {
+		t.Errorf("Expected context flag, got %v instead", got)
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/henvic/kubeapply"
+)
+
+// IdempotencyKeyHeader is used by clients to safely retry requests.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on responses replayed from a previous request.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// DefaultIdempotencyTTL is the duration idempotency keys are kept for.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// DefaultIdempotencyMaxKeys is the maximum number of idempotency keys kept with their responses.
+const DefaultIdempotencyMaxKeys = 10000
+
+var idempotencyKeyRegex = regexp.MustCompile(`^[\x21-\x7e]{1,255}$`)
+
+var (
+	errIdempotencyKeyReused    = errors.New("idempotency key was already used with a different payload")
+	errIdempotencyKeyAbandoned = errors.New("in-flight request with the same idempotency key failed to complete")
+	errIdempotencyStoreFull    = errors.New("too many in-flight requests with idempotency keys")
+)
+
+type idempotencyEntry struct {
+	hash    string
+	expires time.Time
+
+	done      chan struct{}
+	resp      kubeapply.Response
+	abandoned bool
+}
+
+// idempotencyStore keeps the responses of requests with an idempotency key.
+type idempotencyStore struct {
+	ttl time.Duration
+
+	// max number of entries. The completed entries expiring first are evicted when it is reached.
+	max int
+
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+
+	m sync.Mutex
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	if ttl == 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+
+	return &idempotencyStore{
+		ttl:     ttl,
+		max:     DefaultIdempotencyMaxKeys,
+		entries: map[string]*idempotencyEntry{},
+	}
+}
+
+// setMax number of entries kept.
+func (is *idempotencyStore) setMax(max int) {
+	if max <= 0 {
+		max = DefaultIdempotencyMaxKeys
+	}
+
+	is.m.Lock()
+	is.max = max
+	is.m.Unlock()
+}
+
+// setTTL of the keys stored from now on.
+func (is *idempotencyStore) setTTL(ttl time.Duration) {
+	if ttl == 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+
+	is.m.Lock()
+	is.ttl = ttl
+	is.m.Unlock()
+}
+
+// begin returns the entry for the key, and whether the caller is responsible for running the request.
+func (is *idempotencyStore) begin(key, hash string) (e *idempotencyEntry, leader bool, err error) {
+	is.m.Lock()
+	defer is.m.Unlock()
+
+	var now = time.Now()
+	is.maybeSweep(now)
+
+	e, ok := is.entries[key]
+
+	if ok && now.Before(e.expires) {
+		if e.hash != hash {
+			return nil, false, errIdempotencyKeyReused
+		}
+
+		return e, false, nil
+	}
+
+	if !ok && !is.makeRoom() {
+		return nil, false, errIdempotencyStoreFull
+	}
+
+	e = &idempotencyEntry{
+		hash:    hash,
+		expires: now.Add(is.ttl),
+		done:    make(chan struct{}),
+	}
+
+	is.entries[key] = e
+	return e, true, nil
+}
+
+func (is *idempotencyStore) complete(e *idempotencyEntry, resp kubeapply.Response) {
+	e.resp = resp
+	close(e.done)
+}
+
+// abandon the entry so that the request can be retried.
+func (is *idempotencyStore) abandon(key string, e *idempotencyEntry) {
+	is.m.Lock()
+
+	if is.entries[key] == e {
+		delete(is.entries, key)
+	}
+
+	is.m.Unlock()
+
+	e.abandoned = true
+	close(e.done)
+}
+
+// makeRoom for a new entry, evicting the completed entries expiring first if needed.
+// It returns false if there is no room because all entries are in flight.
+func (is *idempotencyStore) makeRoom() bool {
+	for len(is.entries) >= is.max {
+		var oldest string
+
+		for k, e := range is.entries {
+			if e.completed() && (oldest == "" || e.expires.Before(is.entries[oldest].expires)) {
+				oldest = k
+			}
+		}
+
+		if oldest == "" {
+			return false
+		}
+
+		delete(is.entries, oldest)
+	}
+
+	return true
+}
+
+func (e *idempotencyEntry) completed() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (is *idempotencyStore) maybeSweep(now time.Time) {
+	if now.Sub(is.lastSweep) < time.Minute {
+		return
+	}
+
+	is.lastSweep = now
+
+	for k, e := range is.entries {
+		if now.After(e.expires) {
+			delete(is.entries, k)
+		}
+	}
+}
+
+// load the idempotency keys of recent recordings.
+func (is *idempotencyStore) load(dir string) (int, error) {
+	var cutoff = time.Now().Add(-is.ttl)
+	var loaded int
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case os.IsNotExist(err):
+			return filepath.SkipDir
+		case err != nil:
+			return err
+		case info.IsDir():
+			// skip directories of days before the cutoff
+			if d, ed := time.Parse("2006-01-02", info.Name()); ed == nil && d.Before(cutoff.Add(-24*time.Hour)) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		case info.Name() != "idempotency":
+			return nil
+		}
+
+		if is.loadRecording(filepath.Dir(path), cutoff) {
+			loaded++
+		}
+
+		return nil
+	})
+
+	return loaded, err
+}
+
+func (is *idempotencyStore) loadRecording(dir string, cutoff time.Time) bool {
+	var ir kubeapply.IdempotencyRecord
+	var resp kubeapply.Response
+
+	if err := readJSON(filepath.Join(dir, "idempotency"), &ir); err != nil || ir.Date.Before(cutoff) {
+		return false
+	}
+
+	// skip requests interrupted before responding
+	if err := readJSON(filepath.Join(dir, "response"), &resp); err != nil {
+		return false
+	}
+
+	var e = &idempotencyEntry{
+		hash:    ir.PayloadHash,
+		expires: ir.Date.Add(is.ttl),
+		done:    make(chan struct{}),
+		resp:    resp,
+	}
+
+	close(e.done)
+
+	is.m.Lock()
+	defer is.m.Unlock()
+
+	old, ok := is.entries[ir.Key]
+
+	if ok && old.expires.After(e.expires) {
+		return false
+	}
+
+	if !ok && !is.makeRoom() {
+		return false
+	}
+
+	is.entries[ir.Key] = e
+	return true
+}
+
+func readJSON(name string, v interface{}) error {
+	b, err := ioutil.ReadFile(name) // #nosec
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// payloadHash identifies the request payload.
+func payloadHash(path string, arb ApplyRequestBody) (string, error) {
+	b, err := json.Marshal(arb)
+
+	if err != nil {
+		return "", err
+	}
+
+	var h = sha256.New()
+	_, _ = h.Write([]byte(path))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(b)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
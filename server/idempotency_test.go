@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/henvic/kubeapply"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	var is = newIdempotencyStore(time.Hour)
+
+	e, leader, err := is.begin("key", "hash")
+
+	if err != nil || !leader {
+		t.Fatalf("Expected first request to lead, got (%v, %v) instead", leader, err)
+	}
+
+	follower, leader, err := is.begin("key", "hash")
+
+	if err != nil || leader || follower != e {
+		t.Fatalf("Expected second request to follow, got (%v, %v) instead", leader, err)
+	}
+
+	if _, _, err = is.begin("key", "other"); err != errIdempotencyKeyReused {
+		t.Errorf("Expected error %v, got %v instead", errIdempotencyKeyReused, err)
+	}
+
+	is.complete(e, kubeapply.Response{ID: "abc"})
+
+	select {
+	case <-follower.done:
+	default:
+		t.Fatalf("Expected entry to be done")
+	}
+
+	if follower.resp.ID != "abc" {
+		t.Errorf("Expected stored response to be replayed, got %+v instead", follower.resp)
+	}
+}
+
+func TestIdempotencyStoreAbandon(t *testing.T) {
+	var is = newIdempotencyStore(time.Hour)
+
+	e, _, _ := is.begin("key", "hash")
+	is.abandon("key", e)
+
+	if !e.abandoned {
+		t.Errorf("Expected entry to be abandoned")
+	}
+
+	if _, leader, _ := is.begin("key", "hash"); !leader {
+		t.Errorf("Expected abandoned key to be usable again")
+	}
+}
+
+func TestIdempotencyStoreMax(t *testing.T) {
+	var is = newIdempotencyStore(time.Hour)
+	is.setMax(2)
+
+	first, _, _ := is.begin("first", "hash")
+	second, _, _ := is.begin("second", "hash")
+
+	if _, _, err := is.begin("third", "hash"); err != errIdempotencyStoreFull {
+		t.Errorf("Expected error %v when all keys are in flight, got %v instead", errIdempotencyStoreFull, err)
+	}
+
+	first.expires = first.expires.Add(-time.Minute)
+	is.complete(second, kubeapply.Response{})
+	is.complete(first, kubeapply.Response{})
+
+	if _, leader, err := is.begin("third", "hash"); !leader || err != nil {
+		t.Fatalf("Expected new key to evict a completed one, got (%v, %v) instead", leader, err)
+	}
+
+	if _, ok := is.entries["first"]; ok {
+		t.Errorf("Expected key expiring first to be evicted")
+	}
+
+	if len(is.entries) != 2 {
+		t.Errorf("Expected 2 keys, got %d instead", len(is.entries))
+	}
+}
+
+func TestIdempotencyStoreLoad(t *testing.T) {
+	var dir = t.TempDir()
+
+	writeRecording(t, filepath.Join(dir, time.Now().Format("2006-01-02"), "1-recent"),
+		kubeapply.IdempotencyRecord{Key: "recent", PayloadHash: "h1", Date: time.Now()}, true)
+	writeRecording(t, filepath.Join(dir, time.Now().Format("2006-01-02"), "2-interrupted"),
+		kubeapply.IdempotencyRecord{Key: "interrupted", PayloadHash: "h2", Date: time.Now()}, false)
+	writeRecording(t, filepath.Join(dir, "2001-01-01", "3-expired"),
+		kubeapply.IdempotencyRecord{Key: "expired", PayloadHash: "h3", Date: time.Now().Add(-48 * time.Hour)}, true)
+
+	var is = newIdempotencyStore(time.Hour)
+	n, err := is.load(dir)
+
+	if n != 1 || err != nil {
+		t.Fatalf("Expected to load 1 key, got (%v, %v) instead", n, err)
+	}
+
+	if e, leader, _ := is.begin("recent", "h1"); leader || e.resp.ID != "recent-id" {
+		t.Errorf("Expected loaded key to replay the recorded response")
+	}
+}
+
+func writeRecording(t *testing.T, dir string, ir kubeapply.IdempotencyRecord, withResponse bool) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var b, _ = json.Marshal(ir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "idempotency"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !withResponse {
+		return
+	}
+
+	b, _ = json.Marshal(kubeapply.Response{ID: ir.Key + "-id", Stdout: kubeapply.Output(`{"kind":"List"}`)})
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "response"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdempotencyStoreLoadMissingDir(t *testing.T) {
+	var is = newIdempotencyStore(time.Hour)
+
+	if n, err := is.load(filepath.Join(t.TempDir(), "not-found")); n != 0 || err != nil {
+		t.Errorf("Expected no keys and no error, got (%v, %v) instead", n, err)
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henvic/kubeapply"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultJobTTL is the duration finished jobs are kept for.
+const DefaultJobTTL = time.Hour
+
+// Job status.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is an apply request running in the background.
+// Requests with the "Prefer: respond-async" header are run as jobs.
+type Job struct {
+	ID        string `json:"id"`
+	RequestID string `json:"request_id,omitempty"`
+	Status    string `json:"status"`
+
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// Response of a done job.
+	Response *kubeapply.Response `json:"response,omitempty"`
+
+	// Error of a failed job, when kubectl couldn't run.
+	Error string `json:"error,omitempty"`
+}
+
+// wantsAsync checks if the client prefers the request to run in the background.
+func wantsAsync(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, p := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(p), "respond-async") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// jobStore keeps the jobs until they expire.
+type jobStore struct {
+	ttl time.Duration
+
+	jobs      map[string]*Job
+	lastSweep time.Time
+
+	m sync.RWMutex
+}
+
+func newJobStore(ttl time.Duration) *jobStore {
+	if ttl == 0 {
+		ttl = DefaultJobTTL
+	}
+
+	return &jobStore{
+		ttl:  ttl,
+		jobs: map[string]*Job{},
+	}
+}
+
+func (js *jobStore) setTTL(ttl time.Duration) {
+	if ttl == 0 {
+		ttl = DefaultJobTTL
+	}
+
+	js.m.Lock()
+	js.ttl = ttl
+	js.m.Unlock()
+}
+
+func (js *jobStore) add(requestID string) *Job {
+	js.m.Lock()
+	defer js.m.Unlock()
+
+	var now = time.Now()
+	js.maybeSweep(now)
+
+	var j = &Job{
+		ID:        uuid.NewV4().String(),
+		RequestID: requestID,
+		Status:    JobQueued,
+		Created:   now,
+	}
+
+	js.jobs[j.ID] = j
+	return j
+}
+
+// get a copy of the job.
+func (js *jobStore) get(id string) (Job, bool) {
+	js.m.RLock()
+	defer js.m.RUnlock()
+
+	j, ok := js.jobs[id]
+
+	if !ok {
+		return Job{}, false
+	}
+
+	return *j, true
+}
+
+func (js *jobStore) update(j *Job, f func(j *Job)) {
+	js.m.Lock()
+	defer js.m.Unlock()
+	f(j)
+}
+
+func (js *jobStore) finish(j *Job, resp kubeapply.Response, err error) {
+	js.update(j, func(j *Job) {
+		var now = time.Now()
+		j.Finished = &now
+
+		if err != nil {
+			j.Status = JobFailed
+			j.Error = err.Error()
+			return
+		}
+
+		j.Status = JobDone
+		j.Response = &resp
+	})
+}
+
+// maybeSweep finished jobs that expired.
+func (js *jobStore) maybeSweep(now time.Time) {
+	if now.Sub(js.lastSweep) < time.Minute {
+		return
+	}
+
+	js.lastSweep = now
+
+	for k, j := range js.jobs {
+		if j.Finished != nil && now.Sub(*j.Finished) > js.ttl {
+			delete(js.jobs, k)
+		}
+	}
+}
+
+// submitJob runs the request in the background, responding with 202 Accepted and the location of the job.
+func (s *Server) submitJob(w http.ResponseWriter, r *http.Request, a *kubeapply.Apply, arb ApplyRequestBody) {
+	var key = r.Header.Get(IdempotencyKeyHeader)
+
+	if key != "" && !idempotencyKeyRegex.MatchString(key) {
+		ErrorHandler(w, r, http.StatusBadRequest, "invalid "+IdempotencyKeyHeader+" header")
+		return
+	}
+
+	var (
+		e      *idempotencyEntry
+		leader = true
+	)
+
+	if key != "" {
+		var hash, err = payloadHash(r.URL.Path, arb)
+
+		if err != nil {
+			ErrorHandler(w, r, http.StatusInternalServerError, "cannot hash request payload")
+			logger(r).Errorf("cannot hash request payload: %v", err)
+			return
+		}
+
+		if e, leader, err = s.idempotency.begin(key, hash); err != nil {
+			ErrorHandler(w, r, idempotencyErrorStatus(err), err.Error())
+			return
+		}
+
+		a.IdempotencyKey = key
+		a.PayloadHash = hash
+	}
+
+	var j = s.jobs.add(RequestID(r.Context()))
+	var queued = *j
+	var jr = r.WithContext(detachedContext{s.ctx, r.Context()})
+
+	go s.runJob(jr, j, a, key, e, leader)
+
+	w.Header().Set("Location", "/jobs/"+queued.ID)
+	w.Header().Set("Preference-Applied", "respond-async")
+	writeJob(w, r, http.StatusAccepted, queued)
+}
+
+// runJob of a request, or waits for the in-flight request with the same idempotency key if not the leader.
+func (s *Server) runJob(r *http.Request, j *Job, a *kubeapply.Apply, key string, e *idempotencyEntry, leader bool) {
+	if !leader {
+		s.jobs.update(j, func(j *Job) {
+			j.Status = JobRunning
+		})
+
+		select {
+		case <-e.done:
+		case <-r.Context().Done():
+			s.jobs.finish(j, kubeapply.Response{}, r.Context().Err())
+			return
+		}
+
+		if e.abandoned {
+			s.jobs.finish(j, kubeapply.Response{}, errIdempotencyKeyAbandoned)
+			return
+		}
+
+		s.jobs.finish(j, e.resp, nil)
+		return
+	}
+
+	var resp, err = s.execute(r, a, func() {
+		s.jobs.update(j, func(j *Job) {
+			j.Status = JobRunning
+		})
+	})
+
+	switch {
+	case e != nil && err != nil:
+		s.idempotency.abandon(key, e)
+	case e != nil:
+		s.idempotency.complete(e, resp)
+	}
+
+	s.jobs.finish(j, resp, err)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var j, ok = s.jobs.get(strings.TrimPrefix(r.URL.Path, "/jobs/"))
+
+	if !ok {
+		ErrorHandler(w, r, http.StatusNotFound, "job not found")
+		return
+	}
+
+	writeJob(w, r, http.StatusOK, j)
+}
+
+func writeJob(w http.ResponseWriter, r *http.Request, code int, j Job) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(j); err != nil {
+		logger(r).Errorf("cannot encode job %v: %v", j.ID, err)
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultKubeconfigsDir is where the kubeconfigs registered through the admin API are stored.
+const DefaultKubeconfigsDir = "kubeconfigs"
+
+const (
+	kubeconfigsDirMode  = os.FileMode(0700)
+	kubeconfigFileMode  = os.FileMode(0600)
+	maxKubeconfigLength = 1 << 20
+)
+
+// kubeconfigs stored on a protected directory.
+type kubeconfigs struct {
+	dir     string
+	kubectl kubectlFunc
+}
+
+func (k *kubeconfigs) path(name string) string {
+	return filepath.Join(k.dir, name)
+}
+
+func (k *kubeconfigs) exists(name string) bool {
+	if !clusterProfileRegex.MatchString(name) {
+		return false
+	}
+
+	fi, err := os.Stat(k.path(name))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func (k *kubeconfigs) names() []string {
+	var list = []string{}
+	fis, err := ioutil.ReadDir(k.dir)
+
+	if err != nil {
+		return list
+	}
+
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && clusterProfileRegex.MatchString(fi.Name()) {
+			list = append(list, fi.Name())
+		}
+	}
+
+	sort.Strings(list)
+	return list
+}
+
+func (k *kubeconfigs) cluster(name string) Cluster {
+	return Cluster{
+		Name:       name,
+		Kubeconfig: k.path(name),
+	}
+}
+
+// kubeconfigInfo doesn't expose any credentials.
+type kubeconfigInfo struct {
+	Name           string    `json:"name"`
+	Contexts       []string  `json:"contexts"`
+	CurrentContext string    `json:"current_context,omitempty"`
+	Fingerprint    string    `json:"fingerprint"`
+	Modified       time.Time `json:"modified"`
+}
+
+func (k *kubeconfigs) info(r *http.Request, name string) (kubeconfigInfo, error) {
+	var file = k.path(name)
+	b, err := ioutil.ReadFile(file) // #nosec
+
+	if err != nil {
+		return kubeconfigInfo{}, err
+	}
+
+	fi, err := os.Stat(file)
+
+	if err != nil {
+		return kubeconfigInfo{}, err
+	}
+
+	var sum = sha256.Sum256(b)
+	var c = k.cluster(name)
+
+	var ki = kubeconfigInfo{
+		Name:        name,
+		Contexts:    []string{},
+		Fingerprint: "sha256:" + hex.EncodeToString(sum[:]),
+		Modified:    fi.ModTime(),
+	}
+
+	if out, err := k.kubectl.output(r.Context(), &c, "config", "get-contexts", "--output=name"); err == nil {
+		ki.Contexts = strings.Fields(string(out))
+	}
+
+	if out, err := k.kubectl.output(r.Context(), &c, "config", "current-context"); err == nil {
+		ki.CurrentContext = strings.TrimSpace(string(out))
+	}
+
+	return ki, nil
+}
+
+// put validates and stores a kubeconfig, replacing the existing one atomically.
+func (k *kubeconfigs) put(r *http.Request, name string, b []byte) error {
+	if err := os.MkdirAll(k.dir, kubeconfigsDirMode); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(k.dir, ".tmp-"+name+"-")
+
+	if err != nil {
+		return err
+	}
+
+	var tmp = f.Name()
+	defer os.Remove(tmp)
+
+	if err = f.Chmod(kubeconfigFileMode); err == nil {
+		_, err = f.Write(b)
+	}
+
+	if ec := f.Close(); err == nil {
+		err = ec
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := k.kubectl.run(r.Context(), &Cluster{Kubeconfig: tmp},
+		"version", "--output=json", "--request-timeout=10s"); err != nil {
+		return &kubeconfigValidationError{err}
+	}
+
+	return os.Rename(tmp, k.path(name))
+}
+
+type kubeconfigValidationError struct {
+	err error
+}
+
+func (k *kubeconfigValidationError) Error() string {
+	return fmt.Sprintf("cannot connect to the cluster using the kubeconfig: %v", k.err)
+}
+
+// handleAdminKubeconfigs routes /admin/kubeconfigs and /admin/kubeconfigs/{name}.
+func (s *Server) handleAdminKubeconfigs(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r) {
+		return
+	}
+
+	var name = strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/kubeconfigs"), "/")
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		s.listKubeconfigs(w, r)
+	case name == "":
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+	case !clusterProfileRegex.MatchString(name):
+		ErrorHandler(w, r, http.StatusBadRequest, "invalid kubeconfig name")
+	case r.Method == http.MethodGet:
+		s.getKubeconfig(w, r, name)
+	case r.Method == http.MethodPut:
+		s.putKubeconfig(w, r, name)
+	case r.Method == http.MethodDelete:
+		s.deleteKubeconfig(w, r, name)
+	default:
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// checkAdmin requires the admin bearer token.
+func (s *Server) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	var adminToken = s.settings().AdminToken
+
+	if adminToken == "" {
+		ErrorHandler(w, r, http.StatusNotFound, "admin API is disabled")
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kubeapply admin"`)
+		ErrorHandler(w, r, http.StatusUnauthorized)
+		logger(r).Warnf("unauthorized admin request from IP %v", r.RemoteAddr)
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) listKubeconfigs(w http.ResponseWriter, r *http.Request) {
+	var list = []kubeconfigInfo{}
+
+	for _, name := range s.kubeconfigs.names() {
+		ki, err := s.kubeconfigs.info(r, name)
+
+		if err != nil {
+			logger(r).Errorf("cannot read kubeconfig %v: %v", name, err)
+			continue
+		}
+
+		list = append(list, ki)
+	}
+
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+func (s *Server) getKubeconfig(w http.ResponseWriter, r *http.Request, name string) {
+	if !s.kubeconfigs.exists(name) {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	s.writeKubeconfig(w, r, name, http.StatusOK)
+}
+
+func (s *Server) putKubeconfig(w http.ResponseWriter, r *http.Request, name string) {
+	if _, ok := s.findProfile(name); ok {
+		ErrorHandler(w, r, http.StatusConflict, "a cluster profile with this name is already configured")
+		return
+	}
+
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxKubeconfigLength))
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusRequestEntityTooLarge, "kubeconfig is too large")
+		return
+	}
+
+	var existed = s.kubeconfigs.exists(name)
+
+	if err = s.kubeconfigs.put(r, name, b); err != nil {
+		if _, ok := err.(*kubeconfigValidationError); ok {
+			ErrorHandler(w, r, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		ErrorHandler(w, r, http.StatusInternalServerError, "cannot store kubeconfig")
+		logger(r).Errorf("cannot store kubeconfig %v: %v", name, err)
+		return
+	}
+
+	var status = http.StatusCreated
+
+	if existed {
+		status = http.StatusOK
+	}
+
+	logger(r).Infof("kubeconfig %v stored by IP %v", name, r.RemoteAddr)
+	s.versions.forget(name)
+	s.writeKubeconfig(w, r, name, status)
+}
+
+func (s *Server) writeKubeconfig(w http.ResponseWriter, r *http.Request, name string, status int) {
+	ki, err := s.kubeconfigs.info(r, name)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusInternalServerError, "cannot read kubeconfig")
+		logger(r).Errorf("cannot read kubeconfig %v: %v", name, err)
+		return
+	}
+
+	writeJSON(w, r, status, ki)
+}
+
+func (s *Server) deleteKubeconfig(w http.ResponseWriter, r *http.Request, name string) {
+	if !s.kubeconfigs.exists(name) {
+		ErrorHandler(w, r, http.StatusNotFound)
+		return
+	}
+
+	if err := os.Remove(s.kubeconfigs.path(name)); err != nil {
+		ErrorHandler(w, r, http.StatusInternalServerError, "cannot delete kubeconfig")
+		logger(r).Errorf("cannot delete kubeconfig %v: %v", name, err)
+		return
+	}
+
+	logger(r).Infof("kubeconfig %v deleted by IP %v", name, r.RemoteAddr)
+	s.versions.forget(name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger(r).Errorf("error responding %v request: %v", r.URL.Path, err)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/henvic/kubeapply"
+)
+
+// DefaultMaxBodySize of requests, in bytes.
+const DefaultMaxBodySize = 48 << 20
+
+// limitBody of the request to the maximum body size, refusing it if its length is already known to be too large.
+func (s *Server) limitBody(w http.ResponseWriter, r *http.Request) bool {
+	var max = s.settings().MaxBodySize
+
+	if max == 0 {
+		return true
+	}
+
+	if r.ContentLength > max {
+		ErrorHandler(w, r, http.StatusRequestEntityTooLarge, s.bodyLimitError(r.ContentLength).Error())
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+	return true
+}
+
+func (s *Server) bodyLimitError(length int64) *kubeapply.LimitError {
+	return &kubeapply.LimitError{
+		Limit: "max_body_size",
+		Max:   s.settings().MaxBodySize,
+		Value: length,
+	}
+}
+
+// isBodyTooLarge checks if the error was caused by reading more than allowed by http.MaxBytesReader.
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// writeUploadError with 413 for limits on sizes, and 422 for other limits and unsafe files.
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var code = http.StatusUnprocessableEntity
+
+	if le, ok := err.(*kubeapply.LimitError); ok {
+		switch le.Limit {
+		case "max_body_size", "max_file_size", "max_total_size":
+			code = http.StatusRequestEntityTooLarge
+		}
+	}
+
+	ErrorHandler(w, r, code, err.Error())
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/henvic/kubeapply"
+)
+
+var applyLimitsCases = []struct {
+	name string
+	body string
+	want int
+}{
+	{
+		name: "body too large",
+		body: `{"files": {"a.yaml": "` + strings.Repeat("a", 200) + `"}}`,
+		want: http.StatusRequestEntityTooLarge,
+	},
+	{
+		name: "file too large",
+		body: `{"files": {"a.yaml": "` + strings.Repeat("a", 20) + `"}}`,
+		want: http.StatusRequestEntityTooLarge,
+	},
+	{
+		name: "too many files",
+		body: `{"files": {"a.yaml": "", "b.yaml": "", "c.yaml": ""}}`,
+		want: http.StatusUnprocessableEntity,
+	},
+	{
+		name: "path too deep",
+		body: `{"files": {"a/b/c.yaml": ""}}`,
+		want: http.StatusUnprocessableEntity,
+	},
+}
+
+func TestApplyLimits(t *testing.T) {
+	var s = &Server{
+		params: Params{
+			MaxBodySize: 100,
+			Limits: kubeapply.Limits{
+				MaxFiles:     2,
+				MaxFileSize:  10,
+				MaxPathDepth: 2,
+			},
+		},
+		kubeconfigs: &kubeconfigs{dir: t.TempDir()},
+	}
+
+	for _, tt := range applyLimitsCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+
+			// unknown length, so that http.MaxBytesReader is exercised
+			r.ContentLength = -1
+
+			var w = httptest.NewRecorder()
+			s.handleApply(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status code %v, got %v instead (%s)", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
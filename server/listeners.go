@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// Listener of the server.
+type Listener struct {
+	// Address to listen on, such as "127.0.0.1:9000" or ":9443".
+	Address string `json:"address"`
+
+	// TLS of the listener. The listener serves plain HTTP if nil.
+	TLS *TLS `json:"tls,omitempty"`
+}
+
+// TLS certificate of a listener.
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// ClientCAFile with the certificates of the CAs used to verify client certificates.
+	// Client certificates are required if set.
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+// Config for serving with the certificate.
+func (t TLS) Config() (*tls.Config, error) {
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, fmt.Errorf("cert_file and key_file are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot load certificate: %v", err)
+	}
+
+	var c = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if t.ClientCAFile == "" {
+		return c, nil
+	}
+
+	b, err := ioutil.ReadFile(t.ClientCAFile) // #nosec
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read client CA file: %v", err)
+	}
+
+	var pool = x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found on client CA file %s", t.ClientCAFile)
+	}
+
+	c.ClientCAs = pool
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+	return c, nil
+}
+
+// listeners of the server.
+func (p Params) listeners() []Listener {
+	if len(p.Listeners) != 0 {
+		return p.Listeners
+	}
+
+	return []Listener{{Address: p.Address}}
+}
+
+// loadTLSConfigs of the listeners, with nil for the ones without TLS.
+func loadTLSConfigs(listeners []Listener) ([]*tls.Config, error) {
+	var configs = make([]*tls.Config, len(listeners))
+
+	for i, l := range listeners {
+		if l.TLS == nil {
+			continue
+		}
+
+		c, err := l.TLS.Config()
+
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %v", l.Address, err)
+		}
+
+		configs[i] = c
+	}
+
+	return configs, nil
+}
+
+// tlsConfig of the i-th listener, loaded with the latest settings.
+func (s *Server) tlsConfig(i int) *tls.Config {
+	s.pm.RLock()
+	defer s.pm.RUnlock()
+	return s.tlsConfigs[i]
+}
+
+// sameListeners checks if the listeners have the same addresses, and use TLS in the same way.
+func sameListeners(a, b []Listener) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Address != b[i].Address || (a[i].TLS == nil) != (b[i].TLS == nil) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/henvic/kubeapply/metrics"
+)
+
+// Metrics of the HTTP server.
+// They are registered on metrics.DefaultRegistry.
+var (
+	RequestsTotal = metrics.NewCounterVec("kubeapply_http_requests_total",
+		"Number of HTTP requests by endpoint and status code.",
+		"endpoint", "code")
+
+	UploadedBytes = metrics.NewCounterVec("kubeapply_uploaded_bytes_total",
+		"Number of bytes of files uploaded to be applied.")
+
+	VersionDuration = metrics.NewHistogramVec("kubeapply_version_duration_seconds",
+		"Latency of the /version endpoint in seconds.",
+		nil)
+)
+
+func init() {
+	metrics.DefaultRegistry.MustRegister(
+		RequestsTotal,
+		UploadedBytes,
+		VersionDuration,
+	)
+}
+
+// statusRecorder records the status code written to a http.ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush the underlying http.ResponseWriter, if it supports it, so that streamed responses aren't buffered.
+func (s *statusRecorder) Flush() {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument counts the requests to a given endpoint.
+func instrument(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sr = &statusRecorder{ResponseWriter: w}
+
+		h(sr, r)
+
+		if sr.code == 0 {
+			sr.code = http.StatusOK
+		}
+
+		RequestsTotal.With(endpoint, strconv.Itoa(sr.code)).Inc()
+	}
+}
+
+func observeSince(h *metrics.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
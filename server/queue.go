@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// queue limits the number of concurrent kubectl executions.
+// A queue without slots doesn't limit them.
+type queue struct {
+	slots   chan struct{}
+	running int64
+	waiting int64
+}
+
+func newQueue(size int) *queue {
+	var q = &queue{}
+
+	if size > 0 {
+		q.slots = make(chan struct{}, size)
+	}
+
+	return q
+}
+
+// acquire a slot, waiting for one to be available if the queue is full.
+func (q *queue) acquire(ctx context.Context) error {
+	if q.slots == nil {
+		atomic.AddInt64(&q.running, 1)
+		return nil
+	}
+
+	atomic.AddInt64(&q.waiting, 1)
+	defer atomic.AddInt64(&q.waiting, -1)
+
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *queue) release() {
+	if q.slots == nil {
+		atomic.AddInt64(&q.running, -1)
+		return
+	}
+
+	<-q.slots
+}
+
+// saturated checks if all slots are in use.
+func (q *queue) saturated() bool {
+	return q.slots != nil && len(q.slots) == cap(q.slots)
+}
+
+func (q *queue) stats() (running, capacity int, waiting int64) {
+	if q.slots == nil {
+		return int(atomic.LoadInt64(&q.running)), 0, 0
+	}
+
+	return len(q.slots), cap(q.slots), atomic.LoadInt64(&q.waiting)
+}
+
+// String describes the usage of the queue.
+func (q *queue) String() string {
+	running, capacity, waiting := q.stats()
+
+	if q.slots == nil {
+		return fmt.Sprintf("%d running, no limit", running)
+	}
+
+	return fmt.Sprintf("%d of %d slots in use, %d waiting", running, capacity, waiting)
+}
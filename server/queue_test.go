@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	var q = newQueue(1)
+
+	if err := q.acquire(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v instead", err)
+	}
+
+	if !q.saturated() {
+		t.Errorf("Expected queue to be saturated")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := q.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context deadline exceeded error, got %v instead", err)
+	}
+
+	q.release()
+
+	if q.saturated() {
+		t.Errorf("Expected queue not to be saturated")
+	}
+}
+
+func TestQueueUnlimited(t *testing.T) {
+	var q = newQueue(0)
+
+	for i := 0; i < 100; i++ {
+		if err := q.acquire(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v instead", err)
+		}
+	}
+
+	if q.saturated() {
+		t.Errorf("Expected queue without limit not to be saturated")
+	}
+
+	if got, want := q.String(), "100 running, no limit"; got != want {
+		t.Errorf("Expected queue to be %q, got %q instead", want, got)
+	}
+
+	q.release()
+
+	if running, _, _ := q.stats(); running != 99 {
+		t.Errorf("Expected 99 running, got %d instead", running)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/henvic/kubeapply"
+)
+
+var recordingIDRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// recordingDirRegex matches the name of the directories of recordings: <unix timestamp>-<id>
+var recordingDirRegex = regexp.MustCompile(`^[0-9]+-[0-9a-f-]{36}$`)
+
+var errRecordingFound = errors.New("recording found")
+
+// findRecording by the id of the request, returning its directory.
+func findRecording(dir, id string) (string, bool) {
+	var found string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case !info.IsDir() || !recordingDirRegex.MatchString(info.Name()):
+			return nil
+		case strings.HasSuffix(info.Name(), "-"+id):
+			found = path
+			return errRecordingFound
+		default:
+			// don't walk the uploaded files
+			return filepath.SkipDir
+		}
+	})
+
+	return found, err == errRecordingFound
+}
+
+// handleRecordings responds with the response recorded for a request.
+func (s *Server) handleRecordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var id = strings.TrimPrefix(r.URL.Path, "/recordings/")
+
+	if !recordingIDRegex.MatchString(id) {
+		ErrorHandler(w, r, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	dir, ok := findRecording(kubeapply.ConfigurationsDir, id)
+
+	if !ok {
+		ErrorHandler(w, r, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "response")) // #nosec
+
+	switch {
+	case os.IsNotExist(err):
+		ErrorHandler(w, r, http.StatusNotFound, "recording has no response: the request is in progress or was interrupted")
+		return
+	case err != nil:
+		ErrorHandler(w, r, http.StatusInternalServerError, "cannot read recording")
+		logger(r).Errorf("cannot read recording %v: %v", id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/henvic/kubeapply/tracing"
+)
+
+// handleRender renders the uploaded kustomization with kubectl kustomize, without applying it.
+func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "handleRender")
+	defer span.End()
+
+	span.SetAttribute("request_id", RequestID(ctx))
+	r = r.WithContext(ctx)
+
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed,
+			"kubectl reference: https://kubernetes.io/docs/reference/generated/kubectl/kubectl-commands#kustomize")
+		return
+	}
+
+	arb, dump, ok := s.decodeApplyRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	if arb.Command != "" && arb.Command != "kustomize" {
+		ErrorHandler(w, r, http.StatusBadRequest, `command must be "kustomize" or empty`)
+		return
+	}
+
+	arb.Command = "kustomize"
+
+	var c, err = s.resolveCluster(r, arb)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var a = s.newApply(r, arb, dump, c)
+
+	if err := a.CheckUploads(); err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	root, err := a.KustomizationRoot()
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if root == "" {
+		ErrorHandler(w, r, http.StatusUnprocessableEntity,
+			"no kustomization.yaml, kustomization.yml, or Kustomization file found")
+		return
+	}
+
+	resp, err := s.execute(r, a, nil)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "application/yaml") || resp.ExitCode != 0 {
+		writeApplyResponse(w, r, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml; charset=utf8")
+
+	if _, err := w.Write([]byte(resp.Stdout)); err != nil {
+		logger(r).Errorf("cannot write response for request %s: %v", resp.ID, err)
+	}
+}
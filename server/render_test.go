@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var renderCases = []struct {
+	name   string
+	method string
+	body   string
+	want   int
+}{
+	{
+		name:   "method not allowed",
+		method: http.MethodGet,
+		want:   http.StatusMethodNotAllowed,
+	},
+	{
+		name:   "other command",
+		method: http.MethodPost,
+		body:   `{"command": "apply", "files": {"kustomization.yaml": ""}}`,
+		want:   http.StatusBadRequest,
+	},
+	{
+		name:   "no kustomization",
+		method: http.MethodPost,
+		body:   `{"files": {"app.yaml": "apiVersion: v1"}}`,
+		want:   http.StatusUnprocessableEntity,
+	},
+	{
+		name:   "multiple kustomization roots",
+		method: http.MethodPost,
+		body:   `{"files": {"a/kustomization.yaml": "", "b/kustomization.yaml": ""}}`,
+		want:   http.StatusUnprocessableEntity,
+	},
+	{
+		name:   "unsafe path",
+		method: http.MethodPost,
+		body:   `{"files": {"../kustomization.yaml": ""}}`,
+		want:   http.StatusUnprocessableEntity,
+	},
+}
+
+func TestHandleRender(t *testing.T) {
+	var s = &Server{
+		kubeconfigs: &kubeconfigs{dir: t.TempDir()},
+	}
+
+	for _, tt := range renderCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(tt.method, "/render", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+
+			var w = httptest.NewRecorder()
+			s.handleRender(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status code %v, got %v instead (%s)", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/henvic/kubeapply"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader is used to correlate requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._:-]{0,127}$`)
+
+type requestIDKey struct{}
+
+// RequestID of the request, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID accepts a valid X-Request-ID header or generates a new request ID,
+// echoes it on the response, and adds a request-scoped logger to the request context.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id = r.Header.Get(RequestIDHeader)
+		var invalid = id != "" && !requestIDRegex.MatchString(id)
+
+		if id == "" || invalid {
+			id = uuid.NewV4().String()
+		}
+
+		var l = log.WithField("request_id", id)
+
+		if invalid {
+			l.Warnf("ignoring invalid %v header from IP %v", RequestIDHeader, r.RemoteAddr)
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		var ctx = context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = kubeapply.ContextWithLogger(ctx, l)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// logger for the request.
+func logger(r *http.Request) *log.Entry {
+	return kubeapply.Logger(r.Context())
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var requestIDCases = []struct {
+	name      string
+	header    string
+	preserved bool
+}{
+	{"empty", "", false},
+	{"uuid", "f0c8a5a4-4c1b-4c5c-9b59-54b2f1d1e1a0", true},
+	{"custom", "ci-job.1234:5", true},
+	{"spaces", "ci job", false},
+	{"newline", "abc\nX-Foo: bar", false},
+	{"leading dash", "-abc", false},
+}
+
+func TestWithRequestID(t *testing.T) {
+	for _, tt := range requestIDCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			var h = withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = RequestID(r.Context())
+				ErrorHandler(w, r, http.StatusTeapot)
+			}))
+
+			var req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, tt.header)
+
+			var rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got == "" || rec.Header().Get(RequestIDHeader) != got {
+				t.Errorf("Expected request ID %q to be echoed, got %q instead", got, rec.Header().Get(RequestIDHeader))
+			}
+
+			if (got == tt.header) != tt.preserved {
+				t.Errorf("Expected header %q preserved = %v, got request ID %q", tt.header, tt.preserved, got)
+			}
+		})
+	}
+}
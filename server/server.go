@@ -2,13 +2,17 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/errwrap"
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/metrics"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,7 +20,84 @@ import (
 type Params struct {
 	Address string
 
+	// Listeners of the server. The server only listens on Address if empty.
+	Listeners []Listener
+
 	ExposeDebug bool
+
+	// MaxConcurrency of kubectl executions. Requests wait in a queue when all slots are in use.
+	// There is no limit if zero.
+	MaxConcurrency int
+
+	// MinFreeSpace on the configurations directory for the service to be ready, in bytes.
+	MinFreeSpace uint64
+
+	// ReadinessContexts are the kubeconfig contexts checked for reachability by /readyz.
+	ReadinessContexts []string
+
+	// VersionCacheTTL is the duration the kubectl version responses are cached for.
+	VersionCacheTTL time.Duration
+
+	// IdempotencyTTL is the duration idempotency keys are kept for.
+	IdempotencyTTL time.Duration
+
+	// IdempotencyMaxKeys is the maximum number of idempotency keys kept with their responses.
+	// DefaultIdempotencyMaxKeys is used if zero.
+	IdempotencyMaxKeys int
+
+	// JobTTL is the duration finished jobs are kept for.
+	JobTTL time.Duration
+
+	// Clusters profiles. If set, requests are routed to one of them.
+	Clusters []Cluster
+
+	// DefaultCluster for requests without a cluster. Optional if there is only one cluster.
+	DefaultCluster string
+
+	// CheckClusters reachability on /readyz.
+	CheckClusters bool
+
+	// KubeconfigsDir is where the kubeconfigs registered through the admin API are stored.
+	KubeconfigsDir string
+
+	// AdminToken for the admin API. The admin API is disabled if empty.
+	AdminToken string
+
+	// APITokens accepted by the API. Requests don't require a token if empty.
+	APITokens []APIToken
+
+	// AllowedSubcommands of kubectl on any cluster. All subcommands are allowed if empty.
+	AllowedSubcommands []string
+
+	// EnvAllowlist of environment variables passed to kubectl. kubeapply.DefaultEnvAllowlist is used if nil.
+	EnvAllowlist []string
+
+	// MaxBodySize of requests, in bytes. There is no limit if zero.
+	MaxBodySize int64
+
+	// Limits on the uploaded files.
+	Limits kubeapply.Limits
+
+	// OpenAPISpec is the OpenAPI v2 specification file (swagger.json) used to validate objects.
+	// Clusters can use their own.
+	OpenAPISpec string
+
+	// CRDsDir is a directory with CustomResourceDefinition objects whose schemas are used to validate objects.
+	CRDsDir string
+
+	// ValidateBeforeApply validates the objects against the OpenAPI schemas before running kubectl.
+	ValidateBeforeApply bool
+
+	// Sandbox of the kubectl processes. kubectl runs without a sandbox if nil.
+	// The program must call kubeapply.SandboxInit at the start of main to use it.
+	Sandbox *kubeapply.Sandbox
+
+	// HomesDir is where the home directories of each cluster are created.
+	// A temporary home directory is used for each request if empty.
+	HomesDir string
+
+	// Helm executable used to render charts with helm template. Charts are refused if empty.
+	Helm string
 }
 
 // Start "kubectl apply" RESTful server
@@ -30,43 +111,207 @@ func Start(ctx context.Context, params Params) error {
 type Server struct {
 	ctx context.Context
 
-	params Params
+	params     Params
+	tlsConfigs []*tls.Config
+	pm         sync.RWMutex
 
 	http *http.Server
 	ec   chan error
+
+	queue       *queue
+	versions    *versionCache
+	idempotency *idempotencyStore
+	jobs        *jobStore
+	kubeconfigs *kubeconfigs
+	schemas     *schemaCache
+}
+
+// Handler of the service, to serve it on an existing HTTP server.
+// Requests running in the background are canceled when the context is done.
+func Handler(ctx context.Context, params Params) (http.Handler, error) {
+	var s = &Server{}
+
+	if err := s.setup(ctx, params); err != nil {
+		return nil, err
+	}
+
+	return s.http.Handler, nil
 }
 
 // Serve handlers
 func (s *Server) Serve(ctx context.Context, params Params) error {
+	if err := s.setup(ctx, params); err != nil {
+		return err
+	}
+
+	return s.serve()
+}
+
+func (s *Server) setup(ctx context.Context, params Params) error {
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	tlsConfigs, err := loadTLSConfigs(params.listeners())
+
+	if err != nil {
+		return err
+	}
+
 	s.ctx = ctx
 	s.params = params
+	s.tlsConfigs = tlsConfigs
+	s.queue = newQueue(params.MaxConcurrency)
+	s.kubeconfigs = &kubeconfigs{dir: params.KubeconfigsDir, kubectl: s.kubectlCommand}
+
+	if s.kubeconfigs.dir == "" {
+		s.kubeconfigs.dir = DefaultKubeconfigsDir
+	}
+	s.versions = newVersionCache(params.VersionCacheTTL, s.kubectlCommand)
+	s.schemas = newSchemaCache(params.CRDsDir)
+	s.idempotency = newIdempotencyStore(params.IdempotencyTTL)
+	s.idempotency.setMax(params.IdempotencyMaxKeys)
+	s.jobs = newJobStore(params.JobTTL)
+
+	if n, err := s.idempotency.load(kubeapply.ConfigurationsDir); err != nil {
+		log.Errorf("cannot load idempotency keys from recordings: %v", err)
+	} else if n != 0 {
+		log.Debugf("Loaded %d idempotency keys from recordings", n)
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", handleHome)
-	mux.HandleFunc("/apply", handleApply)
-	mux.HandleFunc("/version", handleVersion)
+	mux.HandleFunc("/", instrument("/", handleHome))
+	mux.HandleFunc("/apply", instrument("/apply", s.handleApply))
+	mux.HandleFunc("/validate", instrument("/validate", s.handleValidate))
+	mux.HandleFunc("/render", instrument("/render", s.handleRender))
+	mux.HandleFunc("/jobs/", instrument("/jobs/{id}", s.handleJobs))
+	mux.HandleFunc("/recordings/", instrument("/recordings/{id}", s.handleRecordings))
+	mux.HandleFunc("/clusters", instrument("/clusters", s.handleClusters))
+	mux.HandleFunc("/clusters/", instrument("/clusters/{name}", s.handleClusters))
+	mux.HandleFunc("/version", instrument("/version", s.handleVersion))
+	mux.HandleFunc("/healthz", instrument("/healthz", handleHealthz))
+	mux.HandleFunc("/readyz", instrument("/readyz", s.handleReadyz))
+	mux.HandleFunc("/admin/kubeconfigs", instrument("/admin/kubeconfigs", s.handleAdminKubeconfigs))
+	mux.HandleFunc("/admin/kubeconfigs/", instrument("/admin/kubeconfigs/{name}", s.handleAdminKubeconfigs))
+	mux.Handle("/metrics", metrics.Handler())
 
 	s.http = &http.Server{
-		Handler: mux,
+		Handler: withRequestID(s.authenticate(mux)),
 	}
 
-	return s.serve()
+	return nil
+}
+
+// settings of the server, which might change on Reload.
+func (s *Server) settings() Params {
+	s.pm.RLock()
+	defer s.pm.RUnlock()
+	return s.params
 }
 
-func getAddr(a string) string {
+// Reload the settings of the server without interrupting the requests in flight.
+// Changes to the listeners (except for their TLS certificates), the maximum concurrency,
+// the kubeconfigs directory, and the CRDs directory require a restart, and are ignored.
+func (s *Server) Reload(params Params) error {
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	var old = s.settings()
+
+	if !sameListeners(old.listeners(), params.listeners()) {
+		log.Warn("Changing the listeners requires a restart; keeping the current listeners")
+		params.Address, params.Listeners = old.Address, old.Listeners
+	}
+
+	if params.MaxConcurrency != old.MaxConcurrency {
+		log.Warn("Changing the maximum concurrency requires a restart; keeping the current value")
+		params.MaxConcurrency = old.MaxConcurrency
+	}
+
+	if params.KubeconfigsDir != old.KubeconfigsDir || params.CRDsDir != old.CRDsDir {
+		log.Warn("Changing the kubeconfigs or CRDs directory requires a restart; keeping the current directories")
+		params.KubeconfigsDir, params.CRDsDir = old.KubeconfigsDir, old.CRDsDir
+	}
+
+	tlsConfigs, err := loadTLSConfigs(params.listeners())
+
+	if err != nil {
+		return err
+	}
+
+	s.pm.Lock()
+	s.params = params
+	s.tlsConfigs = tlsConfigs
+	s.pm.Unlock()
+
+	s.versions.setTTL(params.VersionCacheTTL)
+	s.idempotency.setTTL(params.IdempotencyTTL)
+	s.idempotency.setMax(params.IdempotencyMaxKeys)
+	s.jobs.setTTL(params.JobTTL)
+	return nil
+}
+
+// hasSchemas checks if there are schemas for validating the objects of every cluster.
+func (p Params) hasSchemas() bool {
+	if p.OpenAPISpec != "" || p.CRDsDir != "" {
+		return true
+	}
+
+	for _, c := range p.Clusters {
+		if c.OpenAPISpec == "" {
+			return false
+		}
+	}
+
+	return len(p.Clusters) != 0
+}
+
+func (p Params) validate() error {
+	if err := ValidateClusters(p.Clusters); err != nil {
+		return err
+	}
+
+	if p.ValidateBeforeApply && !p.hasSchemas() {
+		return errNoSchemas
+	}
+
+	if p.DefaultCluster == "" {
+		return nil
+	}
+
+	for _, c := range p.Clusters {
+		if c.Name == p.DefaultCluster {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(`default cluster "%s" not found`, p.DefaultCluster)
+}
+
+func getAddr(a string, secure bool) string {
 	l := strings.LastIndex(a, ":")
 
 	if l == -1 && len(a) <= l {
 		return a
 	}
 
+	if secure {
+		return "https://localhost:" + a[l+1:]
+	}
+
 	return "http://localhost:" + a[l+1:]
 }
 
 // Serve HTTP requests
 func (s *Server) serve() error {
-	s.ec = make(chan error, 1)
-	go s.listen()
+	var listeners = s.settings().listeners()
+	s.ec = make(chan error, len(listeners)+1)
+
+	for i, l := range listeners {
+		go s.listen(i, l)
+	}
+
 	go s.waitShutdown()
 
 	err := <-s.ec
@@ -91,15 +336,23 @@ func (s *Server) waitShutdown() {
 	}
 }
 
-func (s *Server) listen() {
-	l, err := net.Listen("tcp", s.params.Address)
+func (s *Server) listen(i int, listener Listener) {
+	l, err := net.Listen("tcp", listener.Address)
 
 	if err != nil {
 		s.ec <- err
 		return
 	}
 
-	log.Infof("Starting server on %v", getAddr(l.Addr().String()))
+	if listener.TLS != nil {
+		l = tls.NewListener(l, &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.tlsConfig(i), nil
+			},
+		})
+	}
+
+	log.Infof("Starting server on %v", getAddr(l.Addr().String(), listener.TLS != nil))
 
 	err = s.http.Serve(l)
 
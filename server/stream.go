@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/henvic/kubeapply"
+)
+
+// StreamMediaType is accepted by /apply to stream the output of kubectl as it runs.
+const StreamMediaType = "application/x-ndjson"
+
+// StreamEvent is a line of a streamed response.
+// Events with the output of kubectl are followed by a last event with the response.
+type StreamEvent struct {
+	// Stream of the output: stdout or stderr.
+	Stream string `json:"stream,omitempty"`
+	Data   string `json:"data,omitempty"`
+
+	Response *kubeapply.Response `json:"response,omitempty"`
+}
+
+// acceptsStream checks if the client asked for the output to be streamed.
+func acceptsStream(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(v)); err == nil && mt == StreamMediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// outputStream writes the output of kubectl to the response as it runs.
+// It replaces the response writer, so that the response is written as the last event.
+type outputStream struct {
+	http.ResponseWriter
+
+	started  bool
+	streamed bool
+
+	m sync.Mutex
+}
+
+func newOutputStream(w http.ResponseWriter, a *kubeapply.Apply) *outputStream {
+	var o = &outputStream{
+		ResponseWriter: w,
+	}
+
+	a.Stdout = streamWriter{o, "stdout"}
+	a.Stderr = streamWriter{o, "stderr"}
+	return o
+}
+
+// event is written and flushed, starting the response if needed.
+func (o *outputStream) event(e StreamEvent) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if !o.started {
+		o.started = true
+		o.Header().Set("Content-Type", StreamMediaType)
+		o.WriteHeader(http.StatusOK)
+	}
+
+	if e.Stream != "" {
+		o.streamed = true
+	}
+
+	_ = json.NewEncoder(o.ResponseWriter).Encode(e)
+
+	if f, ok := o.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// done writes the response as the last event.
+// The output is sent first if it wasn't streamed, such as when replaying a response.
+func (o *outputStream) done(resp kubeapply.Response) {
+	o.m.Lock()
+	var streamed = o.streamed
+	o.m.Unlock()
+
+	if !streamed && resp.Stderr != "" {
+		o.event(StreamEvent{Stream: "stderr", Data: resp.Stderr})
+	}
+
+	if !streamed && resp.Stdout != "" {
+		o.event(StreamEvent{Stream: "stdout", Data: string(resp.Stdout)})
+	}
+
+	o.event(StreamEvent{Response: &resp})
+}
+
+type streamWriter struct {
+	o      *outputStream
+	stream string
+}
+
+func (sw streamWriter) Write(p []byte) (int, error) {
+	sw.o.event(StreamEvent{Stream: sw.stream, Data: string(p)})
+	return len(p), nil
+}
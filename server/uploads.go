@@ -0,0 +1,284 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/server/decoding"
+)
+
+// requestPart is the name of the multipart/form-data part with the JSON request body.
+const requestPart = "request"
+
+// uploadError is an unsafe or invalid uploaded file.
+type uploadError struct {
+	error
+}
+
+// decodeMultipart request: the "request" part has the JSON request body, and the parts with a filename are files.
+func (s *Server) decodeMultipart(r *http.Request, boundary string) (arb ApplyRequestBody, err error) {
+	if boundary == "" {
+		return arb, errors.New("missing multipart boundary")
+	}
+
+	var mr = multipart.NewReader(r.Body, boundary)
+	var files = map[string][]byte{}
+	var hasRequest bool
+
+	for {
+		p, err := mr.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return arb, err
+		}
+
+		name, filename := partNames(p)
+
+		switch {
+		case filename != "":
+			if err := addUpload(files, filename, p); err != nil {
+				return arb, err
+			}
+		case name == requestPart && !hasRequest:
+			hasRequest = true
+
+			if err := json.NewDecoder(p).Decode(&arb); err != nil {
+				return arb, fmt.Errorf("cannot decode %s part as JSON: %v", requestPart, err)
+			}
+		default:
+			return arb, fmt.Errorf(`unexpected part "%s": use "%s" for the JSON request body, and a filename for files`,
+				name, requestPart)
+		}
+	}
+
+	return arb, mergeUploads(&arb, files, nil)
+}
+
+// partNames gets the name and filename of a multipart part.
+// multipart.Part.FileName is not used as it discards the directories.
+func partNames(p *multipart.Part) (name, filename string) {
+	var _, params, err = mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+
+	if err != nil {
+		return "", ""
+	}
+
+	return params["name"], params["filename"]
+}
+
+// decodeTarball of files compressed with gzip. The cluster, command, and flags are read from the query parameters.
+// Only regular files and directories are accepted, and the permission bits of the files are kept.
+func (s *Server) decodeTarball(r *http.Request) (arb ApplyRequestBody, err error) {
+	if arb, err = queryRequest(r.URL.Query()); err != nil {
+		return arb, err
+	}
+
+	gz, err := gzip.NewReader(r.Body)
+
+	if err != nil {
+		return arb, fmt.Errorf("cannot decompress request body: %v", err)
+	}
+
+	var (
+		limits = s.settings().Limits
+		tr     = tar.NewReader(gz)
+		files  = map[string][]byte{}
+		modes  = map[string]decoding.FileMode{}
+		total  int64
+	)
+
+	for {
+		h, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return arb, fmt.Errorf("cannot read tar archive: %v", err)
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return arb, uploadError{fmt.Errorf(`refusing to apply: "%s" is not a regular file or directory`, h.Name)}
+		}
+
+		// check the limits before decompressing the files
+		switch total += h.Size; {
+		case limits.MaxFiles != 0 && len(files) >= limits.MaxFiles:
+			return arb, &kubeapply.LimitError{Limit: "max_files", Max: int64(limits.MaxFiles), Value: -1}
+		case limits.MaxFileSize != 0 && h.Size > limits.MaxFileSize:
+			return arb, &kubeapply.LimitError{Limit: "max_file_size", Max: limits.MaxFileSize, Value: h.Size, File: h.Name}
+		case limits.MaxTotalSize != 0 && total > limits.MaxTotalSize:
+			return arb, &kubeapply.LimitError{Limit: "max_total_size", Max: limits.MaxTotalSize, Value: -1}
+		}
+
+		if err := addUpload(files, h.Name, tr); err != nil {
+			return arb, err
+		}
+
+		clean, _ := kubeapply.CleanUploadPath(h.Name)
+		modes[clean] = decoding.FileMode(h.Mode & 0777)
+	}
+
+	return arb, mergeUploads(&arb, files, modes)
+}
+
+// writeDecodeError with 413 or 422 for uploads exceeding the limits or unsafe, and 400 otherwise.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	if _, ok := err.(*kubeapply.LimitError); ok {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	if ue, ok := err.(uploadError); ok {
+		writeUploadError(w, r, ue.error)
+		return
+	}
+
+	ErrorHandler(w, r, http.StatusBadRequest, err.Error())
+}
+
+// addUpload to the files, using the canonical form of its path.
+func addUpload(files map[string][]byte, name string, r io.Reader) error {
+	var clean, err = kubeapply.CleanUploadPath(name)
+
+	if err != nil {
+		return uploadError{err}
+	}
+
+	if _, ok := files[clean]; ok {
+		return uploadError{fmt.Errorf(`refusing to apply: filepath "%s" is uploaded more than once`, clean)}
+	}
+
+	b, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return err
+	}
+
+	files[clean] = b
+	return nil
+}
+
+// mergeUploads with the files of the request body.
+func mergeUploads(arb *ApplyRequestBody, files map[string][]byte, modes map[string]decoding.FileMode) error {
+	if arb.Files == nil {
+		arb.Files = map[string]decoding.FileValue{}
+	}
+
+	for f, b := range files {
+		if _, ok := arb.Files[f]; ok {
+			return uploadError{fmt.Errorf(`refusing to apply: filepath "%s" is uploaded more than once`, f)}
+		}
+
+		arb.Files[f] = decoding.FileValue{Data: b}
+	}
+
+	if arb.Modes == nil && len(modes) != 0 {
+		arb.Modes = map[string]decoding.FileMode{}
+	}
+
+	for f, m := range modes {
+		arb.Modes[f] = m
+	}
+
+	return nil
+}
+
+// YAMLBodyFile is the name of the file with the body of application/yaml requests.
+const YAMLBodyFile = "manifests.yaml"
+
+func isYAML(mediaType string) bool {
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+
+	return false
+}
+
+// decodeYAML request, whose body is the manifests. The cluster, command, and flags are read from the query parameters.
+func decodeYAML(r *http.Request) (arb ApplyRequestBody, err error) {
+	if arb, err = queryRequest(r.URL.Query()); err != nil {
+		return arb, err
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		return arb, err
+	}
+
+	if len(b) == 0 {
+		return arb, errors.New("empty request body: send the manifests")
+	}
+
+	arb.Files = map[string]decoding.FileValue{
+		YAMLBodyFile: {Data: b},
+	}
+
+	return arb, nil
+}
+
+// queryRequest gets the cluster, command, positional arguments, and flags of a request from the query parameters.
+// Any parameter other than cluster, command, and args is a flag. Flags and args can be repeated.
+func queryRequest(q url.Values) (arb ApplyRequestBody, err error) {
+	arb.Flags = map[string]decoding.FlagValue{}
+
+	for k, v := range q {
+		switch k {
+		case "cluster", "command":
+			if len(v) != 1 {
+				return arb, fmt.Errorf(`query parameter "%s" is set more than once`, k)
+			}
+		}
+
+		switch k {
+		case "cluster":
+			arb.Cluster = v[0]
+		case "command":
+			arb.Command = v[0]
+		case "args":
+			arb.Args = v
+		default:
+			arb.Flags[k] = decoding.FlagValue(v)
+		}
+	}
+
+	return arb, nil
+}
+
+// checkModes are set for uploaded files only, and only once.
+func (a *ApplyRequestBody) checkModes() error {
+	for f := range a.Modes {
+		v, ok := a.Files[f]
+
+		if !ok {
+			return fmt.Errorf(`mode set for "%s", but file is not uploaded`, f)
+		}
+
+		if v.Mode != nil {
+			return fmt.Errorf(`mode set for "%s" twice`, f)
+		}
+	}
+
+	return nil
+}
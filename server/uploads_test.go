@@ -0,0 +1,380 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/server/decoding"
+)
+
+func TestDecodeMultipart(t *testing.T) {
+	var body bytes.Buffer
+	var mw = multipart.NewWriter(&body)
+
+	if err := mw.WriteField("request", `{"command": "create", "flags": {"dry-run": "server"}, "files": {"a.yaml": "a"}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	var h = textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="file"; filename="apps/web.yaml"`)
+	part, err := mw.CreatePart(h)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = part.Write([]byte("kind: Deployment\n"))
+	_ = mw.Close()
+
+	var r = httptest.NewRequest(http.MethodPost, "/apply", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var s = &Server{}
+	var w = httptest.NewRecorder()
+	var arb, _, ok = s.decodeApplyRequest(w, r)
+
+	if !ok {
+		t.Fatalf("Unexpected error: %v", w.Body.String())
+	}
+
+	var want = ApplyRequestBody{
+		Command: "create",
+		Flags:   map[string]decoding.FlagValue{"dry-run": {"server"}},
+		Files: map[string]decoding.FileValue{
+			"a.yaml":        {Data: []byte("a")},
+			"apps/web.yaml": {Data: []byte("kind: Deployment\n")},
+		},
+	}
+
+	if !reflect.DeepEqual(arb, want) {
+		t.Errorf("Expected request %+v, got %+v instead", want, arb)
+	}
+}
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	content  string
+}
+
+func tarball(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	var gz = gzip.NewWriter(&buf)
+	var tw = tar.NewWriter(gz)
+
+	for _, e := range entries {
+		var h = &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     e.mode,
+			Size:     int64(len(e.content)),
+			Linkname: "/etc/passwd",
+		}
+
+		if e.typeflag != tar.TypeReg {
+			h.Size = 0
+		}
+
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(e.content)); err != nil && e.typeflag == tar.TypeReg {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestDecodeTarball(t *testing.T) {
+	var body = tarball(t, []tarEntry{
+		{name: "./", typeflag: tar.TypeDir, mode: 0755},
+		{name: "./apps/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "./apps/web.yaml", typeflag: tar.TypeReg, mode: 0644, content: "kind: Deployment\n"},
+		{name: "./scripts/run.sh", typeflag: tar.TypeReg, mode: 04755, content: "#!/bin/sh\n"},
+	})
+
+	var r = httptest.NewRequest(http.MethodPut, "/apply?command=create&dry-run=server&R=", body)
+	r.Header.Set("Content-Type", "application/gzip")
+
+	var s = &Server{}
+	var w = httptest.NewRecorder()
+	var arb, _, ok = s.decodeApplyRequest(w, r)
+
+	if !ok {
+		t.Fatalf("Unexpected error: %v", w.Body.String())
+	}
+
+	var want = ApplyRequestBody{
+		Command: "create",
+		Flags:   map[string]decoding.FlagValue{"dry-run": {"server"}, "R": {""}},
+		Files: map[string]decoding.FileValue{
+			"apps/web.yaml":  {Data: []byte("kind: Deployment\n")},
+			"scripts/run.sh": {Data: []byte("#!/bin/sh\n")},
+		},
+		Modes: map[string]decoding.FileMode{
+			"apps/web.yaml":  0644,
+			"scripts/run.sh": 0755,
+		},
+	}
+
+	if !reflect.DeepEqual(arb, want) {
+		t.Errorf("Expected request %+v, got %+v instead", want, arb)
+	}
+}
+
+var decodeUploadsErrorsCases = []struct {
+	name        string
+	contentType string
+	body        func(t *testing.T) *bytes.Buffer
+	want        int
+}{
+	{
+		name:        "symbolic link",
+		contentType: "application/gzip",
+		body: func(t *testing.T) *bytes.Buffer {
+			return tarball(t, []tarEntry{{name: "a.yaml", typeflag: tar.TypeSymlink}})
+		},
+		want: http.StatusUnprocessableEntity,
+	},
+	{
+		name:        "parent directory",
+		contentType: "application/gzip",
+		body: func(t *testing.T) *bytes.Buffer {
+			return tarball(t, []tarEntry{{name: "../a.yaml", typeflag: tar.TypeReg, content: "a"}})
+		},
+		want: http.StatusUnprocessableEntity,
+	},
+	{
+		name:        "reserved",
+		contentType: "application/gzip",
+		body: func(t *testing.T) *bytes.Buffer {
+			return tarball(t, []tarEntry{{name: "./response", typeflag: tar.TypeReg, content: "a"}})
+		},
+		want: http.StatusUnprocessableEntity,
+	},
+	{
+		name:        "duplicated",
+		contentType: "application/gzip",
+		body: func(t *testing.T) *bytes.Buffer {
+			return tarball(t, []tarEntry{
+				{name: "a.yaml", typeflag: tar.TypeReg, content: "a"},
+				{name: "./a.yaml", typeflag: tar.TypeReg, content: "b"},
+			})
+		},
+		want: http.StatusUnprocessableEntity,
+	},
+	{
+		name:        "file too large",
+		contentType: "application/gzip",
+		body: func(t *testing.T) *bytes.Buffer {
+			return tarball(t, []tarEntry{{name: "a.yaml", typeflag: tar.TypeReg, content: string(make([]byte, 11))}})
+		},
+		want: http.StatusRequestEntityTooLarge,
+	},
+	{
+		name:        "too many files",
+		contentType: "application/gzip",
+		body: func(t *testing.T) *bytes.Buffer {
+			return tarball(t, []tarEntry{
+				{name: "a.yaml", typeflag: tar.TypeReg},
+				{name: "b.yaml", typeflag: tar.TypeReg},
+				{name: "c.yaml", typeflag: tar.TypeReg},
+			})
+		},
+		want: http.StatusUnprocessableEntity,
+	},
+	{
+		name:        "not gzip",
+		contentType: "application/gzip",
+		body: func(t *testing.T) *bytes.Buffer {
+			return bytes.NewBufferString("plain text")
+		},
+		want: http.StatusBadRequest,
+	},
+	{
+		name:        "missing boundary",
+		contentType: "multipart/form-data",
+		body: func(t *testing.T) *bytes.Buffer {
+			return &bytes.Buffer{}
+		},
+		want: http.StatusBadRequest,
+	},
+	{
+		name:        "unsupported",
+		contentType: "text/plain",
+		body: func(t *testing.T) *bytes.Buffer {
+			return &bytes.Buffer{}
+		},
+		want: http.StatusNotAcceptable,
+	},
+}
+
+func TestDecodeUploadsErrors(t *testing.T) {
+	var s = &Server{
+		params: Params{
+			Limits: kubeapply.Limits{
+				MaxFiles:    2,
+				MaxFileSize: 10,
+			},
+		},
+	}
+
+	for _, tt := range decodeUploadsErrorsCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodPut, "/apply", tt.body(t))
+			r.Header.Set("Content-Type", tt.contentType)
+
+			var w = httptest.NewRecorder()
+
+			if _, _, ok := s.decodeApplyRequest(w, r); ok || w.Code != tt.want {
+				t.Errorf("Expected status code %v, got %v instead (%s)", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDecodeYAML(t *testing.T) {
+	var body = bytes.NewBufferString("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: web\n")
+	var r = httptest.NewRequest(http.MethodPut, "/apply?cluster=production&--dry-run=server&n=web&force&l=app=web&l=tier=frontend&args=a&args=b", body)
+	r.Header.Set("Content-Type", "application/yaml")
+
+	var s = &Server{}
+	var w = httptest.NewRecorder()
+	var arb, _, ok = s.decodeApplyRequest(w, r)
+
+	if !ok {
+		t.Fatalf("Unexpected error: %v", w.Body.String())
+	}
+
+	var want = ApplyRequestBody{
+		Cluster: "production",
+		Args:    []string{"a", "b"},
+		Flags: map[string]decoding.FlagValue{
+			"--dry-run": {"server"},
+			"n":         {"web"},
+			"force":     {""},
+			"l":         {"app=web", "tier=frontend"},
+		},
+		Files: map[string]decoding.FileValue{
+			YAMLBodyFile: {Data: []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: web\n")},
+		},
+	}
+
+	if !reflect.DeepEqual(arb, want) {
+		t.Errorf("Expected request %+v, got %+v instead", want, arb)
+	}
+}
+
+var decodeYAMLErrorsCases = []struct {
+	name  string
+	query string
+	body  string
+}{
+	{
+		name: "empty body",
+	},
+	{
+		name:  "repeated cluster",
+		query: "?cluster=a&cluster=b",
+		body:  "kind: Namespace",
+	},
+}
+
+func TestDecodeYAMLErrors(t *testing.T) {
+	var s = &Server{}
+
+	for _, tt := range decodeYAMLErrorsCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodPut, "/apply"+tt.query, bytes.NewBufferString(tt.body))
+			r.Header.Set("Content-Type", "text/yaml; charset=utf-8")
+
+			var w = httptest.NewRecorder()
+
+			if _, _, ok := s.decodeApplyRequest(w, r); ok || w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %v, got %v instead (%s)", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDecodeFileObjects(t *testing.T) {
+	var body = bytes.NewBufferString(`{
+	"files": {
+		"logo.png": {"base64": "iVBORw0KGgo=", "mode": "0600"},
+		"run.sh": {"content": "#!/bin/sh\n"},
+		"cm.json": {"apiVersion": "v1", "kind": "ConfigMap"}
+	},
+	"modes": {"run.sh": "0755"}
+}`)
+
+	var r = httptest.NewRequest(http.MethodPut, "/apply", body)
+	r.Header.Set("Content-Type", "application/json")
+
+	var s = &Server{}
+	var w = httptest.NewRecorder()
+	var arb, _, ok = s.decodeApplyRequest(w, r)
+
+	if !ok {
+		t.Fatalf("Unexpected error: %v", w.Body.String())
+	}
+
+	var wantFiles = map[string][]byte{
+		"logo.png": []byte("\x89PNG\r\n\x1a\n"),
+		"run.sh":   []byte("#!/bin/sh\n"),
+		"cm.json":  []byte(`{"apiVersion": "v1", "kind": "ConfigMap"}`),
+	}
+
+	if files := arb.FilesMap(); !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("Expected files %q, got %q instead", wantFiles, files)
+	}
+
+	var wantModes = map[string]os.FileMode{
+		"logo.png": 0600,
+		"run.sh":   0755,
+	}
+
+	if modes := arb.ModesMap(); !reflect.DeepEqual(modes, wantModes) {
+		t.Errorf("Expected modes %v, got %v instead", wantModes, modes)
+	}
+}
+
+func TestDecodeFileObjectsErrors(t *testing.T) {
+	var cases = []string{
+		`{"files": {"a": {"base64": "not base64"}}}`,
+		`{"files": {"a": {"content": "", "mode": "0600"}}, "modes": {"a": "0644"}}`,
+		`{"files": {"a": ""}, "modes": {"b": "0644"}}`,
+	}
+
+	var s = &Server{}
+
+	for _, c := range cases {
+		var r = httptest.NewRequest(http.MethodPut, "/apply", bytes.NewBufferString(c))
+		r.Header.Set("Content-Type", "application/json")
+
+		var w = httptest.NewRecorder()
+
+		if _, _, ok := s.decodeApplyRequest(w, r); ok || w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %v for %s, got %v instead (%s)", http.StatusBadRequest, c, w.Code, w.Body.String())
+		}
+	}
+}
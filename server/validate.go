@@ -0,0 +1,173 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/henvic/kubeapply"
+	"github.com/henvic/kubeapply/openapi"
+	"github.com/henvic/kubeapply/tracing"
+)
+
+var errNoSchemas = errors.New("schema validation is not configured: set an OpenAPI specification or a CRDs directory")
+
+// schemaCache of the OpenAPI schemas, loaded on first use.
+type schemaCache struct {
+	crdsDir string
+
+	m       sync.Mutex
+	schemas map[string]*openapi.Schemas
+}
+
+func newSchemaCache(crdsDir string) *schemaCache {
+	return &schemaCache{
+		crdsDir: crdsDir,
+		schemas: map[string]*openapi.Schemas{},
+	}
+}
+
+func (sc *schemaCache) get(spec string) (*openapi.Schemas, error) {
+	if spec == "" && sc.crdsDir == "" {
+		return nil, errNoSchemas
+	}
+
+	sc.m.Lock()
+	defer sc.m.Unlock()
+
+	if s, ok := sc.schemas[spec]; ok {
+		return s, nil
+	}
+
+	var s, err = openapi.Load(spec, sc.crdsDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sc.schemas[spec] = s
+	return s, nil
+}
+
+// schemasFor the cluster, which might have its own OpenAPI specification.
+func (s *Server) schemasFor(c *Cluster) (*openapi.Schemas, error) {
+	var spec = s.settings().OpenAPISpec
+
+	if c != nil && c.OpenAPISpec != "" {
+		spec = c.OpenAPISpec
+	}
+
+	return s.schemas.get(spec)
+}
+
+// hasSchemasFor the cluster: its own OpenAPI specification, or the ones of the server.
+func (s *Server) hasSchemasFor(c *Cluster) bool {
+	var params = s.settings()
+	return params.OpenAPISpec != "" || params.CRDsDir != "" || (c != nil && c.OpenAPISpec != "")
+}
+
+type validateResponse struct {
+	Valid   bool                     `json:"valid"`
+	Objects []kubeapply.Object       `json:"objects"`
+	Errors  kubeapply.ManifestErrors `json:"errors,omitempty"`
+}
+
+// handleValidate validates the uploaded manifests against the OpenAPI schemas without applying them.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "handleValidate")
+	defer span.End()
+
+	r = r.WithContext(ctx)
+
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		ErrorHandler(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	arb, dump, ok := s.decodeApplyRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	var c, err = s.resolveCluster(r, arb)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var a = s.newApply(r, arb, dump, c)
+
+	if err := a.CheckUploads(); err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	schemas, err := s.schemasFor(c)
+
+	if err == errNoSchemas {
+		ErrorHandler(w, r, http.StatusNotImplemented, err.Error())
+		return
+	}
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusInternalServerError, "cannot load schemas")
+		logger(r).Errorf("cannot load schemas: %v", err)
+		return
+	}
+
+	var resp = validateResponse{
+		Valid:   true,
+		Objects: []kubeapply.Object{},
+	}
+
+	objects, err := a.ValidateManifests(schemas)
+
+	if objects != nil {
+		resp.Objects = objects
+	}
+
+	if me, ok := err.(kubeapply.ManifestErrors); ok {
+		resp.Valid = false
+		resp.Errors = me
+	} else if err != nil {
+		ErrorHandler(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	var status = http.StatusOK
+
+	if !resp.Valid {
+		status = http.StatusUnprocessableEntity
+	}
+
+	writeJSON(w, r, status, resp)
+}
+
+// preflight validates the manifests against the OpenAPI schemas before applying them, if enabled.
+func (s *Server) preflight(w http.ResponseWriter, r *http.Request, a *kubeapply.Apply, c *Cluster) bool {
+	if !s.settings().ValidateBeforeApply {
+		return true
+	}
+
+	if !s.hasSchemasFor(c) {
+		logger(r).Debugf("Skipping validation before apply: no schemas are configured for the cluster")
+		return true
+	}
+
+	var schemas, err = s.schemasFor(c)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusInternalServerError, "cannot load schemas")
+		logger(r).Errorf("cannot load schemas: %v", err)
+		return false
+	}
+
+	if _, err := a.ValidateManifests(schemas); err != nil {
+		ErrorHandler(w, r, http.StatusUnprocessableEntity, err.Error())
+		return false
+	}
+
+	return true
+}
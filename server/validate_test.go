@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/henvic/kubeapply"
+)
+
+var validateCases = []struct {
+	name   string
+	spec   string
+	body   string
+	want   int
+	errors int
+}{
+	{
+		name: "valid",
+		spec: "../openapi/testdata/swagger.json",
+		body: `{"files": {"app.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  selector: {}\n  template: {}\n"}}`,
+		want: http.StatusOK,
+	},
+	{
+		name:   "invalid",
+		spec:   "../openapi/testdata/swagger.json",
+		body:   `{"files": {"app.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  replicas: two\n"}}`,
+		want:   http.StatusUnprocessableEntity,
+		errors: 3,
+	},
+	{
+		name: "not configured",
+		body: `{"files": {"app.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n"}}`,
+		want: http.StatusNotImplemented,
+	},
+}
+
+func TestHandleValidate(t *testing.T) {
+	for _, tt := range validateCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var s = &Server{
+				params:      Params{OpenAPISpec: tt.spec},
+				kubeconfigs: &kubeconfigs{dir: t.TempDir()},
+				schemas:     newSchemaCache(""),
+			}
+
+			var r = httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+
+			var w = httptest.NewRecorder()
+			s.handleValidate(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("Expected status code %v, got %v instead (%s)", tt.want, w.Code, w.Body.String())
+			}
+
+			if tt.want == http.StatusNotImplemented {
+				return
+			}
+
+			var resp validateResponse
+
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Cannot decode response: %v", err)
+			}
+
+			if len(resp.Objects) != 1 || len(resp.Errors) != tt.errors {
+				t.Errorf("Expected 1 object and %d errors, got %+v instead", tt.errors, resp)
+			}
+		})
+	}
+}
+
+var preflightCases = []struct {
+	name    string
+	cluster *Cluster
+	want    int
+}{
+	{"cluster with schemas", &Cluster{Name: "prod", OpenAPISpec: "../openapi/testdata/swagger.json"}, http.StatusUnprocessableEntity},
+	{"cluster without schemas", &Cluster{Name: "dev"}, http.StatusOK},
+	{"no cluster", nil, http.StatusOK},
+}
+
+func TestPreflight(t *testing.T) {
+	var a = &kubeapply.Apply{
+		Files: map[string][]byte{
+			"app.yaml": []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  replicas: two\n"),
+		},
+	}
+
+	for _, tt := range preflightCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var s = &Server{
+				params:  Params{ValidateBeforeApply: true},
+				schemas: newSchemaCache(""),
+			}
+
+			var r = httptest.NewRequest(http.MethodPost, "/apply", nil)
+			var w = httptest.NewRecorder()
+
+			if ok := s.preflight(w, r, a, tt.cluster); ok != (tt.want == http.StatusOK) {
+				t.Errorf("Expected preflight to return %v, got %v instead", !ok, ok)
+			}
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status code %v, got %v instead (%s)", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
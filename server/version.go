@@ -1,27 +1,289 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"os/exec"
-
-	log "github.com/sirupsen/logrus"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
 )
 
-func handleVersion(w http.ResponseWriter, r *http.Request) {
-	var cmd = exec.CommandContext(r.Context(), "kubectl", "version", "--client", "--output=json") // #nosec
+// Version of kubeapply.
+// You can set it at build time with -ldflags "-X github.com/henvic/kubeapply/server.Version=v1.0.0".
+var Version = ""
+
+// Commit of kubeapply.
+// You can set it at build time with -ldflags "-X github.com/henvic/kubeapply/server.Commit=abc123".
+var Commit = ""
+
+// DefaultVersionCacheTTL is the duration the kubectl version responses are cached for.
+const DefaultVersionCacheTTL = time.Minute
+
+// BuildInfo of kubeapply.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// GetBuildInfo returns the build information of kubeapply.
+func GetBuildInfo() BuildInfo {
+	var bi = BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	info, ok := debug.ReadBuildInfo()
+
+	if !ok {
+		return bi
+	}
+
+	if bi.Version == "" && info.Main.Version != "(devel)" {
+		bi.Version = info.Main.Version
+	}
+
+	for _, s := range info.Settings {
+		if bi.Commit == "" && s.Key == "vcs.revision" {
+			bi.Commit = s.Value
+		}
+	}
+
+	if bi.Version == "" {
+		bi.Version = "devel"
+	}
+
+	return bi
+}
+
+// VersionResponse of the /version endpoint.
+type VersionResponse struct {
+	Kubeapply BuildInfo       `json:"kubeapply"`
+	Kubectl   json.RawMessage `json:"kubectl"`
+	Cluster   *ClusterVersion `json:"cluster,omitempty"`
+}
+
+// ClusterVersion is the version of the Kubernetes API server of a cluster.
+type ClusterVersion struct {
+	Name          string          `json:"name"`
+	ServerVersion json.RawMessage `json:"serverVersion"`
+}
+
+// versionError contains the stderr of a failed kubectl version command.
+type versionError struct {
+	err    error
+	stderr string
+}
+
+func (v *versionError) Error() string {
+	if v.stderr == "" {
+		return v.err.Error()
+	}
+
+	return fmt.Sprintf("%v: %s", v.err, v.stderr)
+}
+
+type cachedVersion struct {
+	value   json.RawMessage
+	expires time.Time
+}
+
+// versionCache caches the kubectl version responses, keyed by cluster.
+type versionCache struct {
+	ttl     time.Duration
+	kubectl kubectlFunc
+	m       sync.Mutex
+	c       map[string]cachedVersion
+}
+
+func newVersionCache(ttl time.Duration, kubectl kubectlFunc) *versionCache {
+	if ttl == 0 {
+		ttl = DefaultVersionCacheTTL
+	}
+
+	return &versionCache{
+		ttl:     ttl,
+		kubectl: kubectl,
+		c:       map[string]cachedVersion{},
+	}
+}
+
+// setTTL of the cache, forgetting the cached responses.
+func (v *versionCache) setTTL(ttl time.Duration) {
+	if ttl == 0 {
+		ttl = DefaultVersionCacheTTL
+	}
+
+	v.m.Lock()
+	v.ttl = ttl
+	v.c = map[string]cachedVersion{}
+	v.m.Unlock()
+}
+
+// get the client version, or the server version of the cluster if c is not nil.
+func (v *versionCache) get(ctx context.Context, c *Cluster) (json.RawMessage, error) {
+	var cluster string
+
+	if c != nil {
+		cluster = c.Name + "\x00" + c.Context
+	}
+
+	v.m.Lock()
+	cv, ok := v.c[cluster]
+	v.m.Unlock()
+
+	if ok && time.Now().Before(cv.expires) {
+		return cv.value, nil
+	}
+
+	value, err := kubectlVersion(ctx, v.kubectl, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v.m.Lock()
+	v.c[cluster] = cachedVersion{
+		value:   value,
+		expires: time.Now().Add(v.ttl),
+	}
+	v.m.Unlock()
+
+	return value, nil
+}
+
+// forget the cached server versions of a cluster.
+func (v *versionCache) forget(name string) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	for k := range v.c {
+		if strings.HasPrefix(k, name+"\x00") {
+			delete(v.c, k)
+		}
+	}
+}
+
+func kubectlVersion(ctx context.Context, kubectl kubectlFunc, c *Cluster) (json.RawMessage, error) {
+	var args = []string{"version", "--output=json"}
 
-	var v, err = cmd.CombinedOutput()
+	if c == nil {
+		args = append(args, "--client")
+	} else {
+		args = append(args, "--request-timeout=10s")
+	}
+
+	cmd, cleanup, err := kubectl(ctx, c, args...)
 
 	if err != nil {
-		ErrorHandler(w, r, http.StatusInternalServerError)
-		log.Errorf("cannot show version during request: %v", err)
+		return nil, &versionError{err: err}
+	}
+
+	defer cleanup()
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	v, err := cmd.Output()
+
+	if err != nil {
+		return nil, &versionError{
+			err:    err,
+			stderr: strings.TrimSpace(stderr.String()),
+		}
+	}
+
+	var vs struct {
+		ClientVersion json.RawMessage `json:"clientVersion"`
+		ServerVersion json.RawMessage `json:"serverVersion"`
+	}
+
+	if err := json.Unmarshal(v, &vs); err != nil {
+		return nil, fmt.Errorf("cannot decode kubectl version: %v", err)
+	}
+
+	if c == nil {
+		return vs.ClientVersion, nil
+	}
+
+	return vs.ServerVersion, nil
+}
+
+var clusterNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._@:/-]{0,252}$`)
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	defer observeSince(VersionDuration.With(), time.Now())
+
+	var cluster = r.URL.Query().Get("cluster")
+
+	if cluster != "" && !clusterNameRegex.MatchString(cluster) {
+		ErrorHandler(w, r, http.StatusBadRequest, "invalid cluster name")
+		return
+	}
+
+	var vr = VersionResponse{
+		Kubeapply: GetBuildInfo(),
+	}
+
+	var err error
+
+	if vr.Kubectl, err = s.versions.get(r.Context(), nil); err != nil {
+		ErrorHandler(w, r, http.StatusInternalServerError, err.Error())
+		logger(r).Errorf("cannot show version during request: %v", err)
+		return
+	}
+
+	if cluster != "" {
+		s.writeClusterVersion(w, r, vr, cluster)
+		return
+	}
+
+	writeVersion(w, r, vr)
+}
+
+func (s *Server) writeClusterVersion(w http.ResponseWriter, r *http.Request, vr VersionResponse, cluster string) {
+	var c = &Cluster{Name: cluster, Context: cluster}
+
+	if len(s.clusters()) != 0 {
+		found, ok := s.findCluster(cluster)
+
+		if !ok {
+			ErrorHandler(w, r, http.StatusNotFound, fmt.Sprintf(`cluster "%s" not found`, cluster))
+			return
+		}
+
+		c = &found
+	}
+
+	sv, err := s.versions.get(r.Context(), c)
+
+	if err != nil {
+		ErrorHandler(w, r, http.StatusBadGateway, err.Error())
+		logger(r).Errorf("cannot get server version of cluster %v: %v", cluster, err)
 		return
 	}
 
+	vr.Cluster = &ClusterVersion{
+		Name:          cluster,
+		ServerVersion: sv,
+	}
+
+	writeVersion(w, r, vr)
+}
+
+func writeVersion(w http.ResponseWriter, r *http.Request, vr VersionResponse) {
 	w.Header().Set("Content-Type", "application/json; charset=utf8")
 
-	if _, err = fmt.Fprint(w, string(v)); err != nil {
-		log.Errorf("error responding /version request: %v", err)
+	if err := json.NewEncoder(w).Encode(vr); err != nil {
+		logger(r).Errorf("error responding /version request: %v", err)
 	}
 }
@@ -0,0 +1,462 @@
+package kubeapply
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isTemplate checks if the uploaded file is a template of a manifest.
+func isTemplate(filename string) bool {
+	return strings.HasSuffix(filename, ".tmpl.yaml") || strings.HasSuffix(filename, ".tmpl.yml")
+}
+
+// usesTemplates checks if there are templates to render.
+func (a *Apply) usesTemplates() bool {
+	if a.Vars == nil {
+		return false
+	}
+
+	for f := range a.Files {
+		if isTemplate(f) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renderedTemplate is the path where a template is rendered on the recording.
+func renderedTemplate(filename string) string {
+	var ext = path.Ext(filename)
+	return path.Join(RenderedDir, strings.TrimSuffix(path.Clean(filename), ".tmpl"+ext)+ext)
+}
+
+// templateFuncs are safe to use on templates: they don't access files, the environment, or the network.
+var templateFuncs = template.FuncMap{
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+
+		return v
+	},
+	"required": func(msg string, v interface{}) (interface{}, error) {
+		if v == nil || v == "" {
+			return nil, errors.New(msg)
+		}
+
+		return v, nil
+	},
+	"quote": func(v interface{}) string {
+		return strconv.Quote(fmt.Sprint(v))
+	},
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": strings.Replace,
+	"indent": func(n int, s string) (string, error) {
+		return indent(n, s, 0)
+	},
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"toJSON": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"toYAML": func(v interface{}) (string, error) {
+		b, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(b), "\n"), err
+	},
+}
+
+// maxIndent is the maximum number of spaces used by the indent function.
+const maxIndent = 256
+
+// indent each line of s with n spaces, failing if the result is larger than max, if set.
+func indent(n int, s string, max int64) (string, error) {
+	if n < 0 || n > maxIndent {
+		return "", fmt.Errorf("invalid indentation %d: use up to %d spaces", n, maxIndent)
+	}
+
+	if size := int64(len(s)) + int64(n)*int64(strings.Count(s, "\n")+1); max > 0 && size > max {
+		return "", errTemplateTooLarge
+	}
+
+	var pad = strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1), nil
+}
+
+// replace the occurrences of old in s with new, failing if the result is larger than max, if set.
+func replace(s, old, new string, n int, max int64) (string, error) {
+	var count = strings.Count(s, old)
+
+	if n >= 0 && n < count {
+		count = n
+	}
+
+	if size := int64(len(s)) + int64(count)*(int64(len(new))-int64(len(old))); max > 0 && size > max {
+		return "", errTemplateTooLarge
+	}
+
+	return strings.Replace(s, old, new, n), nil
+}
+
+// templateFuncs limiting the size of the strings they create to the maximum file size.
+func (a *Apply) templateFuncs() template.FuncMap {
+	var funcs = template.FuncMap{}
+
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+
+	var max = a.Limits.MaxFileSize
+
+	funcs["indent"] = func(n int, s string) (string, error) {
+		return indent(n, s, max)
+	}
+
+	funcs["replace"] = func(s, old, new string, n int) (string, error) {
+		return replace(s, old, new, n, max)
+	}
+
+	return funcs
+}
+
+// templateTimeout is the maximum time to render each template.
+var templateTimeout = 10 * time.Second
+
+// errTemplateTooLarge is returned when a rendered template exceeds the maximum file size.
+var errTemplateTooLarge = errors.New("rendered template is too large")
+
+// errTemplateTimeout is returned when a template takes too long to render.
+var errTemplateTimeout = errors.New("rendering template timed out")
+
+// limitedBuffer fails writes exceeding its maximum size, if set, or after its deadline, if set.
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int64
+	deadline time.Time
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	if l.max > 0 && int64(l.Len()+len(p)) > l.max {
+		return 0, errTemplateTooLarge
+	}
+
+	if !l.deadline.IsZero() && time.Now().After(l.deadline) {
+		return 0, errTemplateTimeout
+	}
+
+	return l.Buffer.Write(p)
+}
+
+// templateErrorRegex matches the line (and column) on template errors, after the name of the template.
+var templateErrorRegex = regexp.MustCompile(`^(\d+)(?::\d+)?: (.*)$`)
+
+// RenderTemplates of manifests (*.tmpl.yaml or *.tmpl.yml files) using the variables, if set.
+// Templates use the Go text/template syntax, with the variables as data.
+// It returns ManifestErrors pointing to the template file and line on failure.
+func (a *Apply) RenderTemplates() error {
+	if a.Vars == nil || a.rendered != nil {
+		return nil
+	}
+
+	var rendered = map[string][]byte{}
+	var errs = ManifestErrors{}
+
+	for _, f := range a.listFiles() {
+		if !isTemplate(f) {
+			continue
+		}
+
+		b, err := a.renderTemplate(f)
+
+		if err != nil {
+			errs = append(errs, templateError(f, err))
+			continue
+		}
+
+		rendered[renderedTemplate(f)] = b
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	if len(rendered) != 0 && a.Chart != nil {
+		return errors.New("refusing to apply: templates can't be used with charts: use the values of the chart instead")
+	}
+
+	if root, _ := a.KustomizationRoot(); len(rendered) != 0 && root != "" {
+		return errors.New("refusing to apply: templates can't be used with kustomizations")
+	}
+
+	a.rendered = rendered
+	return nil
+}
+
+func (a *Apply) renderTemplate(name string) ([]byte, error) {
+	t, err := template.New(name).Funcs(a.templateFuncs()).Option("missingkey=error").Parse(string(a.Files[name]))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkTemplate(t, a.Vars); err != nil {
+		return nil, err
+	}
+
+	var buf = &limitedBuffer{
+		max:      a.Limits.MaxFileSize,
+		deadline: time.Now().Add(templateTimeout),
+	}
+
+	var done = make(chan error, 1)
+
+	go func() {
+		done <- t.Execute(buf, a.Vars)
+	}()
+
+	var timer = time.NewTimer(templateTimeout)
+	defer timer.Stop()
+
+	// text/template can't be interrupted, but checkTemplate bounds its work: after timing out, the execution
+	// finishes on its own, failing on its next write.
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	case <-timer.C:
+		return nil, errTemplateTimeout
+	}
+}
+
+// maxTemplateSteps is the maximum number of actions, texts, and control structures executed by a template,
+// counting each iteration of a range.
+const maxTemplateSteps = 1000000
+
+// checkTemplate bounds the work of executing it: templates can't define or call other templates,
+// and can't run more than maxTemplateSteps, assuming ranges iterate over the largest collection or number of the vars.
+func checkTemplate(t *template.Template, vars interface{}) error {
+	if len(t.Templates()) > 1 {
+		return fmt.Errorf("template: %s: templates can't define other templates", t.Name())
+	}
+
+	if t.Tree == nil {
+		return nil
+	}
+
+	var c = &templateChecker{
+		tree:    t.Tree,
+		largest: largestRange(reflect.ValueOf(vars)),
+	}
+
+	return c.check(t.Tree.Root, 1)
+}
+
+type templateChecker struct {
+	tree    *parse.Tree
+	largest int64
+	steps   int64
+}
+
+func (c *templateChecker) check(list *parse.ListNode, times int64) error {
+	if list == nil {
+		return nil
+	}
+
+	for _, n := range list.Nodes {
+		if err := c.checkNode(n, times); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *templateChecker) checkNode(n parse.Node, times int64) error {
+	if c.steps += times; c.steps > maxTemplateSteps {
+		return c.errorf(n, "template can run more than %d steps: use fewer or smaller ranges", maxTemplateSteps)
+	}
+
+	switch n := n.(type) {
+	case *parse.TemplateNode:
+		return c.errorf(n, "templates can't call other templates")
+	case *parse.IfNode:
+		return c.checkBranch(&n.BranchNode, times, times)
+	case *parse.WithNode:
+		return c.checkBranch(&n.BranchNode, times, times)
+	case *parse.RangeNode:
+		iterations, err := c.iterations(n.Pipe)
+
+		if err != nil {
+			return err
+		}
+
+		if iterations != 0 && times > maxTemplateSteps/iterations {
+			return c.errorf(n, "template can run more than %d steps: use fewer or smaller ranges", maxTemplateSteps)
+		}
+
+		// each iteration is a step, even if the range has no actions
+		if c.steps += times * iterations; c.steps > maxTemplateSteps {
+			return c.errorf(n, "template can run more than %d steps: use fewer or smaller ranges", maxTemplateSteps)
+		}
+
+		return c.checkBranch(&n.BranchNode, times*iterations, times)
+	}
+
+	return nil
+}
+
+func (c *templateChecker) checkBranch(b *parse.BranchNode, times, elseTimes int64) error {
+	if err := c.check(b.List, times); err != nil {
+		return err
+	}
+
+	return c.check(b.ElseList, elseTimes)
+}
+
+// rangeFuncs can be used on the pipeline of a range, as they return values of the vars or constants.
+var rangeFuncs = map[string]bool{
+	"index":   true,
+	"slice":   true,
+	"default": true,
+}
+
+// iterations of a range, at most: its largest constant, or the largest range of the vars.
+func (c *templateChecker) iterations(n parse.Node) (int64, error) {
+	var iterations = c.largest
+
+	var max = func(nn parse.Node) error {
+		i, err := c.iterations(nn)
+
+		if i > iterations {
+			iterations = i
+		}
+
+		return err
+	}
+
+	switch n := n.(type) {
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			if err := max(cmd); err != nil {
+				return 0, err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := max(arg); err != nil {
+				return 0, err
+			}
+		}
+	case *parse.ChainNode:
+		return c.iterations(n.Node)
+	case *parse.IdentifierNode:
+		if !rangeFuncs[n.Ident] {
+			return 0, c.errorf(n, "range can't use %s: use vars, numbers, index, slice, or default", n.Ident)
+		}
+	case *parse.NumberNode:
+		if n.IsInt && n.Int64 > iterations {
+			iterations = n.Int64
+		}
+	}
+
+	return iterations, nil
+}
+
+func (c *templateChecker) errorf(n parse.Node, format string, a ...interface{}) error {
+	var location, _ = c.tree.ErrorContext(n)
+	return fmt.Errorf("template: %s: %s", location, fmt.Sprintf(format, a...))
+}
+
+// largestRange of the value: the length of its largest collection, or its largest integer, and at least 1.
+func largestRange(v reflect.Value) int64 {
+	var largest int64 = 1
+
+	var max = func(n int64) {
+		if n > largest {
+			largest = n
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if !v.IsNil() {
+			max(largestRange(v.Elem()))
+		}
+	case reflect.Slice, reflect.Array:
+		max(int64(v.Len()))
+
+		for i := 0; i < v.Len(); i++ {
+			max(largestRange(v.Index(i)))
+		}
+	case reflect.Map:
+		max(int64(v.Len()))
+
+		var iter = v.MapRange()
+
+		for iter.Next() {
+			max(largestRange(iter.Value()))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			max(largestRange(v.Field(i)))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		max(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return math.MaxInt64
+		}
+
+		max(int64(v.Uint()))
+	}
+
+	return largest
+}
+
+func templateError(file string, err error) *ManifestError {
+	var me = &ManifestError{
+		File:    file,
+		Message: strings.TrimPrefix(err.Error(), "template: "),
+	}
+
+	var msg = strings.TrimPrefix(err.Error(), "template: "+file+":")
+
+	if m := templateErrorRegex.FindStringSubmatch(msg); m != nil {
+		me.Line, _ = strconv.Atoi(m[1])
+		me.Message = m[2]
+	}
+
+	return me
+}
+
+// saveRenderedTemplates on the recording.
+func (a *Apply) saveRenderedTemplates() error {
+	for _, f := range sortedKeys(a.rendered) {
+		if err := a.saveRendered(f, a.rendered[f]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
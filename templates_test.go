@@ -0,0 +1,231 @@
+package kubeapply
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const deploymentTemplate = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .name }}
+  labels: {{ toJSON .labels }}
+spec:
+  replicas: {{ index . "replicas" | default 1 }}
+  template:
+    spec:
+      containers:
+      - name: web
+        image: {{ printf "%s:%s" .image .tag | quote }}
+`
+
+func TestRenderTemplates(t *testing.T) {
+	var a = &Apply{
+		Vars: map[string]interface{}{
+			"name":   "web",
+			"labels": map[string]interface{}{"app": "web"},
+			"image":  "example/web",
+			"tag":    "v1.2.0",
+		},
+		Files: map[string][]byte{
+			"apps/web.tmpl.yaml": []byte(deploymentTemplate),
+			"service.yaml":       []byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n"),
+		},
+	}
+
+	var objects, err = a.CheckManifests()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var wantObjects = []Object{
+		{File: "service.yaml", Line: 1, APIVersion: "v1", Kind: "Service", Name: "web"},
+		{File: "rendered/apps/web.yaml", Line: 1, APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+	}
+
+	if !reflect.DeepEqual(objects, wantObjects) {
+		t.Errorf("Expected objects to be %+v, got %+v instead", wantObjects, objects)
+	}
+
+	var rendered = string(a.rendered["rendered/apps/web.yaml"])
+
+	for _, want := range []string{`labels: {"app":"web"}`, "replicas: 1\n", `image: "example/web:v1.2.0"`} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("Expected rendered template to contain %v, got %v instead", want, rendered)
+		}
+	}
+
+	var _, args = a.Command()
+	var wantArgs = []string{"apply", "--filename=rendered/apps/web.yaml", "--filename=service.yaml", "--output=json"}
+
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("Expected arguments to be %v, got %v instead", wantArgs, args)
+	}
+}
+
+var renderTemplatesErrorsCases = []struct {
+	name     string
+	template string
+	want     string
+}{
+	{
+		name:     "syntax",
+		template: "kind: ConfigMap\nmetadata:\n  name: {{ .name }\n",
+		want:     `web.tmpl.yaml:3: unexpected "}" in operand`,
+	},
+	{
+		name:     "missing variable",
+		template: "kind: ConfigMap\n\nmetadata:\n  name: {{ .nme }}\n",
+		want:     `web.tmpl.yaml:4: executing "web.tmpl.yaml" at <.nme>: map has no entry for key "nme"`,
+	},
+	{
+		name:     "unknown function",
+		template: `{{ env "HOME" }}`,
+		want:     `web.tmpl.yaml:1: function "env" not defined`,
+	},
+	{
+		name:     "required",
+		template: "\n{{ required \"tag is required\" .tag }}",
+		want:     "tag is required",
+	},
+	{
+		name:     "too large",
+		template: `{{ range .items }}{{ range $.items }}{{ printf "%100s" "" }}{{ end }}{{ end }}`,
+		want:     "rendered template is too large",
+	},
+	{
+		name:     "too many steps",
+		template: "\n{{ range .items }}{{ range $.items }}{{ range $.items }}{{ end }}{{ end }}{{ end }}",
+		want:     "web.tmpl.yaml:2: template can run more than 1000000 steps: use fewer or smaller ranges",
+	},
+	{
+		name:     "range over a large number",
+		template: `{{ range 100000000 }}{{ end }}`,
+		want:     "web.tmpl.yaml:1: template can run more than 1000000 steps",
+	},
+	{
+		name:     "range over a function",
+		template: `{{ range len .items }}{{ end }}`,
+		want:     "web.tmpl.yaml:1: range can't use len: use vars, numbers, index, slice, or default",
+	},
+	{
+		name:     "define",
+		template: `{{ define "a" }}{{ end }}`,
+		want:     "templates can't define other templates",
+	},
+	{
+		name:     "template",
+		template: `{{ template "web.tmpl.yaml" }}`,
+		want:     "web.tmpl.yaml:1: templates can't call other templates",
+	},
+	{
+		name:     "indent too large",
+		template: `{{ indent 256 (indent 256 (indent 256 (replace (printf "%100s" "") " " "\n" -1))) }}`,
+		want:     "rendered template is too large",
+	},
+	{
+		name:     "invalid indent",
+		template: `{{ indent -1 "a" }}`,
+		want:     "invalid indentation -1: use up to 256 spaces",
+	},
+	{
+		name:     "replace too large",
+		template: `{{ replace (printf "%1000s" "") " " (printf "%1000s" "") -1 }}`,
+		want:     "rendered template is too large",
+	},
+	{
+		name:     "invalid manifest",
+		template: "apiVersion: v1\nkind: ConfigMap\n",
+		want:     "rendered/web.yaml:1: ConfigMap is missing metadata.name",
+	},
+}
+
+func TestRenderTemplatesErrors(t *testing.T) {
+	for _, tt := range renderTemplatesErrorsCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var a = &Apply{
+				Vars: map[string]interface{}{
+					"name":  "web",
+					"tag":   "",
+					"items": make([]interface{}, 100),
+				},
+				Files:  map[string][]byte{"web.tmpl.yaml": []byte(tt.template)},
+				Limits: Limits{MaxFileSize: 1 << 16},
+			}
+
+			var _, err = a.CheckManifests()
+
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error to contain %q, got %v instead", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRenderTemplatesTimeout(t *testing.T) {
+	var defaultTimeout = templateTimeout
+	templateTimeout = time.Nanosecond
+
+	defer func() {
+		templateTimeout = defaultTimeout
+	}()
+
+	var a = &Apply{
+		Vars: map[string]interface{}{"items": make([]interface{}, 900)},
+		Files: map[string][]byte{
+			"web.tmpl.yaml": []byte(`{{ range .items }}{{ range $.items }}{{ end }}{{ end }}`),
+		},
+	}
+
+	var _, err = a.CheckManifests()
+
+	if want := "web.tmpl.yaml: rendering template timed out"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Expected error to contain %q, got %v instead", want, err)
+	}
+}
+
+func TestTemplatesWithoutVars(t *testing.T) {
+	var a = &Apply{
+		Files: map[string][]byte{
+			"web.tmpl.yaml": []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n"),
+		},
+	}
+
+	var objects, err = a.CheckManifests()
+
+	if err != nil || len(objects) != 1 || objects[0].File != "web.tmpl.yaml" {
+		t.Errorf("Expected templates to be used as manifests without vars, got (%+v, %v) instead", objects, err)
+	}
+}
+
+func TestRecordRenderedTemplates(t *testing.T) {
+	var configurationsDir = ConfigurationsDir
+	ConfigurationsDir = t.TempDir()
+	defer func() {
+		ConfigurationsDir = configurationsDir
+	}()
+
+	var a = &Apply{
+		Vars:       map[string]interface{}{"name": "web"},
+		Files:      map[string][]byte{"web.tmpl.yaml": []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .name }}\n")},
+		executable: "echo",
+	}
+
+	var resp, err = a.Run(context.Background())
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var b, ef = ioutil.ReadFile(filepath.Join(resp.Dir, "rendered", "web.yaml"))
+
+	if ef != nil || !strings.Contains(string(b), "name: web") {
+		t.Errorf("Expected rendered template to be recorded, got (%q, %v) instead", b, ef)
+	}
+}
@@ -0,0 +1,251 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ServiceName used on the exported resource.
+var ServiceName = "kubeapply"
+
+const scopeName = "github.com/henvic/kubeapply"
+
+// OTLP JSON encoding. See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+func encodeOTLP(spans []SpanData) otlpRequest {
+	var out = make([]otlpSpan, 0, len(spans))
+
+	for _, s := range spans {
+		var span = otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.Attributes),
+		}
+
+		if s.ParentSpanID.IsValid() {
+			span.ParentSpanID = s.ParentSpanID.String()
+		}
+
+		if s.Error != "" {
+			span.Status = otlpStatus{
+				Code:    statusCodeError,
+				Message: s.Error,
+			}
+		}
+
+		out = append(out, span)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: encodeAttributes(map[string]interface{}{
+						"service.name": ServiceName,
+					}),
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: scopeName},
+						Spans: out,
+					},
+				},
+			},
+		},
+	}
+}
+
+func encodeAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	var kvs = []otlpKeyValue{}
+
+	for k, v := range attributes {
+		var value = map[string]interface{}{}
+
+		switch vt := v.(type) {
+		case string:
+			value["stringValue"] = vt
+		case bool:
+			value["boolValue"] = vt
+		case int:
+			value["intValue"] = strconv.Itoa(vt)
+		case int64:
+			value["intValue"] = strconv.FormatInt(vt, 10)
+		case float64:
+			value["doubleValue"] = vt
+		default:
+			value["stringValue"] = fmt.Sprint(vt)
+		}
+
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: value})
+	}
+
+	return kvs
+}
+
+// OTLPExporter sends spans to an OTLP/HTTP collector using the JSON encoding.
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewOTLPExporter creates an exporter for the given collector endpoint.
+// The /v1/traces path is used if the endpoint has no path.
+func NewOTLPExporter(endpoint string) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: scheme must be http or https", endpoint)
+	}
+
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1/traces"
+	}
+
+	return &OTLPExporter{
+		endpoint: u.String(),
+		client:   &http.Client{},
+	}, nil
+}
+
+// Export spans.
+func (o *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	b, err := json.Marshal(encodeOTLP(spans))
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.endpoint, bytes.NewReader(b))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP collector responded with %v", resp.Status)
+	}
+
+	return nil
+}
+
+// Shutdown the exporter.
+func (o *OTLPExporter) Shutdown(ctx context.Context) error {
+	o.client.CloseIdleConnections()
+	return nil
+}
+
+// FileExporter appends spans to a file, one OTLP JSON request per line.
+type FileExporter struct {
+	f *os.File
+	m sync.Mutex
+}
+
+// NewFileExporter creates an exporter writing to the given file.
+func NewFileExporter(name string) (*FileExporter, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileExporter{f: f}, nil
+}
+
+// Export spans.
+func (fe *FileExporter) Export(ctx context.Context, spans []SpanData) error {
+	b, err := json.Marshal(encodeOTLP(spans))
+
+	if err != nil {
+		return err
+	}
+
+	fe.m.Lock()
+	defer fe.m.Unlock()
+
+	_, err = fe.f.Write(append(b, '\n'))
+	return err
+}
+
+// Shutdown the exporter.
+func (fe *FileExporter) Shutdown(ctx context.Context) error {
+	fe.m.Lock()
+	defer fe.m.Unlock()
+	return fe.f.Close()
+}
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Exporter of finished spans.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+const (
+	queueSize     = 2048
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+)
+
+// Tracer exports finished spans in batches.
+type Tracer struct {
+	exporter Exporter
+
+	queue chan SpanData
+	done  chan struct{}
+
+	closed bool
+	m      sync.RWMutex
+}
+
+// NewTracer creates a tracer exporting spans in the background.
+func NewTracer(e Exporter) *Tracer {
+	var t = &Tracer{
+		exporter: e,
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+
+	go t.loop()
+	return t
+}
+
+func (t *Tracer) enqueue(s SpanData) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	if t.closed {
+		return
+	}
+
+	select {
+	case t.queue <- s:
+	default:
+		log.Debugf("tracing queue is full: dropping span %v", s.Name)
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+
+	var ticker = time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				t.export(batch)
+				return
+			}
+
+			if batch = append(batch, s); len(batch) >= batchSize {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = nil
+		}
+	}
+}
+
+func (t *Tracer) export(batch []SpanData) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := t.exporter.Export(ctx, batch); err != nil {
+		log.Errorf("cannot export %d spans: %v", len(batch), err)
+	}
+}
+
+// Shutdown flushes the pending spans and shutdowns the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.m.Lock()
+
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+
+	t.m.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return t.exporter.Shutdown(ctx)
+}
+
+var (
+	defaultTracer *Tracer
+	defaultM      sync.RWMutex
+)
+
+// SetDefault tracer used to export spans. Use nil to disable exporting spans.
+func SetDefault(t *Tracer) {
+	defaultM.Lock()
+	defaultTracer = t
+	defaultM.Unlock()
+}
+
+// Default tracer.
+func Default() *Tracer {
+	defaultM.RLock()
+	defer defaultM.RUnlock()
+	return defaultTracer
+}
@@ -0,0 +1,294 @@
+// Package tracing records spans of work and propagates the W3C trace context.
+//
+// Spans are exported in the OpenTelemetry protocol (OTLP) JSON encoding,
+// either to an OTLP/HTTP collector or to a local file.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C Trace Context header.
+const TraceparentHeader = "traceparent"
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// IsValid checks if the trace ID is not all zeros.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span.
+type SpanID [8]byte
+
+// IsValid checks if the span ID is not all zeros.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext is the part of a span propagated across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid checks if both trace and span IDs are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent encodes the span context as a W3C traceparent value.
+func (sc SpanContext) Traceparent() string {
+	var flags = "00"
+
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent decodes a W3C traceparent value.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	var parts = strings.Split(strings.TrimSpace(s), "-")
+
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, errors.New("invalid traceparent format")
+	}
+
+	if _, err := hex.DecodeString(parts[0]); err != nil {
+		return sc, errors.New("invalid traceparent version")
+	}
+
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("invalid trace-id: %v", err)
+	}
+
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("invalid parent-id: %v", err)
+	}
+
+	var flags [1]byte
+
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, fmt.Errorf("invalid trace-flags: %v", err)
+	}
+
+	if !sc.IsValid() {
+		return sc, errors.New("invalid traceparent: all zeros ID")
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return errors.New("wrong length or case")
+	}
+
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// Span of work.
+type Span struct {
+	name   string
+	sc     SpanContext
+	parent SpanID
+
+	start time.Time
+	end   time.Time
+
+	attributes map[string]interface{}
+	err        string
+
+	tracer *Tracer
+
+	m sync.Mutex
+}
+
+// SpanContext of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.sc
+}
+
+// SetAttribute of the span. Value should be a string, bool, int, int64, or float64.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = map[string]interface{}{}
+	}
+
+	s.attributes[key] = value
+}
+
+// RecordError sets the span status to error.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.m.Lock()
+	s.err = err.Error()
+	s.m.Unlock()
+}
+
+// End the span and queue it for exporting.
+func (s *Span) End() {
+	s.m.Lock()
+
+	if !s.end.IsZero() {
+		s.m.Unlock()
+		return
+	}
+
+	s.end = time.Now()
+	var data = s.data()
+	s.m.Unlock()
+
+	if s.tracer != nil && s.sc.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+func (s *Span) data() SpanData {
+	var attributes = map[string]interface{}{}
+
+	for k, v := range s.attributes {
+		attributes[k] = v
+	}
+
+	return SpanData{
+		Name:         s.name,
+		TraceID:      s.sc.TraceID,
+		SpanID:       s.sc.SpanID,
+		ParentSpanID: s.parent,
+		Start:        s.start,
+		End:          s.end,
+		Attributes:   attributes,
+		Error:        s.err,
+	}
+}
+
+// SpanData is a finished span.
+type SpanData struct {
+	Name string
+
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+
+	Start time.Time
+	End   time.Time
+
+	Attributes map[string]interface{}
+	Error      string
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Start a span as a child of the span or remote span context found on ctx.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	var s = &Span{
+		name:   name,
+		start:  time.Now(),
+		tracer: Default(),
+	}
+
+	switch parent := parentSpanContext(ctx); {
+	case parent.IsValid():
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	default:
+		randomID(s.sc.TraceID[:])
+		s.sc.Sampled = true
+	}
+
+	randomID(s.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func parentSpanContext(ctx context.Context) SpanContext {
+	if s := FromContext(ctx); s != nil {
+		return s.sc
+	}
+
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+func randomID(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
+
+// FromContext returns the current span or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Traceparent value for the current span of the context, or an empty string.
+func Traceparent(ctx context.Context) string {
+	var sc = parentSpanContext(ctx)
+
+	if !sc.IsValid() {
+		return ""
+	}
+
+	return sc.Traceparent()
+}
+
+// ContextWithRemoteSpanContext returns a context with a span context received from another process.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Extract the W3C trace context from the HTTP headers.
+// Invalid values are ignored, and a new trace is started by the next span.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+
+	if err != nil {
+		return ctx
+	}
+
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Inject the W3C trace context into the HTTP headers.
+func Inject(ctx context.Context, h http.Header) {
+	if tp := Traceparent(ctx); tp != "" {
+		h.Set(TraceparentHeader, tp)
+	}
+}
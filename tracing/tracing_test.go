@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+var traceparentCases = []struct {
+	name    string
+	in      string
+	sampled bool
+	valid   bool
+}{
+	{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+	{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, true},
+	{"empty", "", false, false},
+	{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+	{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+	{"zero trace-id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+	{"zero parent-id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+	{"short trace-id", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, false},
+	{"extra fields on version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x", false, false},
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tt := range traceparentCases {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.in)
+
+			if (err == nil) != tt.valid {
+				t.Fatalf("Expected valid = %v, got error %v instead", tt.valid, err)
+			}
+
+			if !tt.valid {
+				return
+			}
+
+			if sc.Sampled != tt.sampled || sc.Traceparent() != tt.in {
+				t.Errorf("Expected %v (sampled = %v), got %v (sampled = %v) instead",
+					tt.in, tt.sampled, sc.Traceparent(), sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestStartPropagation(t *testing.T) {
+	var h = http.Header{}
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	var ctx = Extract(context.Background(), h)
+	ctx, parent := Start(ctx, "parent")
+	_, child := Start(ctx, "child")
+
+	if got := parent.SpanContext().TraceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected trace ID to be propagated, got %v instead", got)
+	}
+
+	if child.SpanContext().TraceID != parent.SpanContext().TraceID || child.parent != parent.SpanContext().SpanID {
+		t.Errorf("Expected child span to be linked to its parent")
+	}
+
+	var out = http.Header{}
+	Inject(ctx, out)
+
+	if out.Get(TraceparentHeader) != parent.SpanContext().Traceparent() {
+		t.Errorf("Expected injected traceparent to be %v, got %v instead",
+			parent.SpanContext().Traceparent(), out.Get(TraceparentHeader))
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	var name = filepath.Join(t.TempDir(), "traces.jsonl")
+	fe, err := NewFileExporter(name)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var tracer = NewTracer(fe)
+	SetDefault(tracer)
+	defer SetDefault(nil)
+
+	_, s := Start(context.Background(), "work")
+	s.SetAttribute("exit_code", 2)
+	s.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(name)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var r otlpRequest
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var spans = r.ResourceSpans[0].ScopeSpans[0].Spans
+
+	if len(spans) != 1 || spans[0].Name != "work" || spans[0].TraceID != s.SpanContext().TraceID.String() {
+		t.Errorf("Unexpected exported spans: %+v", spans)
+	}
+}
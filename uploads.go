@@ -0,0 +1,165 @@
+package kubeapply
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxPathLength of uploaded files, in bytes.
+	maxPathLength = 1024
+
+	// maxNameLength of each component of the path of uploaded files, in bytes.
+	maxNameLength = 255
+)
+
+// CleanUploadPath returns the canonical form of the path of an uploaded file,
+// or an error if it is unsafe to write it on the recording directory.
+func CleanUploadPath(name string) (string, error) {
+	if err := checkUploadPath(name); err != nil {
+		return "", fmt.Errorf(`refusing to apply: unsafe filepath "%s": %v`, name, err)
+	}
+
+	return path.Clean(name), nil
+}
+
+func checkUploadPath(name string) error {
+	if name == "" {
+		return errors.New("empty path")
+	}
+
+	if len(name) > maxPathLength {
+		return fmt.Errorf("path longer than %d bytes", maxPathLength)
+	}
+
+	for _, c := range name {
+		switch {
+		case c == '\\':
+			return errors.New("backslashes are not allowed")
+		case c < 0x20 || c == 0x7f:
+			return errors.New("control characters are not allowed")
+		}
+	}
+
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || hasDriveLetter(name) {
+		return errors.New("absolute paths are not allowed")
+	}
+
+	for _, p := range strings.Split(name, "/") {
+		if p == ".." {
+			return errors.New("parent directory references are not allowed")
+		}
+
+		if len(p) > maxNameLength {
+			return fmt.Errorf("file name longer than %d bytes", maxNameLength)
+		}
+	}
+
+	var clean = path.Clean(name)
+
+	if clean == "." {
+		return errors.New("empty path")
+	}
+
+	var top = strings.SplitN(clean, "/", 2)[0]
+
+	if _, reserved := blacklist[strings.ToLower(top)]; reserved {
+		return fmt.Errorf(`"%s" is reserved`, top)
+	}
+
+	return nil
+}
+
+func hasDriveLetter(name string) bool {
+	return len(name) >= 2 && name[1] == ':' &&
+		(name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z')
+}
+
+// CheckUploads verifies the uploaded files are safe to write on the recording directory and within the limits.
+// Paths are compared case-insensitively, as some filesystems are case-insensitive.
+func (a *Apply) CheckUploads() error {
+	var (
+		files = map[string]string{}
+		dirs  = map[string]string{}
+	)
+
+	for _, f := range a.listFiles() {
+		var clean, err = CleanUploadPath(f)
+
+		if err != nil {
+			return err
+		}
+
+		var key = strings.ToLower(clean)
+
+		if other, ok := files[key]; ok {
+			return fmt.Errorf(`refusing to apply: filepath "%s" collides with "%s"`, f, other)
+		}
+
+		files[key] = f
+
+		for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = f
+		}
+	}
+
+	for key, f := range files {
+		if other, ok := dirs[key]; ok {
+			return fmt.Errorf(`refusing to apply: filepath "%s" collides with directory of "%s"`, f, other)
+		}
+	}
+
+	return a.Limits.Check(a.Files)
+}
+
+// writeUpload on the directory, creating the parent directories.
+// Existing files and symbolic links are never followed or overwritten.
+func writeUpload(dir, name string, b []byte, mode os.FileMode) error {
+	var parts = strings.Split(name, "/")
+
+	for _, p := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, p)
+
+		if err := os.Mkdir(dir, dirFileMode); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+		var fi, err = os.Lstat(dir)
+
+		if err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+
+	return writeNewFile(filepath.Join(dir, parts[len(parts)-1]), b, mode)
+}
+
+// writeNewFile fails if the file already exists, even as a symbolic link.
+// The mode is set regardless of the umask.
+func writeNewFile(name string, b []byte, mode os.FileMode) error {
+	var f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+
+	if err != nil {
+		return err
+	}
+
+	if err = f.Chmod(mode); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,205 @@
+package kubeapply
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var cleanUploadPathCases = []struct {
+	in   string
+	want string
+	fail bool
+}{
+	{in: "a.yaml", want: "a.yaml"},
+	{in: "./a.yaml", want: "a.yaml"},
+	{in: "sub//dir/./a.yaml", want: "sub/dir/a.yaml"},
+	{in: "a..b.yaml", want: "a..b.yaml"},
+	{in: "sub/request", want: "sub/request"},
+	{in: "", fail: true},
+	{in: ".", fail: true},
+	{in: "./", fail: true},
+	{in: "request", fail: true},
+	{in: "./request", fail: true},
+	{in: "Request", fail: true},
+	{in: "response/x", fail: true},
+	{in: "sub/../request", fail: true},
+	{in: "../a.yaml", fail: true},
+	{in: "/etc/passwd", fail: true},
+	{in: "C:/a.yaml", fail: true},
+	{in: `sub\a.yaml`, fail: true},
+	{in: "a\x00.yaml", fail: true},
+	{in: "a\n.yaml", fail: true},
+	{in: strings.Repeat("a", 256), fail: true},
+	{in: strings.Repeat("a/", 600), fail: true},
+}
+
+func TestCleanUploadPath(t *testing.T) {
+	for _, tt := range cleanUploadPathCases {
+		var got, err = CleanUploadPath(tt.in)
+
+		if tt.fail != (err != nil) {
+			t.Errorf("Expected CleanUploadPath(%q) to fail = %v, got %v instead", tt.in, tt.fail, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("Expected CleanUploadPath(%q) = %q, got %q instead", tt.in, tt.want, got)
+		}
+	}
+}
+
+var checkUploadsCases = []struct {
+	files []string
+	fail  bool
+}{
+	{files: []string{"a.yaml", "sub/a.yaml"}},
+	{files: []string{"a.yaml", "./a.yaml"}, fail: true},
+	{files: []string{"a.yaml", "A.yaml"}, fail: true},
+	{files: []string{"sub", "sub/a.yaml"}, fail: true},
+	{files: []string{"Sub", "sub/a.yaml"}, fail: true},
+	{files: []string{"sub/x", "sub/x/a.yaml"}, fail: true},
+	{files: []string{"description"}, fail: true},
+}
+
+func TestCheckUploads(t *testing.T) {
+	for _, tt := range checkUploadsCases {
+		var a = Apply{Files: map[string][]byte{}}
+
+		for _, f := range tt.files {
+			a.Files[f] = nil
+		}
+
+		if err := a.CheckUploads(); tt.fail != (err != nil) {
+			t.Errorf("Expected CheckUploads() for %v to fail = %v, got %v instead", tt.files, tt.fail, err)
+		}
+	}
+}
+
+func TestWriteUploadSymlink(t *testing.T) {
+	var dir = t.TempDir()
+	var outside = t.TempDir()
+
+	if err := os.Symlink(outside, filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join(outside, "b.yaml"), filepath.Join(dir, "b.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeUpload(dir, "sub/a.yaml", []byte("x"), fileMode); err == nil {
+		t.Errorf("Expected error writing through a symbolic link to a directory")
+	}
+
+	if err := writeUpload(dir, "b.yaml", []byte("x"), fileMode); err == nil {
+		t.Errorf("Expected error writing to a symbolic link")
+	}
+
+	if files, _ := ioutil.ReadDir(outside); len(files) != 0 {
+		t.Errorf("Expected no files to be written outside of the directory, got %v instead", files)
+	}
+}
+
+func TestCopyConfigurationFilesModes(t *testing.T) {
+	var a = &Apply{
+		Files: map[string][]byte{
+			"run.sh":         []byte("#!/bin/sh\n"),
+			"secret/key.pem": []byte("key"),
+			"app.yaml":       []byte(""),
+		},
+		Modes: map[string]os.FileMode{
+			"run.sh":         0755,
+			"secret/key.pem": os.ModeSetuid | 0600,
+		},
+		dir: t.TempDir(),
+	}
+
+	if err := a.copyConfigurationFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	var want = map[string]os.FileMode{
+		"run.sh":         0755,
+		"secret/key.pem": 0600,
+		"app.yaml":       fileMode,
+	}
+
+	for f, m := range want {
+		fi, err := os.Stat(filepath.Join(a.dir, f))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Mode() != m {
+			t.Errorf("Expected %s to have mode %v, got %v instead", f, m, fi.Mode())
+		}
+	}
+}
+
+func FuzzCleanUploadPath(f *testing.F) {
+	for _, tt := range cleanUploadPathCases {
+		f.Add(tt.in)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		var clean, err = CleanUploadPath(name)
+
+		if err != nil {
+			return
+		}
+
+		if clean != path.Clean(clean) || path.IsAbs(clean) || clean == "." {
+			t.Errorf("CleanUploadPath(%q) = %q is not a clean relative path", name, clean)
+		}
+
+		if strings.ContainsAny(clean, "\\\x00") {
+			t.Errorf("CleanUploadPath(%q) = %q has unsafe characters", name, clean)
+		}
+
+		for _, p := range strings.Split(clean, "/") {
+			if p == ".." {
+				t.Errorf("CleanUploadPath(%q) = %q escapes the directory", name, clean)
+			}
+		}
+
+		var root = filepath.FromSlash("/recording")
+
+		if file := filepath.Join(root, clean); !strings.HasPrefix(file, root+string(filepath.Separator)) {
+			t.Errorf("CleanUploadPath(%q) = %q is written outside of the directory: %v", name, clean, file)
+		}
+
+		if _, reserved := blacklist[strings.ToLower(strings.SplitN(clean, "/", 2)[0])]; reserved {
+			t.Errorf("CleanUploadPath(%q) = %q collides with a reserved name", name, clean)
+		}
+	})
+}
+
+func FuzzCheckUploads(f *testing.F) {
+	f.Add("a.yaml", "A.yaml")
+	f.Add("sub", "sub/a.yaml")
+	f.Add("a.yaml", "./a.yaml")
+
+	f.Fuzz(func(t *testing.T, a, b string) {
+		if a == b {
+			return
+		}
+
+		var apply = Apply{Files: map[string][]byte{a: nil, b: nil}}
+
+		if apply.CheckUploads() != nil {
+			return
+		}
+
+		var ca, _ = CleanUploadPath(a)
+		var cb, _ = CleanUploadPath(b)
+		ca, cb = strings.ToLower(ca), strings.ToLower(cb)
+
+		if ca == cb || strings.HasPrefix(ca, cb+"/") || strings.HasPrefix(cb, ca+"/") {
+			t.Errorf("Expected %q and %q to collide", a, b)
+		}
+	})
+}